@@ -6,6 +6,8 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"strconv"
+	"time"
 
 	"github.com/google/go-jsonnet/formatter"
 	"github.com/grafana/jsonnet-language-server/pkg/server"
@@ -41,6 +43,13 @@ Options:
   -l / --log-level   Set the log level (default: info).
   --eval-diags       Try to evaluate files to find errors and warnings.
   --lint             Enable linting.
+  --eval-inlay-hints Show the evaluated value of top-level locals as inlay hints.
+  --eval-timeout <duration>
+                     Abort evaluations after this duration (default: 30s).
+  --max-stack <n>    Maximum stack depth of evaluations (default: 500).
+  --diagnostics-debounce <duration>
+                     Wait for this long without changes before diagnosing
+                     a document (default: 300ms).
   -v / --version     Print version.
 
 Environment variables:
@@ -83,8 +92,28 @@ func main() {
 			config.EnableLintDiagnostics = true
 		case "--eval-diags":
 			config.EnableEvalDiagnostics = true
+		case "--eval-inlay-hints":
+			config.EnableEvalInlayHints = true
 		case "--show-docstrings":
 			config.ShowDocstringInCompletion = true
+		case "--eval-timeout":
+			timeout, err := time.ParseDuration(getArgValue(i))
+			if err != nil {
+				log.Fatalf("Invalid evaluation timeout: %s", err)
+			}
+			config.EvalTimeout = timeout
+		case "--max-stack":
+			maxStack, err := strconv.Atoi(getArgValue(i))
+			if err != nil {
+				log.Fatalf("Invalid max stack: %s", err)
+			}
+			config.MaxStack = maxStack
+		case "--diagnostics-debounce":
+			debounce, err := time.ParseDuration(getArgValue(i))
+			if err != nil {
+				log.Fatalf("Invalid diagnostics debounce: %s", err)
+			}
+			config.DiagnosticsDebounce = debounce
 		}
 	}
 
@@ -93,12 +122,12 @@ func main() {
 	ctx := context.Background()
 	stream := jsonrpc2.NewHeaderStream(utils.NewDefaultStdio())
 	conn := jsonrpc2.NewConn(stream)
-	client := protocol.ClientDispatcher(conn)
+	client := server.NewClient(conn)
 
 	s := server.NewServer(name, version, client, config)
 
 	conn.Go(ctx, protocol.Handlers(
-		protocol.ServerHandler(s, jsonrpc2.MethodNotFound)))
+		server.NewHandler(s, jsonrpc2.MethodNotFound)))
 	<-conn.Done()
 	if err := conn.Err(); err != nil {
 		fmt.Fprintln(os.Stderr, err)
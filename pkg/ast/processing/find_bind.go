@@ -26,3 +26,32 @@ func FindBindByIDViaStack(stack *nodestack.NodeStack, id ast.Identifier) *ast.Lo
 	}
 	return nil
 }
+
+// FindVarDefinitionViaStack finds where the variable with the given ID is defined, as seen from the top of the stack.
+// Unlike FindBindByIDViaStack and FindParameterByIDViaStack, the innermost definition wins,
+// so shadowed locals and parameters are resolved the same way Jsonnet resolves them.
+func FindVarDefinitionViaStack(stack *nodestack.NodeStack, id ast.Identifier) *ObjectRange {
+	for i := len(stack.Stack) - 1; i >= 0; i-- {
+		var binds ast.LocalBinds
+		switch curr := stack.Stack[i].(type) {
+		case *ast.Local:
+			binds = curr.Binds
+		case *ast.DesugaredObject:
+			binds = curr.Locals
+		case *ast.Function:
+			for _, param := range curr.Parameters {
+				if param.Name == id && param.LocRange.Begin.IsSet() {
+					objectRange := ParameterToRange(param)
+					return &objectRange
+				}
+			}
+		}
+		for _, bind := range binds {
+			if bind.Variable == id {
+				objectRange := LocalBindToRange(bind)
+				return &objectRange
+			}
+		}
+	}
+	return nil
+}
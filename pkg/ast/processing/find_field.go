@@ -67,7 +67,7 @@ func (p *Processor) FindRangesFromIndexList(stack *nodestack.NodeStack, indexLis
 			foundDesugaredObjects = p.FindTopLevelObjects(tmpStack)
 		case *ast.Import:
 			filename := bodyNode.File.Value
-			foundDesugaredObjects = p.FindTopLevelObjectsInFile(filename, "")
+			foundDesugaredObjects = p.FindTopLevelObjectsInFile(filename, string(bodyNode.Loc().File.DiagnosticFileName))
 		case *ast.Index, *ast.Apply:
 			tempStack := nodestack.NewNodeStack(bodyNode)
 			indexList = append(tempStack.BuildIndexList(), indexList...)
@@ -312,39 +312,23 @@ func (p *Processor) findSelfObject(self *ast.Self) *ast.DesugaredObject {
 	return nil
 }
 
-// findUsagesVisitor creates a visitor function that finds all usages of a given symbol
+// findUsagesVisitor creates a visitor function that finds all variables and indexes named after a given symbol
 func (p *Processor) findUsagesVisitor(symbolID ast.Identifier, symbol string, ranges *[]ObjectRange) func(node ast.Node) {
 	return func(node ast.Node) {
 		switch node := node.(type) {
 		case *ast.Var:
 			// For variables, check if the ID matches
 			if node.Id == symbolID {
-				*ranges = append(*ranges, ObjectRange{
-					Filename:       node.LocRange.FileName,
-					SelectionRange: node.LocRange,
-					FullRange:      node.LocRange,
-				})
+				*ranges = append(*ranges, usageToRange(node))
 			}
 		case *ast.Index:
 			// For field access, check if the index matches
-			if litStr, ok := node.Index.(*ast.LiteralString); ok {
-				if litStr.Value == symbol {
-					*ranges = append(*ranges, ObjectRange{
-						Filename:       node.LocRange.FileName,
-						SelectionRange: node.LocRange,
-						FullRange:      node.LocRange,
-					})
-				}
+			if litStr, ok := node.Index.(*ast.LiteralString); ok && litStr.Value == symbol {
+				*ranges = append(*ranges, usageToRange(node))
 			}
-		case *ast.Apply:
-			if litStr, ok := node.Target.(*ast.LiteralString); ok {
-				if litStr.Value == symbol {
-					*ranges = append(*ranges, ObjectRange{
-						Filename:       node.LocRange.FileName,
-						SelectionRange: node.LocRange,
-						FullRange:      node.LocRange,
-					})
-				}
+		case *ast.SuperIndex:
+			if litStr, ok := node.Index.(*ast.LiteralString); ok && litStr.Value == symbol {
+				*ranges = append(*ranges, usageToRange(node))
 			}
 		}
 
@@ -374,6 +358,12 @@ func (p *Processor) findUsagesVisitor(symbolID ast.Identifier, symbol string, ra
 				p.findUsagesVisitor(symbolID, symbol, ranges)(field.Name)
 				p.findUsagesVisitor(symbolID, symbol, ranges)(field.Body)
 			}
+			for _, local := range node.Locals {
+				p.findUsagesVisitor(symbolID, symbol, ranges)(local.Body)
+			}
+			for _, assert := range node.Asserts {
+				p.findUsagesVisitor(symbolID, symbol, ranges)(assert)
+			}
 		case *ast.Error:
 			p.findUsagesVisitor(symbolID, symbol, ranges)(node.Expr)
 		case *ast.Function:
@@ -406,22 +396,108 @@ func (p *Processor) findUsagesVisitor(symbolID ast.Identifier, symbol string, ra
 	}
 }
 
-// FindUsages finds all usages of a symbol in the given files
-func (p *Processor) FindUsages(files []string, symbol string) ([]ObjectRange, error) {
-	var ranges []ObjectRange
-	symbolID := ast.Identifier(symbol)
+func usageToRange(node ast.Node) ObjectRange {
+	return ObjectRange{
+		Filename:       node.Loc().FileName,
+		SelectionRange: *node.Loc(),
+		FullRange:      *node.Loc(),
+		Node:           node,
+	}
+}
 
-	// Create a visitor to find all usages
-	visitor := p.findUsagesVisitor(symbolID, symbol, &ranges)
+// FindUsages finds all usages of a symbol in the given files.
+// Usages are resolved semantically: a variable is a usage if it is bound to one of the given definitions
+// and an index is a usage if it resolves to one of the given field definitions.
+// Since fields can be overridden (`field+:`), definitions that share usages with the given ones are also returned.
+func (p *Processor) FindUsages(files []string, symbol string, definitions []ObjectRange) (usages []ObjectRange, allDefinitions []ObjectRange, err error) {
+	type candidate struct {
+		usage       ObjectRange
+		definitions []ObjectRange
+		matched     bool
+	}
 
-	// Process each file
+	var candidates []candidate
 	for _, file := range files {
-		rootNode, _, err := p.vm.ImportAST("", file)
+		rootNode, err := p.GetAST(file)
 		if err != nil {
-			return nil, fmt.Errorf("failed to import AST for file %s: %w", file, err)
+			log.Errorf("FindUsages: failed to import AST for file %s: %v", file, err)
+			continue
+		}
+
+		var ranges []ObjectRange
+		p.findUsagesVisitor(ast.Identifier(symbol), symbol, &ranges)(rootNode)
+		for _, r := range ranges {
+			if resolved := p.resolveUsage(rootNode, r.Node); len(resolved) > 0 {
+				candidates = append(candidates, candidate{usage: r, definitions: resolved})
+			}
 		}
-		visitor(rootNode)
 	}
 
-	return ranges, nil
+	// A usage can resolve to multiple definitions (`field+:` overrides) and a definition can be overridden,
+	// so the set of definitions is expanded until all usages that refer to it are found
+	allDefinitions = append(allDefinitions, definitions...)
+	for changed := true; changed; {
+		changed = false
+		for i, c := range candidates {
+			if c.matched || !anySameDefinition(c.definitions, allDefinitions) {
+				continue
+			}
+			candidates[i].matched = true
+			for _, definition := range c.definitions {
+				if !anySameDefinition([]ObjectRange{definition}, allDefinitions) {
+					allDefinitions = append(allDefinitions, definition)
+					changed = true
+				}
+			}
+		}
+	}
+
+	for _, c := range candidates {
+		if c.matched {
+			usages = append(usages, c.usage)
+		}
+	}
+
+	return usages, allDefinitions, nil
+}
+
+// resolveUsage finds the definitions of a variable or an index node
+func (p *Processor) resolveUsage(rootNode, node ast.Node) []ObjectRange {
+	stack, err := FindParentsByNode(rootNode, node)
+	if err != nil {
+		return nil
+	}
+
+	switch node := node.(type) {
+	case *ast.Var:
+		if definition := FindVarDefinitionViaStack(stack, node.Id); definition != nil {
+			return []ObjectRange{*definition}
+		}
+	case *ast.Index, *ast.SuperIndex:
+		ranges, err := p.FindRangesFromIndexList(stack, nodestack.NewNodeStack(node).BuildIndexList(), false)
+		if err != nil {
+			log.Debugf("FindUsages: could not resolve index: %v", err)
+			return nil
+		}
+		var fieldRanges []ObjectRange
+		for _, r := range ranges {
+			// Indexes of parameters resolve to the parameter itself
+			if r.FieldName != "" {
+				fieldRanges = append(fieldRanges, r)
+			}
+		}
+		return fieldRanges
+	}
+	return nil
+}
+
+func anySameDefinition(candidates, definitions []ObjectRange) bool {
+	for _, candidate := range candidates {
+		for _, definition := range definitions {
+			if IsSameDefinition(candidate, definition) {
+				return true
+			}
+		}
+	}
+	return false
 }
@@ -63,3 +63,18 @@ func FindNodeByPosition(node ast.Node, location ast.Location) (*nodestack.NodeSt
 	}
 	return searchStack.ReorderDesugaredObjects(), nil
 }
+
+// FindParentsByNode finds the stack of nodes enclosing the given node, which must be part of the tree under root.
+// The node itself is not part of the returned stack.
+func FindParentsByNode(root, node ast.Node) (*nodestack.NodeStack, error) {
+	stack, err := FindNodeByPosition(root, node.Loc().Begin)
+	if err != nil {
+		return nil, err
+	}
+	for !stack.IsEmpty() {
+		if stack.Pop() == node {
+			return stack, nil
+		}
+	}
+	return nil, errors.New("node was not found at its location")
+}
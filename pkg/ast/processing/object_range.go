@@ -5,7 +5,8 @@ import (
 	"strings"
 
 	"github.com/google/go-jsonnet/ast"
-	position "github.com/grafana/jsonnet-language-server/pkg/position_conversion"
+	"github.com/grafana/jsonnet-language-server/pkg/cache"
+	"github.com/grafana/jsonnet-language-server/pkg/utils"
 	"github.com/jdbaldry/go-language-server-protocol/lsp/protocol"
 )
 
@@ -37,6 +38,14 @@ func (p *Processor) FieldToRange(field ast.DesugaredObjectField) ObjectRange {
 	}
 }
 
+// IsSameDefinition returns true if both ranges point to the same definition.
+// The ranges may come from different parses of the same file, so nodes are not compared
+func IsSameDefinition(a, b ObjectRange) bool {
+	return utils.AbsFilename(a.Filename) == utils.AbsFilename(b.Filename) &&
+		a.FullRange.Begin == b.FullRange.Begin &&
+		a.SelectionRange.Begin == b.SelectionRange.Begin
+}
+
 func (p *Processor) FieldNameToString(fieldName ast.Node) string {
 	const unknown = "<unknown>"
 
@@ -61,7 +70,13 @@ func (p *Processor) FieldNameToString(fieldName ast.Node) string {
 			return unknown
 		}
 
-		content, err := p.cache.GetContents(protocol.URIFromPath(fname), position.RangeASTToProtocol(*loc))
+		var content string
+		var err error
+		if fname == p.filename {
+			content, err = cache.TextInRange(p.text, *loc)
+		} else {
+			content, err = p.cache.GetContents(protocol.URIFromPath(fname), *loc)
+		}
 		if err != nil {
 			return unknown
 		}
@@ -90,3 +105,102 @@ func LocalBindToRange(bind ast.LocalBind) ObjectRange {
 		},
 	}
 }
+
+func ParameterToRange(param ast.Parameter) ObjectRange {
+	return ObjectRange{
+		Filename:  param.LocRange.FileName,
+		FullRange: param.LocRange,
+		SelectionRange: ast.LocationRange{
+			FileName: param.LocRange.FileName,
+			Begin:    param.LocRange.Begin,
+			End: ast.Location{
+				Line:   param.LocRange.Begin.Line,
+				Column: param.LocRange.Begin.Column + len(param.Name),
+			},
+		},
+	}
+}
+
+// FieldNameRange returns the range of the name of a field, without quotes or brackets.
+// It returns false if the field name is computed and cannot be located.
+func FieldNameRange(field ast.DesugaredObjectField) (ast.LocationRange, bool) {
+	name, ok := field.Name.(*ast.LiteralString)
+	if !ok {
+		return ast.LocationRange{}, false
+	}
+	if !name.LocRange.Begin.IsSet() {
+		// Identifier field names (`foo: ...`) have no location, they start the field
+		return ast.LocationRange{
+			FileName: field.LocRange.FileName,
+			Begin:    field.LocRange.Begin,
+			End: ast.Location{
+				Line:   field.LocRange.Begin.Line,
+				Column: field.LocRange.Begin.Column + len(name.Value),
+			},
+		}, true
+	}
+	return unquotedStringRange(name)
+}
+
+// IndexNameRange returns the range of the indexed field name in `foo.bar`, `foo['bar']` or `super.bar`.
+// It returns false if the index is computed and cannot be located.
+func IndexNameRange(node ast.Node) (ast.LocationRange, bool) {
+	switch node := node.(type) {
+	case *ast.Index:
+		name, ok := node.Index.(*ast.LiteralString)
+		if !ok {
+			return ast.LocationRange{}, false
+		}
+		if !name.LocRange.Begin.IsSet() {
+			// `foo.bar`: the field name ends the index
+			return ast.LocationRange{
+				FileName: node.LocRange.FileName,
+				Begin: ast.Location{
+					Line:   node.LocRange.End.Line,
+					Column: node.LocRange.End.Column - len(name.Value),
+				},
+				End: node.LocRange.End,
+			}, true
+		}
+		return unquotedStringRange(name)
+	case *ast.SuperIndex:
+		name, ok := node.Index.(*ast.LiteralString)
+		if !ok {
+			return ast.LocationRange{}, false
+		}
+		if !name.LocRange.Begin.IsSet() {
+			// `super.bar`: the location only spans `super`. Its end is not reliable (see FindNodeByPosition), so use the start
+			begin := ast.Location{
+				Line:   node.LocRange.Begin.Line,
+				Column: node.LocRange.Begin.Column + len("super."),
+			}
+			return ast.LocationRange{
+				FileName: node.LocRange.FileName,
+				Begin:    begin,
+				End:      ast.Location{Line: begin.Line, Column: begin.Column + len(name.Value)},
+			}, true
+		}
+		return unquotedStringRange(name)
+	}
+	return ast.LocationRange{}, false
+}
+
+func unquotedStringRange(str *ast.LiteralString) (ast.LocationRange, bool) {
+	var quoteLength int
+	switch str.Kind {
+	case ast.StringSingle, ast.StringDouble:
+		quoteLength = 1
+	case ast.VerbatimStringSingle, ast.VerbatimStringDouble:
+		quoteLength = 2
+	default:
+		return ast.LocationRange{}, false
+	}
+	if str.LocRange.Begin.Line != str.LocRange.End.Line {
+		return ast.LocationRange{}, false
+	}
+	return ast.LocationRange{
+		FileName: str.LocRange.FileName,
+		Begin:    ast.Location{Line: str.LocRange.Begin.Line, Column: str.LocRange.Begin.Column + quoteLength},
+		End:      ast.Location{Line: str.LocRange.End.Line, Column: str.LocRange.End.Column - 1},
+	}, true
+}
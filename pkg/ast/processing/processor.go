@@ -2,12 +2,18 @@ package processing
 
 import (
 	"github.com/google/go-jsonnet"
+	"github.com/google/go-jsonnet/ast"
 	"github.com/grafana/jsonnet-language-server/pkg/cache"
+	"github.com/jdbaldry/go-language-server-protocol/lsp/protocol"
 )
 
 type Processor struct {
 	cache *cache.Cache
 	vm    *jsonnet.VM
+
+	// filename and text are read instead of the cache, see WithText
+	filename string
+	text     string
 }
 
 func NewProcessor(cache *cache.Cache, vm *jsonnet.VM) *Processor {
@@ -16,3 +22,18 @@ func NewProcessor(cache *cache.Cache, vm *jsonnet.VM) *Processor {
 		vm:    vm,
 	}
 }
+
+// WithText returns a processor reading the text of a file from the given text rather than from the cache.
+// It's used for files that aren't open, so that their text isn't kept in the cache
+func (p *Processor) WithText(filename, text string) *Processor {
+	return &Processor{cache: p.cache, vm: p.vm, filename: filename, text: text}
+}
+
+// GetAST returns the AST of a file. Open documents are read from the cache, so that positions match the editor's content
+func (p *Processor) GetAST(filename string) (ast.Node, error) {
+	if doc, err := p.cache.Get(protocol.URIFromPath(filename)); err == nil && doc.AST != nil && len(doc.LinesChangedSinceAST) == 0 {
+		return doc.AST, nil
+	}
+	rootNode, _, err := p.vm.ImportAST("", filename)
+	return rootNode, err
+}
@@ -9,9 +9,10 @@ import (
 func (p *Processor) FindTopLevelObjectsInFile(filename, importedFrom string) []*ast.DesugaredObject {
 	v, ok := p.cache.GetTopLevelObject(filename, importedFrom)
 	if !ok {
-		rootNode, _, _ := p.vm.ImportAST(importedFrom, filename)
+		rootNode, foundAt, _ := p.vm.ImportAST(importedFrom, filename)
+		p.cache.AddImport(importedFrom, foundAt)
 		v = p.FindTopLevelObjects(nodestack.NewNodeStack(rootNode))
-		p.cache.PutTopLevelObject(filename, importedFrom, v)
+		p.cache.PutTopLevelObject(filename, importedFrom, foundAt, v)
 	}
 	return v
 }
@@ -31,7 +32,9 @@ func (p *Processor) FindTopLevelObjects(stack *nodestack.NodeStack) []*ast.Desug
 			stack.Push(curr.Body)
 		case *ast.Import:
 			filename := curr.File.Value
-			rootNode, _, _ := p.vm.ImportAST(string(curr.Loc().File.DiagnosticFileName), filename)
+			importedFrom := string(curr.Loc().File.DiagnosticFileName)
+			rootNode, foundAt, _ := p.vm.ImportAST(importedFrom, filename)
+			p.cache.AddImport(importedFrom, foundAt)
 			stack.Push(rootNode)
 		case *ast.Index:
 			indexValue, indexIsString := curr.Index.(*ast.LiteralString)
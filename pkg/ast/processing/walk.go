@@ -0,0 +1,29 @@
+package processing
+
+import (
+	"github.com/google/go-jsonnet/ast"
+	"github.com/google/go-jsonnet/toolutils"
+)
+
+// Walk calls visit for the node and its descendants, depth first in the order of the tree.
+// The children of a node are skipped when visit returns false
+func Walk(node ast.Node, visit func(node ast.Node) bool) {
+	if node == nil || !visit(node) {
+		return
+	}
+	for _, child := range toolutils.Children(node) {
+		Walk(child, visit)
+	}
+}
+
+// FindNodes returns the nodes of the tree that have a location and match the predicate, depth first
+func FindNodes(root ast.Node, predicate func(node ast.Node) bool) []ast.Node {
+	var nodes []ast.Node
+	Walk(root, func(node ast.Node) bool {
+		if loc := node.Loc(); loc != nil && loc.Begin.IsSet() && predicate(node) {
+			nodes = append(nodes, node)
+		}
+		return true
+	})
+	return nodes
+}
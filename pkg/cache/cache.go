@@ -8,6 +8,7 @@ import (
 	"sync"
 
 	"github.com/google/go-jsonnet/ast"
+	"github.com/grafana/jsonnet-language-server/pkg/utils"
 	"github.com/jdbaldry/go-language-server-protocol/lsp/protocol"
 )
 
@@ -23,13 +24,24 @@ type Document struct {
 	Val         string
 	Err         error
 	Diagnostics []protocol.Diagnostic
+	// Whether Err comes from the evaluation rather than the parsing. The imported files may have changed since
+	EvalErr bool
 }
 
 // Cache caches documents.
 type Cache struct {
 	mu              sync.RWMutex
 	docs            map[protocol.DocumentURI]*Document
-	topLevelObjects map[string][]*ast.DesugaredObject
+	topLevelObjects map[string]topLevelObjects
+
+	// Import graph, between resolved filenames: edges from each file to the files it imports, and the reverse edges
+	imports   map[string]map[string]bool
+	importers map[string]map[string]bool
+
+	// Text of the files that aren't open, read from disk. The generation is incremented on invalidation,
+	// so that a file read during an invalidation isn't cached
+	files      map[protocol.DocumentURI]string
+	generation int
 }
 
 // New returns a document cache.
@@ -37,7 +49,10 @@ func New() *Cache {
 	return &Cache{
 		mu:              sync.RWMutex{},
 		docs:            make(map[protocol.DocumentURI]*Document),
-		topLevelObjects: make(map[string][]*ast.DesugaredObject),
+		topLevelObjects: make(map[string]topLevelObjects),
+		imports:         make(map[string]map[string]bool),
+		importers:       make(map[string]map[string]bool),
+		files:           make(map[protocol.DocumentURI]string),
 	}
 }
 
@@ -53,15 +68,30 @@ func (c *Cache) Put(doc *Document) error {
 		}
 	}
 	c.docs[uri] = doc
-
-	// Invalidate the TopLevelObject cache
-	// We can't easily invalidate the cache for a single file (hard to figure out where the import actually leads),
-	// so we just clear the whole thing
-	c.topLevelObjects = make(map[string][]*ast.DesugaredObject)
+	c.invalidateImporters(uri.SpanURI().Filename())
 
 	return nil
 }
 
+// UpdateDiagnostics records the evaluation and the diagnostics of a version of a document. The cached document is
+// replaced rather than modified, since it may be read at the same time. It returns false if the document was closed
+// or changed since.
+func (c *Cache) UpdateDiagnostics(diagnosed *Document) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	doc, ok := c.docs[diagnosed.Item.URI]
+	if !ok || doc.Item.Version != diagnosed.Item.Version || doc.Item.Text != diagnosed.Item.Text {
+		return false
+	}
+	updated := *doc
+	updated.Val, updated.Err, updated.EvalErr = diagnosed.Val, diagnosed.Err, diagnosed.EvalErr
+	updated.Diagnostics = diagnosed.Diagnostics
+	c.docs[diagnosed.Item.URI] = &updated
+
+	return true
+}
+
 // Get retrieves a document from the cache.
 func (c *Cache) Get(uri protocol.DocumentURI) (*Document, error) {
 	c.mu.RLock()
@@ -75,49 +105,99 @@ func (c *Cache) Get(uri protocol.DocumentURI) (*Document, error) {
 	return doc, nil
 }
 
-func (c *Cache) GetContents(uri protocol.DocumentURI, position protocol.Range) (string, error) {
-	text := ""
-	doc, err := c.Get(uri)
-	if err == nil {
-		text = doc.Item.Text
-	} else {
-		// Read the file from disk (TODO: cache this)
-		bytes, err := os.ReadFile(uri.SpanURI().Filename())
-		if err != nil {
-			return "", err
-		}
-		text = string(bytes)
+// Remove evicts a closed document from the cache. Its text is read from disk from now on
+func (c *Cache) Remove(uri protocol.DocumentURI) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.docs, uri)
+	c.invalidate(uri)
+}
+
+// Invalidate forgets the text of a file read from disk, and the top-level objects of the file and of its importers.
+// It's called when the file changes on disk
+func (c *Cache) Invalidate(uri protocol.DocumentURI) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.invalidate(uri)
+}
+
+func (c *Cache) invalidate(uri protocol.DocumentURI) {
+	delete(c.files, uri)
+	c.generation++
+	c.invalidateImporters(uri.SpanURI().Filename())
+}
+
+// GetText returns the text of a document, or of the file on disk if the document isn't open
+func (c *Cache) GetText(uri protocol.DocumentURI) (string, error) {
+	c.mu.RLock()
+	if doc, ok := c.docs[uri]; ok {
+		c.mu.RUnlock()
+		return doc.Item.Text, nil
+	}
+	text, ok := c.files[uri]
+	generation := c.generation
+	c.mu.RUnlock()
+	if ok {
+		return text, nil
+	}
+
+	bytes, err := os.ReadFile(uri.SpanURI().Filename())
+	if err != nil {
+		return "", err
 	}
 
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.generation == generation {
+		c.files[uri] = string(bytes)
+	}
+	return string(bytes), nil
+}
+
+// GetContents returns the text of a document in a range. Like in the AST, the columns of the range are byte offsets
+func (c *Cache) GetContents(uri protocol.DocumentURI, locRange ast.LocationRange) (string, error) {
+	text, err := c.GetText(uri)
+	if err != nil {
+		return "", err
+	}
+	return TextInRange(text, locRange)
+}
+
+// TextInRange returns the part of a text in a range of byte offsets
+func TextInRange(text string, locRange ast.LocationRange) (string, error) {
 	lines := strings.Split(text, "\n")
-	if int(position.Start.Line) >= len(lines) {
-		return "", fmt.Errorf("line %d out of range", position.Start.Line)
+	startLine, startColumn := locRange.Begin.Line-1, locRange.Begin.Column-1
+	endLine, endColumn := locRange.End.Line-1, locRange.End.Column-1
+	if startLine < 0 || startLine >= len(lines) {
+		return "", fmt.Errorf("line %d out of range", startLine)
 	}
-	if int(position.Start.Character) >= len(lines[position.Start.Line]) {
-		return "", fmt.Errorf("character %d out of range", position.Start.Character)
+	if startColumn < 0 || startColumn >= len(lines[startLine]) {
+		return "", fmt.Errorf("character %d out of range", startColumn)
 	}
-	if int(position.End.Line) >= len(lines) {
-		return "", fmt.Errorf("line %d out of range", position.End.Line)
+	if endLine < startLine || endLine >= len(lines) {
+		return "", fmt.Errorf("line %d out of range", endLine)
 	}
-	if int(position.End.Character) >= len(lines[position.End.Line]) {
-		return "", fmt.Errorf("character %d out of range", position.End.Character)
+	if endColumn < 0 || endColumn >= len(lines[endLine]) {
+		return "", fmt.Errorf("character %d out of range", endColumn)
 	}
 
 	contentBuilder := strings.Builder{}
-	for i := position.Start.Line; i <= position.End.Line; i++ {
+	for i := startLine; i <= endLine; i++ {
 		switch i {
-		case position.Start.Line:
-			if i == position.End.Line {
-				contentBuilder.WriteString(lines[i][position.Start.Character:position.End.Character])
+		case startLine:
+			if i == endLine {
+				contentBuilder.WriteString(lines[i][startColumn:endColumn])
 			} else {
-				contentBuilder.WriteString(lines[i][position.Start.Character:])
+				contentBuilder.WriteString(lines[i][startColumn:])
 			}
-		case position.End.Line:
-			contentBuilder.WriteString(lines[i][:position.End.Character])
+		case endLine:
+			contentBuilder.WriteString(lines[i][:endColumn])
 		default:
 			contentBuilder.WriteString(lines[i])
 		}
-		if i != position.End.Line {
+		if i != endLine {
 			contentBuilder.WriteRune('\n')
 		}
 	}
@@ -125,19 +205,30 @@ func (c *Cache) GetContents(uri protocol.DocumentURI, position protocol.Range) (
 	return contentBuilder.String(), nil
 }
 
+// topLevelObjects are the top-level objects of an imported file, along with the resolved filename of the import
+type topLevelObjects struct {
+	foundAt string
+	objects []*ast.DesugaredObject
+}
+
 func (c *Cache) GetTopLevelObject(filename, importedFrom string) ([]*ast.DesugaredObject, bool) {
 	c.mu.RLock()
 	defer c.mu.RUnlock()
 
 	cacheKey := importedFrom + ":" + filename
 	v, ok := c.topLevelObjects[cacheKey]
-	return v, ok
+	return v.objects, ok
 }
 
-func (c *Cache) PutTopLevelObject(filename, importedFrom string, objects []*ast.DesugaredObject) {
+// PutTopLevelObject caches the top-level objects of an import. foundAt is the file the import resolved to, if any:
+// the objects are invalidated when this file or one of the files it imports changes
+func (c *Cache) PutTopLevelObject(filename, importedFrom, foundAt string, objects []*ast.DesugaredObject) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
+	if foundAt != "" {
+		foundAt = utils.AbsFilename(foundAt)
+	}
 	cacheKey := importedFrom + ":" + filename
-	c.topLevelObjects[cacheKey] = objects
+	c.topLevelObjects[cacheKey] = topLevelObjects{foundAt: foundAt, objects: objects}
 }
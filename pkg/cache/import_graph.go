@@ -0,0 +1,115 @@
+package cache
+
+import (
+	"sort"
+
+	"github.com/grafana/jsonnet-language-server/pkg/utils"
+)
+
+// AddImport adds an edge to the import graph, from a file to a file it imports, as resolved by the importer
+func (c *Cache) AddImport(importer, imported string) {
+	if importer == "" || imported == "" {
+		return
+	}
+	importer, imported = utils.AbsFilename(importer), utils.AbsFilename(imported)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.addImport(importer, imported)
+}
+
+// SetImports replaces the edges of the import graph from a file, once all its imports are resolved again
+func (c *Cache) SetImports(importer string, imported []string) {
+	if importer == "" {
+		return
+	}
+	importer = utils.AbsFilename(importer)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for previous := range c.imports[importer] {
+		delete(c.importers[previous], importer)
+		if len(c.importers[previous]) == 0 {
+			delete(c.importers, previous)
+		}
+	}
+	delete(c.imports, importer)
+
+	for _, filename := range imported {
+		if filename != "" {
+			c.addImport(importer, utils.AbsFilename(filename))
+		}
+	}
+}
+
+func (c *Cache) addImport(importer, imported string) {
+	if c.imports[importer] == nil {
+		c.imports[importer] = map[string]bool{}
+	}
+	c.imports[importer][imported] = true
+	if c.importers[imported] == nil {
+		c.importers[imported] = map[string]bool{}
+	}
+	c.importers[imported][importer] = true
+}
+
+// TransitiveImporters returns the files of the import graph that import a file, directly or not, sorted
+func (c *Cache) TransitiveImporters(filename string) []string {
+	filename = utils.AbsFilename(filename)
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	var importers []string
+	for importer := range c.transitiveImporters(filename) {
+		if importer != filename {
+			importers = append(importers, importer)
+		}
+	}
+	sort.Strings(importers)
+	return importers
+}
+
+// transitiveImporters returns the file and the files that import it, directly or not
+func (c *Cache) transitiveImporters(filename string) map[string]bool {
+	visited := map[string]bool{filename: true}
+	queue := []string{filename}
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+		for importer := range c.importers[current] {
+			if !visited[importer] {
+				visited[importer] = true
+				queue = append(queue, importer)
+			}
+		}
+	}
+	return visited
+}
+
+// ResetImports forgets the import graph and all the top-level objects. It's called when imports may resolve to other files
+func (c *Cache) ResetImports() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.topLevelObjects = make(map[string]topLevelObjects)
+	c.imports = make(map[string]map[string]bool)
+	c.importers = make(map[string]map[string]bool)
+}
+
+// invalidateImporters forgets the top-level objects of a changed file and of the files that import it, directly or not.
+// Imports that couldn't be resolved may resolve to the changed file now, their objects are forgotten as well.
+// The imports of the changed file are kept until they're resolved again, so that it's still found as an importer
+// of its dependencies in the meantime
+func (c *Cache) invalidateImporters(filename string) {
+	filename = utils.AbsFilename(filename)
+
+	affected := c.transitiveImporters(filename)
+	for key, objects := range c.topLevelObjects {
+		if objects.foundAt == "" || affected[objects.foundAt] {
+			delete(c.topLevelObjects, key)
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package cache
+
+import (
+	"testing"
+
+	"github.com/google/go-jsonnet/ast"
+	"github.com/jdbaldry/go-language-server-protocol/lsp/protocol"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTransitiveImporters(t *testing.T) {
+	testCases := []struct {
+		name     string
+		imports  [][2]string
+		filename string
+		expected []string
+	}{
+		{
+			name:     "no importers",
+			imports:  [][2]string{{"/main.jsonnet", "/lib.libsonnet"}},
+			filename: "/main.jsonnet",
+		},
+		{
+			name:     "direct importers, sorted",
+			imports:  [][2]string{{"/b.jsonnet", "/lib.libsonnet"}, {"/a.jsonnet", "/lib.libsonnet"}},
+			filename: "/lib.libsonnet",
+			expected: []string{"/a.jsonnet", "/b.jsonnet"},
+		},
+		{
+			name: "indirect importers",
+			imports: [][2]string{
+				{"/main.jsonnet", "/lib.libsonnet"},
+				{"/lib.libsonnet", "/base.libsonnet"},
+				{"/other.jsonnet", "/unrelated.libsonnet"},
+			},
+			filename: "/base.libsonnet",
+			expected: []string{"/lib.libsonnet", "/main.jsonnet"},
+		},
+		{
+			name: "cycle",
+			imports: [][2]string{
+				{"/main.jsonnet", "/a.libsonnet"},
+				{"/a.libsonnet", "/b.libsonnet"},
+				{"/b.libsonnet", "/a.libsonnet"},
+			},
+			filename: "/b.libsonnet",
+			expected: []string{"/a.libsonnet", "/main.jsonnet"},
+		},
+		{
+			name:     "self import",
+			imports:  [][2]string{{"/lib.libsonnet", "/lib.libsonnet"}},
+			filename: "/lib.libsonnet",
+		},
+		{
+			name:     "unresolved imports are ignored",
+			imports:  [][2]string{{"/main.jsonnet", ""}, {"", "/lib.libsonnet"}},
+			filename: "/lib.libsonnet",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			c := New()
+			for _, edge := range tc.imports {
+				c.AddImport(edge[0], edge[1])
+			}
+			assert.Equal(t, tc.expected, c.TransitiveImporters(tc.filename))
+		})
+	}
+}
+
+func TestSetImports(t *testing.T) {
+	c := New()
+	c.AddImport("/main.jsonnet", "/a.libsonnet")
+	c.AddImport("/main.jsonnet", "/b.libsonnet")
+
+	c.SetImports("/main.jsonnet", []string{"/b.libsonnet", "/c.libsonnet", ""})
+	assert.Empty(t, c.TransitiveImporters("/a.libsonnet"))
+	assert.Equal(t, []string{"/main.jsonnet"}, c.TransitiveImporters("/b.libsonnet"))
+	assert.Equal(t, []string{"/main.jsonnet"}, c.TransitiveImporters("/c.libsonnet"))
+
+	c.SetImports("/main.jsonnet", nil)
+	assert.Empty(t, c.TransitiveImporters("/b.libsonnet"))
+	assert.Empty(t, c.TransitiveImporters("/c.libsonnet"))
+}
+
+func TestInvalidateImporters(t *testing.T) {
+	// main imports lib, which imports base. other imports unrelated, and missing couldn't be resolved
+	setup := func() *Cache {
+		c := New()
+		c.AddImport("/main.jsonnet", "/lib.libsonnet")
+		c.AddImport("/lib.libsonnet", "/base.libsonnet")
+		c.AddImport("/other.jsonnet", "/unrelated.libsonnet")
+		c.PutTopLevelObject("lib.libsonnet", "/main.jsonnet", "/lib.libsonnet", nil)
+		c.PutTopLevelObject("base.libsonnet", "/lib.libsonnet", "/base.libsonnet", nil)
+		c.PutTopLevelObject("unrelated.libsonnet", "/other.jsonnet", "/unrelated.libsonnet", nil)
+		c.PutTopLevelObject("missing.libsonnet", "/other.jsonnet", "", nil)
+		return c
+	}
+	cached := func(c *Cache) []string {
+		var keys []string
+		for _, key := range []string{
+			"/main.jsonnet:lib.libsonnet",
+			"/lib.libsonnet:base.libsonnet",
+			"/other.jsonnet:unrelated.libsonnet",
+			"/other.jsonnet:missing.libsonnet",
+		} {
+			if _, ok := c.topLevelObjects[key]; ok {
+				keys = append(keys, key)
+			}
+		}
+		return keys
+	}
+
+	testCases := []struct {
+		name       string
+		invalidate func(c *Cache)
+		expected   []string
+	}{
+		{
+			name: "put a dependency",
+			invalidate: func(c *Cache) {
+				require.NoError(t, c.Put(&Document{Item: protocol.TextDocumentItem{URI: protocol.URIFromPath("/base.libsonnet")}}))
+			},
+			expected: []string{"/other.jsonnet:unrelated.libsonnet"},
+		},
+		{
+			name: "put an importer",
+			invalidate: func(c *Cache) {
+				require.NoError(t, c.Put(&Document{Item: protocol.TextDocumentItem{URI: protocol.URIFromPath("/lib.libsonnet")}}))
+			},
+			expected: []string{"/lib.libsonnet:base.libsonnet", "/other.jsonnet:unrelated.libsonnet"},
+		},
+		{
+			name:       "file changed on disk",
+			invalidate: func(c *Cache) { c.Invalidate(protocol.URIFromPath("/unrelated.libsonnet")) },
+			expected:   []string{"/main.jsonnet:lib.libsonnet", "/lib.libsonnet:base.libsonnet"},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			c := setup()
+			tc.invalidate(c)
+			assert.Equal(t, tc.expected, cached(c))
+
+			// The imports are kept until they're resolved again
+			assert.Equal(t, []string{"/lib.libsonnet", "/main.jsonnet"}, c.TransitiveImporters("/base.libsonnet"))
+			assert.Equal(t, []string{"/other.jsonnet"}, c.TransitiveImporters("/unrelated.libsonnet"))
+		})
+	}
+}
+
+func TestResetImports(t *testing.T) {
+	c := New()
+	c.AddImport("/main.jsonnet", "/lib.libsonnet")
+	c.PutTopLevelObject("lib.libsonnet", "/main.jsonnet", "/lib.libsonnet", []*ast.DesugaredObject{{}})
+
+	c.ResetImports()
+	assert.Empty(t, c.TransitiveImporters("/lib.libsonnet"))
+	_, ok := c.GetTopLevelObject("lib.libsonnet", "/main.jsonnet")
+	assert.False(t, ok)
+}
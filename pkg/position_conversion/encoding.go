@@ -0,0 +1,134 @@
+package position
+
+import (
+	"strings"
+	"unicode/utf8"
+
+	"github.com/google/go-jsonnet/ast"
+	"github.com/jdbaldry/go-language-server-protocol/lsp/protocol"
+)
+
+// Encoding is the unit in which the characters of protocol positions are counted
+type Encoding string
+
+const (
+	UTF8  Encoding = "utf-8"
+	UTF16 Encoding = "utf-16"
+	UTF32 Encoding = "utf-32"
+)
+
+// NegotiateEncoding picks the encoding of the positions among the ones supported by the client.
+// UTF-8 is preferred, since it's the encoding of the AST columns. Without a supported encoding, the protocol requires UTF-16
+func NegotiateEncoding(clientEncodings []string) Encoding {
+	for _, encoding := range clientEncodings {
+		if Encoding(encoding) == UTF8 {
+			return UTF8
+		}
+	}
+	for _, encoding := range clientEncodings {
+		if encoding := Encoding(encoding); encoding == UTF16 || encoding == UTF32 {
+			return encoding
+		}
+	}
+	return UTF16
+}
+
+// units returns the number of code units of a rune in the encoding
+func (e Encoding) units(r rune, size int) uint32 {
+	switch e {
+	case UTF8:
+		return uint32(size)
+	case UTF32:
+		return 1
+	}
+	// Characters outside of the Basic Multilingual Plane are made of two UTF-16 code units
+	if r >= 0x10000 {
+		return 2
+	}
+	return 1
+}
+
+// ByteOffset returns the byte offset of a character of the line. Characters past the end of the line count as one byte each
+func (e Encoding) ByteOffset(line string, character uint32) int {
+	offset, units := 0, uint32(0)
+	for offset < len(line) {
+		r, size := utf8.DecodeRuneInString(line[offset:])
+		if units+e.units(r, size) > character {
+			return offset
+		}
+		units += e.units(r, size)
+		offset += size
+	}
+	return offset + int(character-units)
+}
+
+// Character returns the character of a byte offset of the line. Offsets past the end of the line count as one character each
+func (e Encoding) Character(line string, offset int) uint32 {
+	current, units := 0, uint32(0)
+	for current < len(line) && current < offset {
+		r, size := utf8.DecodeRuneInString(line[current:])
+		units += e.units(r, size)
+		current += size
+	}
+	if offset > current {
+		units += uint32(offset - current)
+	}
+	return units
+}
+
+// Converter converts the positions of a text between the protocol, whose characters are counted in an encoding,
+// and the AST, whose columns are counted in bytes
+type Converter struct {
+	encoding Encoding
+	lines    []string
+}
+
+// NewConverter returns the converter of the positions of a text
+func NewConverter(text string, encoding Encoding) *Converter {
+	converter := &Converter{encoding: encoding}
+	if encoding != UTF8 {
+		converter.lines = strings.Split(text, "\n")
+	}
+	return converter
+}
+
+// Encoding returns the encoding of the protocol positions
+func (c *Converter) Encoding() Encoding {
+	return c.encoding
+}
+
+// Line returns a line of the text, or an empty line if it's out of the text. UTF-8 converters don't keep the text
+func (c *Converter) Line(index int) string {
+	if index < 0 || index >= len(c.lines) {
+		return ""
+	}
+	return c.lines[index]
+}
+
+func (c *Converter) ProtocolToAST(point protocol.Position) ast.Location {
+	return ast.Location{
+		Line:   int(point.Line) + 1,
+		Column: c.encoding.ByteOffset(c.Line(int(point.Line)), point.Character) + 1,
+	}
+}
+
+func (c *Converter) ASTToProtocol(location ast.Location) protocol.Position {
+	return protocol.Position{
+		Line:      uint32(location.Line - 1),
+		Character: c.encoding.Character(c.Line(location.Line-1), location.Column-1),
+	}
+}
+
+func (c *Converter) RangeASTToProtocol(lr ast.LocationRange) protocol.Range {
+	return protocol.Range{
+		Start: c.ASTToProtocol(lr.Begin),
+		End:   c.ASTToProtocol(lr.End),
+	}
+}
+
+func (c *Converter) RangeProtocolToAST(r protocol.Range) ast.LocationRange {
+	return ast.LocationRange{
+		Begin: c.ProtocolToAST(r.Start),
+		End:   c.ProtocolToAST(r.End),
+	}
+}
@@ -0,0 +1,90 @@
+package position
+
+import (
+	"testing"
+
+	"github.com/google/go-jsonnet/ast"
+	"github.com/jdbaldry/go-language-server-protocol/lsp/protocol"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNegotiateEncoding(t *testing.T) {
+	for _, tc := range []struct {
+		name            string
+		clientEncodings []string
+		expected        Encoding
+	}{
+		{name: "no encodings", expected: UTF16},
+		{name: "UTF-8 is preferred", clientEncodings: []string{"utf-16", "utf-8"}, expected: UTF8},
+		{name: "UTF-32", clientEncodings: []string{"utf-32", "utf-16"}, expected: UTF32},
+		{name: "unknown encodings", clientEncodings: []string{"utf-7"}, expected: UTF16},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, NegotiateEncoding(tc.clientEncodings))
+		})
+	}
+}
+
+// The emoji is one UTF-32 code unit, two UTF-16 code units and four bytes. The accented letter takes two bytes
+const nonASCIILine = "local e = '😀é', x = e;"
+
+func TestEncodingOffsets(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		encoding  Encoding
+		character uint32
+		offset    int
+	}{
+		{name: "UTF-8 start", encoding: UTF8, character: 0, offset: 0},
+		{name: "UTF-8 accented letter", encoding: UTF8, character: 15, offset: 15},
+		{name: "UTF-8 variable", encoding: UTF8, character: 20, offset: 20},
+		{name: "UTF-16 emoji", encoding: UTF16, character: 11, offset: 11},
+		{name: "UTF-16 accented letter", encoding: UTF16, character: 13, offset: 15},
+		{name: "UTF-16 variable", encoding: UTF16, character: 17, offset: 20},
+		{name: "UTF-16 past the end", encoding: UTF16, character: 24, offset: 27},
+		{name: "UTF-32 accented letter", encoding: UTF32, character: 12, offset: 15},
+		{name: "UTF-32 variable", encoding: UTF32, character: 16, offset: 20},
+		{name: "UTF-32 past the end", encoding: UTF32, character: 23, offset: 27},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.offset, tc.encoding.ByteOffset(nonASCIILine, tc.character))
+			assert.Equal(t, tc.character, tc.encoding.Character(nonASCIILine, tc.offset))
+		})
+	}
+}
+
+func TestEncodingOffsetInsideCharacter(t *testing.T) {
+	// The second UTF-16 code unit of the emoji is in the same character as the first one
+	assert.Equal(t, 11, UTF16.ByteOffset(nonASCIILine, 12))
+	// A byte offset inside the emoji counts the whole character
+	assert.Equal(t, uint32(13), UTF16.Character(nonASCIILine, 12))
+}
+
+func TestConverter(t *testing.T) {
+	const text = nonASCIILine + "\nx\n"
+
+	for _, tc := range []struct {
+		encoding Encoding
+		position protocol.Position
+		location ast.Location
+	}{
+		{encoding: UTF8, position: protocol.Position{Line: 0, Character: 20}, location: ast.Location{Line: 1, Column: 21}},
+		{encoding: UTF16, position: protocol.Position{Line: 0, Character: 17}, location: ast.Location{Line: 1, Column: 21}},
+		{encoding: UTF32, position: protocol.Position{Line: 0, Character: 16}, location: ast.Location{Line: 1, Column: 21}},
+		{encoding: UTF16, position: protocol.Position{Line: 1, Character: 1}, location: ast.Location{Line: 2, Column: 2}},
+		// The lines out of the text are empty
+		{encoding: UTF16, position: protocol.Position{Line: 5, Character: 2}, location: ast.Location{Line: 6, Column: 3}},
+	} {
+		t.Run(string(tc.encoding), func(t *testing.T) {
+			converter := NewConverter(text, tc.encoding)
+			assert.Equal(t, tc.encoding, converter.Encoding())
+			assert.Equal(t, tc.location, converter.ProtocolToAST(tc.position))
+			assert.Equal(t, tc.position, converter.ASTToProtocol(tc.location))
+
+			r := protocol.Range{Start: tc.position, End: tc.position}
+			lr := ast.LocationRange{Begin: tc.location, End: tc.location}
+			assert.Equal(t, lr, converter.RangeProtocolToAST(r))
+			assert.Equal(t, r, converter.RangeASTToProtocol(lr))
+		})
+	}
+}
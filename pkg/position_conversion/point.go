@@ -5,9 +5,18 @@ import (
 	"github.com/jdbaldry/go-language-server-protocol/lsp/protocol"
 )
 
+// ProtocolToAST converts a position whose characters are counted in bytes (UTF-8). See Converter for the other encodings
 func ProtocolToAST(point protocol.Position) ast.Location {
 	return ast.Location{
 		Line:   int(point.Line) + 1,
 		Column: int(point.Character) + 1,
 	}
 }
+
+// ASTToProtocol converts a location to a position whose characters are counted in bytes (UTF-8)
+func ASTToProtocol(location ast.Location) protocol.Position {
+	return protocol.Position{
+		Line:      uint32(location.Line - 1),
+		Character: uint32(location.Column - 1),
+	}
+}
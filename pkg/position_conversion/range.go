@@ -19,7 +19,7 @@ func NewProtocolRange(startLine, startCharacter, endLine, endCharacter int) prot
 }
 
 // RangeASTToProtocol translates a ast.LocationRange to a protocol.Range.
-// The former is one indexed and the latter is zero indexed.
+// The former is one indexed and the latter is zero indexed. The characters are counted in bytes (UTF-8).
 func RangeASTToProtocol(lr ast.LocationRange) protocol.Range {
 	return protocol.Range{
 		Start: protocol.Position{
@@ -0,0 +1,316 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"sort"
+
+	"github.com/google/go-jsonnet/ast"
+	"github.com/grafana/jsonnet-language-server/pkg/ast/processing"
+	"github.com/grafana/jsonnet-language-server/pkg/nodestack"
+	"github.com/grafana/jsonnet-language-server/pkg/utils"
+	"github.com/jdbaldry/go-language-server-protocol/lsp/protocol"
+	log "github.com/sirupsen/logrus"
+)
+
+var errNotAFunction = errors.New("symbol is not a function")
+
+func (s *Server) PrepareCallHierarchy(_ context.Context, params *protocol.CallHierarchyPrepareParams) ([]protocol.CallHierarchyItem, error) {
+	doc, err := s.cache.Get(params.TextDocument.URI)
+	if err != nil {
+		return nil, utils.LogErrorf("PrepareCallHierarchy: %s: %w", errorRetrievingDocument, err)
+	}
+
+	if doc.AST == nil {
+		return nil, utils.LogErrorf("PrepareCallHierarchy: document was never successfully parsed, can't prepare call hierarchy")
+	}
+	if doc.LinesChangedSinceAST[int(params.Position.Line)] {
+		return nil, utils.LogErrorf("PrepareCallHierarchy: document line %d was changed since last successful parse, can't prepare call hierarchy", params.Position.Line)
+	}
+
+	processor := processing.NewProcessor(s.cache, s.getVM(doc.Item.URI.SpanURI().Filename()))
+	target, err := findTargetSymbol(processor, doc.AST, s.converter(doc.Item.Text).ProtocolToAST(params.Position))
+	if err != nil {
+		log.Debugf("PrepareCallHierarchy: %v", err)
+		return nil, nil
+	}
+
+	items := s.callHierarchyItems(processor, target)
+	if len(items) == 0 {
+		log.Debugf("PrepareCallHierarchy: %s: %v", target.name, errNotAFunction)
+		return nil, nil
+	}
+	return items, nil
+}
+
+func (s *Server) IncomingCalls(_ context.Context, params *protocol.CallHierarchyIncomingCallsParams) ([]protocol.CallHierarchyIncomingCall, error) {
+	// Files are only callers, they can't be called
+	if params.Item.Kind == protocol.File {
+		return nil, nil
+	}
+
+	filename := params.Item.URI.SpanURI().Filename()
+	processor := processing.NewProcessor(s.cache, s.getVM(filename))
+	root, err := processor.GetAST(filename)
+	if err != nil {
+		return nil, utils.LogErrorf("IncomingCalls: %s: %w", errorParsingDocument, err)
+	}
+	target, err := findTargetSymbol(processor, root, s.fileConverter(params.Item.URI).ProtocolToAST(params.Item.SelectionRange.Start))
+	if err != nil {
+		return nil, utils.LogErrorf("IncomingCalls: %w", err)
+	}
+
+	usages, _, err := processor.FindUsages(target.files(), target.name, target.definitions)
+	if err != nil {
+		return nil, utils.LogErrorf("IncomingCalls: error finding usages: %w", err)
+	}
+
+	var calls []protocol.CallHierarchyIncomingCall
+	callIndexes := map[callHierarchyKey]int{}
+	for _, usage := range usages {
+		usageRoot, err := processor.GetAST(usage.Filename)
+		if err != nil {
+			log.Debugf("IncomingCalls: could not parse %s: %v", usage.Filename, err)
+			continue
+		}
+		stack, err := processing.FindParentsByNode(usageRoot, usage.Node)
+		if err != nil || stack.IsEmpty() {
+			continue
+		}
+		// Only calls are shown, not other references to the function
+		if apply, ok := stack.Peek().(*ast.Apply); !ok || apply.Target != usage.Node {
+			continue
+		}
+		nameRange, ok := usageNameRange(usage)
+		if !ok {
+			continue
+		}
+
+		caller := s.callerItem(processor, usage.Filename, usageRoot, stack)
+		fromRange := s.fileConverter(caller.URI).RangeASTToProtocol(nameRange)
+		key := newCallHierarchyKey(caller)
+		if i, ok := callIndexes[key]; ok {
+			calls[i].FromRanges = append(calls[i].FromRanges, fromRange)
+			continue
+		}
+		callIndexes[key] = len(calls)
+		calls = append(calls, protocol.CallHierarchyIncomingCall{
+			From:       caller,
+			FromRanges: []protocol.Range{fromRange},
+		})
+	}
+	return calls, nil
+}
+
+func (s *Server) OutgoingCalls(_ context.Context, params *protocol.CallHierarchyOutgoingCallsParams) ([]protocol.CallHierarchyOutgoingCall, error) {
+	filename := params.Item.URI.SpanURI().Filename()
+	processor := processing.NewProcessor(s.cache, s.getVM(filename))
+	root, err := processor.GetAST(filename)
+	if err != nil {
+		return nil, utils.LogErrorf("OutgoingCalls: %s: %w", errorParsingDocument, err)
+	}
+
+	// The calls are searched in the body of the function, or in the whole file
+	converter := s.fileConverter(params.Item.URI)
+	body := root
+	if params.Item.Kind != protocol.File {
+		target, err := findTargetSymbol(processor, root, converter.ProtocolToAST(params.Item.SelectionRange.Start))
+		if err != nil {
+			return nil, utils.LogErrorf("OutgoingCalls: %w", err)
+		}
+		var function *ast.Function
+		for _, definition := range target.definitions {
+			if function = definitionFunction(processor, definition); function != nil {
+				break
+			}
+		}
+		if function == nil {
+			return nil, utils.LogErrorf("OutgoingCalls: %s: %w", target.name, errNotAFunction)
+		}
+		body = function.Body
+	}
+
+	var calls []protocol.CallHierarchyOutgoingCall
+	callIndexes := map[callHierarchyKey]int{}
+	for _, apply := range findCalls(body) {
+		var nameRange ast.LocationRange
+		switch target := apply.Target.(type) {
+		case *ast.Var:
+			nameRange = target.LocRange
+		case *ast.Index:
+			var ok bool
+			if nameRange, ok = processing.IndexNameRange(target); !ok {
+				continue
+			}
+		default:
+			// Calls of expressions (`f(x)(y)`) can't be resolved
+			continue
+		}
+
+		callee, err := findTargetSymbol(processor, root, nameRange.Begin)
+		if err != nil {
+			log.Debugf("OutgoingCalls: could not resolve the call on line %d: %v", nameRange.Begin.Line, err)
+			continue
+		}
+		fromRange := converter.RangeASTToProtocol(nameRange)
+		for _, item := range s.callHierarchyItems(processor, callee) {
+			key := newCallHierarchyKey(item)
+			if i, ok := callIndexes[key]; ok {
+				calls[i].FromRanges = append(calls[i].FromRanges, fromRange)
+				continue
+			}
+			callIndexes[key] = len(calls)
+			calls = append(calls, protocol.CallHierarchyOutgoingCall{
+				To:         item,
+				FromRanges: []protocol.Range{fromRange},
+			})
+		}
+	}
+	return calls, nil
+}
+
+// callHierarchyKey identifies a call hierarchy item, to group the calls by caller or callee
+type callHierarchyKey struct {
+	uri   protocol.DocumentURI
+	start protocol.Position
+}
+
+func newCallHierarchyKey(item protocol.CallHierarchyItem) callHierarchyKey {
+	return callHierarchyKey{uri: item.URI, start: item.SelectionRange.Start}
+}
+
+// callHierarchyItems returns the items of the definitions of the symbol that are functions
+func (s *Server) callHierarchyItems(processor *processing.Processor, target *targetSymbol) []protocol.CallHierarchyItem {
+	var items []protocol.CallHierarchyItem
+	seen := map[callHierarchyKey]bool{}
+	for _, definition := range target.definitions {
+		function := definitionFunction(processor, definition)
+		if function == nil {
+			continue
+		}
+		nameRange, ok := target.definitionNameRange(processor, definition)
+		if !ok {
+			continue
+		}
+		item := s.newCallHierarchyItem(target.name, target.isField, definition.Filename, definition.FullRange, nameRange, function)
+		if key := newCallHierarchyKey(item); !seen[key] {
+			seen[key] = true
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func (s *Server) newCallHierarchyItem(name string, isField bool, filename string, fullRange, nameRange ast.LocationRange, function *ast.Function) protocol.CallHierarchyItem {
+	kind := protocol.Function
+	if isField {
+		kind = protocol.Method
+	}
+	uri := protocol.URIFromPath(utils.AbsFilename(filename))
+	converter := s.fileConverter(uri)
+	return protocol.CallHierarchyItem{
+		Name:           name,
+		Kind:           kind,
+		Detail:         symbolDetails(function),
+		URI:            uri,
+		Range:          converter.RangeASTToProtocol(fullRange),
+		SelectionRange: converter.RangeASTToProtocol(nameRange),
+	}
+}
+
+// definitionFunction returns the function defined by a local or a field, or nil if it's not a function
+func definitionFunction(processor *processing.Processor, definition processing.ObjectRange) *ast.Function {
+	if function, ok := definition.Node.(*ast.Function); ok {
+		return function
+	}
+	if definition.FieldName != "" {
+		return nil
+	}
+
+	// The ranges of locals don't hold their body, find the bind in the file
+	root, err := processor.GetAST(definition.Filename)
+	if err != nil {
+		return nil
+	}
+	stack, err := processing.FindNodeByPosition(root, definition.SelectionRange.Begin)
+	if err != nil {
+		return nil
+	}
+	for !stack.IsEmpty() {
+		var binds ast.LocalBinds
+		switch node := stack.Pop().(type) {
+		case *ast.Local:
+			binds = node.Binds
+		case *ast.DesugaredObject:
+			binds = node.Locals
+		}
+		for _, bind := range binds {
+			if processing.IsSameDefinition(processing.LocalBindToRange(bind), definition) {
+				function, _ := bind.Body.(*ast.Function)
+				return function
+			}
+		}
+	}
+	return nil
+}
+
+// callerItem returns the item of the innermost named function enclosing the stack.
+// Calls that are not part of a named function are made by the file itself
+func (s *Server) callerItem(processor *processing.Processor, filename string, root ast.Node, stack *nodestack.NodeStack) protocol.CallHierarchyItem {
+	for i := len(stack.Stack) - 1; i >= 0; i-- {
+		function, ok := stack.Stack[i].(*ast.Function)
+		if !ok {
+			continue
+		}
+		// Find the local or field that the function is bound to. Anonymous functions are skipped
+		for j := i - 1; j >= 0; j-- {
+			var binds ast.LocalBinds
+			switch parent := stack.Stack[j].(type) {
+			case *ast.Local:
+				binds = parent.Binds
+			case *ast.DesugaredObject:
+				binds = parent.Locals
+				for _, field := range parent.Fields {
+					if field.Body != function {
+						continue
+					}
+					if nameRange, ok := processing.FieldNameRange(field); ok {
+						return s.newCallHierarchyItem(processor.FieldNameToString(field.Name), true, filename, field.LocRange, nameRange, function)
+					}
+				}
+			}
+			for _, bind := range binds {
+				if bind.Body == function {
+					bindRange := processing.LocalBindToRange(bind)
+					return s.newCallHierarchyItem(string(bind.Variable), false, filename, bindRange.FullRange, bindRange.SelectionRange, function)
+				}
+			}
+		}
+	}
+
+	uri := protocol.URIFromPath(utils.AbsFilename(filename))
+	fileRange := s.fileConverter(uri).RangeASTToProtocol(*root.Loc())
+	return protocol.CallHierarchyItem{
+		Name:           filepath.Base(filename),
+		Kind:           protocol.File,
+		URI:            uri,
+		Range:          fileRange,
+		SelectionRange: protocol.Range{Start: fileRange.Start, End: fileRange.Start},
+	}
+}
+
+// findCalls returns the function calls made in the given node, in the order of the text
+func findCalls(node ast.Node) []*ast.Apply {
+	var applies []*ast.Apply
+	processing.Walk(node, func(node ast.Node) bool {
+		if apply, ok := node.(*ast.Apply); ok {
+			applies = append(applies, apply)
+		}
+		return true
+	})
+	sort.SliceStable(applies, func(i, j int) bool {
+		return isBefore(applies[i].LocRange.Begin, applies[j].LocRange.Begin)
+	})
+	return applies
+}
@@ -0,0 +1,243 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/jdbaldry/go-language-server-protocol/lsp/protocol"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// callDescription describes a call as `name (file:line:character) <- [line:character...]`, using the item's selection range
+func callDescription(item protocol.CallHierarchyItem, fromRanges []protocol.Range) string {
+	description := fmt.Sprintf("%s (%s:%d:%d)", item.Name, filepath.Base(item.URI.SpanURI().Filename()), item.SelectionRange.Start.Line, item.SelectionRange.Start.Character)
+	for _, r := range fromRanges {
+		description += fmt.Sprintf(" %d:%d", r.Start.Line, r.Start.Character)
+	}
+	return description
+}
+
+func prepareCallHierarchy(t *testing.T, server *Server, uri protocol.DocumentURI, pos protocol.Position) []protocol.CallHierarchyItem {
+	t.Helper()
+	items, err := server.PrepareCallHierarchy(context.Background(), &protocol.CallHierarchyPrepareParams{
+		TextDocumentPositionParams: protocol.TextDocumentPositionParams{
+			TextDocument: protocol.TextDocumentIdentifier{URI: uri},
+			Position:     pos,
+		},
+	})
+	require.NoError(t, err)
+	return items
+}
+
+func TestPrepareCallHierarchy(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		filename string
+		position protocol.Position
+		expected []protocol.CallHierarchyItem
+	}{
+		{
+			name:     "field function from a call",
+			filename: "testdata/call-hierarchy.jsonnet",
+			position: protocol.Position{Line: 5, Character: 14},
+			expected: []protocol.CallHierarchyItem{
+				{
+					Name:   "withResources",
+					Kind:   protocol.Method,
+					Detail: "Function(cpu, memory)",
+					URI:    absURI(t, "testdata/call-hierarchy-lib.libsonnet"),
+					Range: protocol.Range{
+						Start: protocol.Position{Line: 3, Character: 2},
+						End:   protocol.Position{Line: 3, Character: 85},
+					},
+					SelectionRange: protocol.Range{
+						Start: protocol.Position{Line: 3, Character: 2},
+						End:   protocol.Position{Line: 3, Character: 15},
+					},
+				},
+			},
+		},
+		{
+			name:     "local function from its definition",
+			filename: "testdata/call-hierarchy.jsonnet",
+			position: protocol.Position{Line: 2, Character: 8},
+			expected: []protocol.CallHierarchyItem{
+				{
+					Name:   "sidecar",
+					Kind:   protocol.Function,
+					Detail: "Function(name)",
+					URI:    absURI(t, "testdata/call-hierarchy.jsonnet"),
+					Range: protocol.Range{
+						Start: protocol.Position{Line: 2, Character: 6},
+						End:   protocol.Position{Line: 2, Character: 102},
+					},
+					SelectionRange: protocol.Range{
+						Start: protocol.Position{Line: 2, Character: 6},
+						End:   protocol.Position{Line: 2, Character: 13},
+					},
+				},
+			},
+		},
+		{
+			name:     "not a function",
+			filename: "testdata/call-hierarchy.jsonnet",
+			position: protocol.Position{Line: 0, Character: 8},
+		},
+		{
+			name:     "std function",
+			filename: "testdata/call-hierarchy.jsonnet",
+			position: protocol.Position{Line: 6, Character: 17},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			server := NewServer("any", "test version", nil, Configuration{
+				JPaths: []string{"testdata"},
+			})
+			uri := serverOpenTestFile(t, server, tc.filename)
+
+			assert.Equal(t, tc.expected, prepareCallHierarchy(t, server, uri, tc.position))
+		})
+	}
+}
+
+func TestIncomingCalls(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		filename string
+		position protocol.Position
+		expected []string
+	}{
+		{
+			name:     "field function called from functions and files",
+			filename: "testdata/call-hierarchy.jsonnet",
+			position: protocol.Position{Line: 5, Character: 14},
+			expected: []string{
+				"defaultResources (call-hierarchy-lib.libsonnet:4:2) 4:28",
+				"sidecar (call-hierarchy.jsonnet:2:6) 2:72",
+				"call-hierarchy.jsonnet (call-hierarchy.jsonnet:0:0) 5:14",
+			},
+		},
+		{
+			name:     "local function called from an anonymous function",
+			filename: "testdata/call-hierarchy.jsonnet",
+			position: protocol.Position{Line: 2, Character: 8},
+			expected: []string{
+				"call-hierarchy.jsonnet (call-hierarchy.jsonnet:0:0) 6:35",
+			},
+		},
+		{
+			name:     "local function called from a field function",
+			filename: "testdata/call-hierarchy-lib.libsonnet",
+			position: protocol.Position{Line: 0, Character: 8},
+			expected: []string{
+				"withResources (call-hierarchy-lib.libsonnet:3:2) 3:31",
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			server := NewServer("any", "test version", nil, Configuration{
+				JPaths: []string{"testdata"},
+			})
+			uri := serverOpenTestFile(t, server, tc.filename)
+			items := prepareCallHierarchy(t, server, uri, tc.position)
+			require.Len(t, items, 1)
+
+			calls, err := server.IncomingCalls(context.Background(), &protocol.CallHierarchyIncomingCallsParams{Item: items[0]})
+			require.NoError(t, err)
+
+			var descriptions []string
+			for _, call := range calls {
+				descriptions = append(descriptions, callDescription(call.From, call.FromRanges))
+			}
+			assert.ElementsMatch(t, tc.expected, descriptions)
+		})
+	}
+}
+
+func TestOutgoingCalls(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		filename string
+		position protocol.Position
+		expected []string
+	}{
+		{
+			name:     "local function calling imported functions",
+			filename: "testdata/call-hierarchy.jsonnet",
+			position: protocol.Position{Line: 2, Character: 8},
+			expected: []string{
+				"defaultResources (call-hierarchy-lib.libsonnet:4:2) 2:45",
+				"withResources (call-hierarchy-lib.libsonnet:3:2) 2:72",
+			},
+		},
+		{
+			name:     "field function calling a local function",
+			filename: "testdata/call-hierarchy-lib.libsonnet",
+			position: protocol.Position{Line: 3, Character: 4},
+			expected: []string{
+				"withLimits (call-hierarchy-lib.libsonnet:0:6) 3:31",
+			},
+		},
+		{
+			name:     "calls through self",
+			filename: "testdata/call-hierarchy-lib.libsonnet",
+			position: protocol.Position{Line: 4, Character: 4},
+			expected: []string{
+				"withResources (call-hierarchy-lib.libsonnet:3:2) 4:28",
+			},
+		},
+		{
+			name:     "function without calls",
+			filename: "testdata/call-hierarchy-lib.libsonnet",
+			position: protocol.Position{Line: 0, Character: 8},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			server := NewServer("any", "test version", nil, Configuration{
+				JPaths: []string{"testdata"},
+			})
+			uri := serverOpenTestFile(t, server, tc.filename)
+			items := prepareCallHierarchy(t, server, uri, tc.position)
+			require.Len(t, items, 1)
+
+			calls, err := server.OutgoingCalls(context.Background(), &protocol.CallHierarchyOutgoingCallsParams{Item: items[0]})
+			require.NoError(t, err)
+
+			var descriptions []string
+			for _, call := range calls {
+				descriptions = append(descriptions, callDescription(call.To, call.FromRanges))
+			}
+			assert.Equal(t, tc.expected, descriptions)
+		})
+	}
+}
+
+func TestOutgoingCallsOfFile(t *testing.T) {
+	server := NewServer("any", "test version", nil, Configuration{
+		JPaths: []string{"testdata"},
+	})
+	serverOpenTestFile(t, server, "testdata/call-hierarchy.jsonnet")
+
+	calls, err := server.OutgoingCalls(context.Background(), &protocol.CallHierarchyOutgoingCallsParams{
+		Item: protocol.CallHierarchyItem{
+			Name: "call-hierarchy.jsonnet",
+			Kind: protocol.File,
+			URI:  absURI(t, "testdata/call-hierarchy.jsonnet"),
+		},
+	})
+	require.NoError(t, err)
+
+	var descriptions []string
+	for _, call := range calls {
+		descriptions = append(descriptions, callDescription(call.To, call.FromRanges))
+	}
+	// Calls of the standard library are not part of the hierarchy
+	assert.Equal(t, []string{
+		"defaultResources (call-hierarchy-lib.libsonnet:4:2) 2:45",
+		"withResources (call-hierarchy-lib.libsonnet:3:2) 2:72 5:14",
+		"sidecar (call-hierarchy.jsonnet:2:6) 6:35",
+	}, descriptions)
+}
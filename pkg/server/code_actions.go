@@ -0,0 +1,501 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/google/go-jsonnet/ast"
+	"github.com/google/go-jsonnet/formatter"
+	"github.com/google/go-jsonnet/toolutils"
+	"github.com/grafana/jsonnet-language-server/pkg/ast/processing"
+	"github.com/grafana/jsonnet-language-server/pkg/cache"
+	"github.com/grafana/jsonnet-language-server/pkg/nodestack"
+	position "github.com/grafana/jsonnet-language-server/pkg/position_conversion"
+	"github.com/grafana/jsonnet-language-server/pkg/utils"
+	"github.com/jdbaldry/go-language-server-protocol/lsp/protocol"
+	log "github.com/sirupsen/logrus"
+)
+
+// maxSuggestions is the number of "did you mean" fixes offered for an unknown name
+const maxSuggestions = 3
+
+// stdCallReplacements rewrites the calls of deprecated std functions whose replacement doesn't behave the same way.
+// Other deprecated functions are replaced by the function named in their documentation
+var stdCallReplacements = map[string]string{
+	"base64Decode": "std.decodeUTF8(std.base64DecodeBytes(%s))",
+}
+
+// importLineRegexp matches the lines that import a file into a local
+var importLineRegexp = regexp.MustCompile(`^local\s+\w+\s*=\s*import(str|bin)?\s.*;$`)
+
+// quickFix is a set of edits fixing a diagnostic
+type quickFix struct {
+	title string
+	edits []protocol.TextEdit
+}
+
+func (s *Server) CodeAction(_ context.Context, params *protocol.CodeActionParams) ([]protocol.CodeAction, error) {
+	doc, err := s.cache.Get(params.TextDocument.URI)
+	if err != nil {
+		return nil, utils.LogErrorf("CodeAction: %s: %w", errorRetrievingDocument, err)
+	}
+
+	actions := []protocol.CodeAction{}
+	if codeActionKindRequested(params.Context.Only, protocol.QuickFix) {
+		actions = append(actions, s.quickFixActions(doc, params.Context.Diagnostics)...)
+	}
+	// Refactorings are computed from the AST, its locations must match the text
+	if doc.AST != nil && len(doc.LinesChangedSinceAST) == 0 {
+		actions = append(actions, s.refactorActions(doc, params.Range, params.Context.Only)...)
+	}
+	return actions, nil
+}
+
+// quickFixActions returns the fixes of the diagnostics that have a code
+func (s *Server) quickFixActions(doc *cache.Document, diags []protocol.Diagnostic) []protocol.CodeAction {
+	filename := doc.Item.URI.SpanURI().Filename()
+	// Fixes computed from the AST need its locations to match the text
+	freshAST := doc.AST != nil && len(doc.LinesChangedSinceAST) == 0
+	converter := s.converter(doc.Item.Text)
+
+	var actions []protocol.CodeAction
+	for _, diag := range diags {
+		code, _ := diag.Code.(string)
+		if code == "" {
+			continue
+		}
+		var data diagnosticData
+		if err := unmarshalParams(diag.Data, &data); err != nil || data.Name == "" {
+			log.Debugf("CodeAction: the %s diagnostic has no data, can't fix it", code)
+			continue
+		}
+
+		var fixes []quickFix
+		switch {
+		case code == unusedVariableCode && freshAST:
+			fixes = removeUnusedLocalFixes(converter, doc.Item.Text, doc.AST, data.Name, diag.Range.Start)
+		case code == unknownVariableCode:
+			fixes = unknownVariableFixes(converter, filename, doc.Item.Text, data.Name, diag.Range)
+			fixes = append(fixes, s.importFixes(filename, doc.Item.Text, data.Name)...)
+		case code == unknownFieldCode && freshAST:
+			processor := processing.NewProcessor(s.cache, s.getVM(filename))
+			fixes = unknownFieldFixes(converter, processor, doc.AST, data.Name, diag.Range)
+		case code == deprecatedStdCode && freshAST:
+			fixes = deprecatedStdFixes(converter, doc.Item.Text, doc.AST, data, diag.Range)
+		}
+
+		for _, fix := range fixes {
+			actions = append(actions, protocol.CodeAction{
+				Title:       fix.title,
+				Kind:        protocol.QuickFix,
+				Diagnostics: []protocol.Diagnostic{diag},
+				IsPreferred: len(fixes) == 1,
+				Edit: protocol.WorkspaceEdit{
+					Changes: map[string][]protocol.TextEdit{string(doc.Item.URI): fix.edits},
+				},
+			})
+		}
+	}
+	return actions
+}
+
+// codeActionKindRequested returns true if the kind is part of the kinds requested by the client, or if all kinds are requested
+func codeActionKindRequested(only []protocol.CodeActionKind, kind protocol.CodeActionKind) bool {
+	if len(only) == 0 {
+		return true
+	}
+	for _, requested := range only {
+		if kind == requested || strings.HasPrefix(string(kind), string(requested)+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// removeUnusedLocalFixes removes the bind of an unused local. The whole `local` is removed if it's its only bind
+func removeUnusedLocalFixes(converter *position.Converter, text string, root ast.Node, name string, start protocol.Position) []quickFix {
+	location := converter.ProtocolToAST(start)
+	stack, err := processing.FindNodeByPosition(root, location)
+	if err != nil {
+		return nil
+	}
+
+	isUnusedBind := func(bind ast.LocalBind) bool {
+		return string(bind.Variable) == name && processing.LocalBindToRange(bind).SelectionRange.Begin == location
+	}
+	for !stack.IsEmpty() {
+		var begin, end int
+		switch node := stack.Pop().(type) {
+		case *ast.Local:
+			for i, bind := range node.Binds {
+				if isUnusedBind(bind) {
+					begin, end = localBindDeletion(text, node, i)
+				}
+			}
+		case *ast.DesugaredObject:
+			for _, bind := range node.Locals {
+				if isUnusedBind(bind) {
+					begin, end = objectLocalDeletion(text, bind)
+				}
+			}
+		}
+		if end > begin {
+			return []quickFix{{
+				title: fmt.Sprintf("Remove unused local '%s'", name),
+				edits: []protocol.TextEdit{{Range: offsetRange(converter, text, begin, end)}},
+			}}
+		}
+	}
+	return nil
+}
+
+// localBindDeletion returns the offsets of the text to remove to delete a bind of a local expression
+func localBindDeletion(text string, local *ast.Local, i int) (int, int) {
+	binds := local.Binds
+	switch {
+	case len(binds) == 1:
+		end := textOffset(text, bindEnd(binds[0]))
+		semicolon := strings.IndexByte(text[end:], ';')
+		if semicolon < 0 {
+			return 0, 0
+		}
+		return expandToLines(text, textOffset(text, local.LocRange.Begin), end+semicolon+1)
+	case i < len(binds)-1:
+		// `local a = 1, b = 2;` -> `local b = 2;`
+		return textOffset(text, bindBegin(binds[i])), textOffset(text, bindBegin(binds[i+1]))
+	default:
+		// `local a = 1, b = 2;` -> `local a = 1;`
+		return textOffset(text, bindEnd(binds[i-1])), textOffset(text, bindEnd(binds[i]))
+	}
+}
+
+// objectLocalDeletion returns the offsets of the text to remove to delete a local of an object, with its comma
+func objectLocalDeletion(text string, bind ast.LocalBind) (int, int) {
+	begin := strings.LastIndex(text[:textOffset(text, bind.LocRange.Begin)], "local")
+	if begin < 0 {
+		return 0, 0
+	}
+	end := textOffset(text, bindEnd(bind))
+	if rest := strings.TrimLeft(text[end:], " \t"); strings.HasPrefix(rest, ",") {
+		end = len(text) - len(rest) + 1
+	}
+	return expandToLines(text, begin, end)
+}
+
+// expandToLines expands the removed text to whole lines if nothing else is on them, or to the following spaces
+func expandToLines(text string, begin, end int) (int, int) {
+	lineBegin := strings.LastIndexByte(text[:begin], '\n') + 1
+	lineEnd := len(text)
+	if newline := strings.IndexByte(text[end:], '\n'); newline >= 0 {
+		lineEnd = end + newline
+	}
+	if strings.TrimSpace(text[lineBegin:begin]) == "" && strings.TrimSpace(text[end:lineEnd]) == "" {
+		return lineBegin, min(lineEnd+1, len(text))
+	}
+	for end < len(text) && (text[end] == ' ' || text[end] == '\t') {
+		end++
+	}
+	return begin, end
+}
+
+// unknownVariableFixes suggests the variables in scope that have a name close to the unknown one
+func unknownVariableFixes(converter *position.Converter, filename, text, name string, diagRange protocol.Range) []quickFix {
+	// The document doesn't pass static analysis, the scope is found in its raw AST
+	root, _, err := formatter.SnippetToRawAST(filename, text)
+	if err != nil {
+		return nil
+	}
+
+	var fixes []quickFix
+	for _, suggestion := range closestNames(name, scopeNames(root, converter.ProtocolToAST(diagRange.Start))) {
+		fixes = append(fixes, quickFix{
+			title: fmt.Sprintf("Change to '%s'", suggestion),
+			edits: []protocol.TextEdit{{Range: diagRange, NewText: suggestion}},
+		})
+	}
+	return fixes
+}
+
+// scopeNames returns the names of the variables visible at a location of a raw AST
+func scopeNames(root ast.Node, location ast.Location) []string {
+	names := []string{"std"}
+	addSpec := func(spec *ast.ForSpec) {
+		for ; spec != nil; spec = spec.Outer {
+			names = append(names, string(spec.VarName))
+		}
+	}
+	addObjectLocals := func(fields ast.ObjectFields) {
+		for _, field := range fields {
+			if field.Kind == ast.ObjectLocal && field.Id != nil {
+				names = append(names, string(*field.Id))
+			}
+		}
+	}
+
+	for node := root; node != nil; {
+		switch node := node.(type) {
+		case *ast.Local:
+			for _, bind := range node.Binds {
+				names = append(names, string(bind.Variable))
+			}
+		case *ast.Function:
+			for _, param := range node.Parameters {
+				names = append(names, string(param.Name))
+			}
+		case *ast.Object:
+			addObjectLocals(node.Fields)
+		case *ast.ObjectComp:
+			addObjectLocals(node.Fields)
+			addSpec(&node.Spec)
+		case *ast.ArrayComp:
+			addSpec(&node.Spec)
+		}
+
+		var next ast.Node
+		for _, child := range toolutils.Children(node) {
+			if loc := child.Loc(); loc != nil && loc.Begin.IsSet() && processing.InRange(location, *loc) {
+				next = child
+				break
+			}
+		}
+		node = next
+	}
+	return names
+}
+
+// importFixes imports the workspace files named after an unknown variable.
+// The workspace is indexed in the background, only the files indexed so far are suggested
+func (s *Server) importFixes(filename, text, name string) []quickFix {
+	s.buildWorkspaceSymbolsInBackground()
+
+	var fixes []quickFix
+	line := uint32(importInsertLine(text))
+	for _, candidate := range s.workspaceSymbols.filenames() {
+		if candidate == utils.AbsFilename(filename) || !isImportCandidate(candidate, name) {
+			continue
+		}
+		importPath, ok := s.importPath(filename, candidate)
+		if !ok {
+			continue
+		}
+		insertAt := protocol.Position{Line: line}
+		fixes = append(fixes, quickFix{
+			title: fmt.Sprintf("Import '%s' as %s", importPath, name),
+			edits: []protocol.TextEdit{{
+				Range:   protocol.Range{Start: insertAt, End: insertAt},
+				NewText: fmt.Sprintf("local %s = import '%s';\n", name, importPath),
+			}},
+		})
+	}
+	return fixes
+}
+
+// isImportCandidate returns true if the file is named after the variable: `name.libsonnet` or `name/main.libsonnet`
+func isImportCandidate(path, name string) bool {
+	base := filepath.Base(path)
+	if strings.TrimSuffix(base, filepath.Ext(base)) == name {
+		return true
+	}
+	return base == "main.libsonnet" && filepath.Base(filepath.Dir(path)) == name
+}
+
+// importPath returns the shortest path that imports the target file from the given file
+func (s *Server) importPath(filename, target string) (string, bool) {
+	var candidates []string
+	for _, jpath := range s.configuration.JPaths {
+		jpath, err := filepath.Abs(jpath)
+		if err != nil {
+			continue
+		}
+		if rel, err := filepath.Rel(jpath, target); err == nil && !strings.HasPrefix(rel, "..") {
+			candidates = append(candidates, filepath.ToSlash(rel))
+		}
+	}
+	if rel, err := filepath.Rel(filepath.Dir(utils.AbsFilename(filename)), target); err == nil {
+		candidates = append(candidates, filepath.ToSlash(rel))
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return len(candidates[i]) < len(candidates[j])
+	})
+
+	// The path must resolve to the target, another file with the same path could be found first
+	for _, candidate := range candidates {
+		if uri, err := s.resolveImportPath(filename, candidate); err == nil && uri == protocol.URIFromPath(target) {
+			return candidate, true
+		}
+	}
+	return "", false
+}
+
+// importInsertLine returns the line where a new import is added: after the imports at the top of the file, or before the code
+func importInsertLine(text string) int {
+	lines := strings.Split(text, "\n")
+	insertLine, firstCodeLine := -1, len(lines)-1
+	for i, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case trimmed == "", strings.HasPrefix(trimmed, "//"), strings.HasPrefix(trimmed, "#"):
+			continue
+		case importLineRegexp.MatchString(trimmed):
+			insertLine = i + 1
+			continue
+		}
+		firstCodeLine = i
+		break
+	}
+	if insertLine < 0 {
+		return firstCodeLine
+	}
+	return insertLine
+}
+
+// unknownFieldFixes suggests the fields of the indexed object that have a name close to the unknown one
+func unknownFieldFixes(converter *position.Converter, processor *processing.Processor, root ast.Node, name string, diagRange protocol.Range) []quickFix {
+	var index ast.Node
+	for _, node := range processing.FindNodes(root, func(node ast.Node) bool {
+		_, ok := node.(*ast.Index)
+		return ok && converter.RangeASTToProtocol(*node.Loc()) == diagRange
+	}) {
+		index = node
+	}
+	if index == nil {
+		return nil
+	}
+	nameRange, ok := processing.IndexNameRange(index)
+	if !ok {
+		return nil
+	}
+	stack, err := processing.FindNodeByPosition(root, nameRange.Begin)
+	if err != nil {
+		return nil
+	}
+
+	// All the fields of the indexed object are found by matching an empty prefix
+	indexList := nodestack.NewNodeStack(index).BuildIndexList()
+	if len(indexList) < 2 || indexList[len(indexList)-1] != name {
+		return nil
+	}
+	indexList[len(indexList)-1] = ""
+	ranges, err := processor.FindRangesFromIndexList(stack, indexList, true)
+	if err != nil {
+		log.Debugf("CodeAction: could not find the fields of %s: %v", strings.Join(indexList[:len(indexList)-1], "."), err)
+		return nil
+	}
+
+	var fields []string
+	for _, r := range ranges {
+		if r.FieldName != "" {
+			fields = append(fields, r.FieldName)
+		}
+	}
+	var fixes []quickFix
+	for _, suggestion := range closestNames(name, fields) {
+		fixes = append(fixes, quickFix{
+			title: fmt.Sprintf("Change to '%s'", suggestion),
+			edits: []protocol.TextEdit{{Range: converter.RangeASTToProtocol(nameRange), NewText: suggestion}},
+		})
+	}
+	return fixes
+}
+
+// deprecatedStdFixes replaces a deprecated std function by the one to use instead
+func deprecatedStdFixes(converter *position.Converter, text string, root ast.Node, data diagnosticData, diagRange protocol.Range) []quickFix {
+	template, ok := stdCallReplacements[data.Name]
+	if !ok {
+		if data.Replacement == "" {
+			return nil
+		}
+		return []quickFix{{
+			title: fmt.Sprintf("Replace with std.%s", data.Replacement),
+			edits: []protocol.TextEdit{{Range: diagRange, NewText: data.Replacement}},
+		}}
+	}
+
+	// The call is rewritten, its argument is kept
+	for _, node := range processing.FindNodes(root, func(node ast.Node) bool {
+		apply, ok := node.(*ast.Apply)
+		if !ok || len(apply.Arguments.Positional) != 1 || len(apply.Arguments.Named) != 0 {
+			return false
+		}
+		nameRange, ok := processing.IndexNameRange(apply.Target)
+		return ok && converter.RangeASTToProtocol(nameRange) == diagRange
+	}) {
+		apply := node.(*ast.Apply)
+		argLoc := apply.Arguments.Positional[0].Expr.Loc()
+		argument := text[textOffset(text, argLoc.Begin):textOffset(text, argLoc.End)]
+		replacement := fmt.Sprintf(template, argument)
+		return []quickFix{{
+			title: fmt.Sprintf("Replace with %s", fmt.Sprintf(template, "...")),
+			edits: []protocol.TextEdit{{Range: converter.RangeASTToProtocol(apply.LocRange), NewText: replacement}},
+		}}
+	}
+	return nil
+}
+
+// closestNames returns the candidates that are most likely to be a misspelling of the name, closest first
+func closestNames(name string, candidates []string) []string {
+	maxDistance := max(1, utf8.RuneCountInString(name)/3)
+	distances := map[string]int{}
+	for _, candidate := range candidates {
+		if candidate == name || candidate == "$" {
+			continue
+		}
+		if distance := editDistance(name, candidate); distance <= maxDistance {
+			distances[candidate] = distance
+		}
+	}
+
+	var names []string
+	for candidate := range distances {
+		names = append(names, candidate)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if distances[names[i]] != distances[names[j]] {
+			return distances[names[i]] < distances[names[j]]
+		}
+		return names[i] < names[j]
+	})
+	if len(names) > maxSuggestions {
+		names = names[:maxSuggestions]
+	}
+	return names
+}
+
+// editDistance returns the Levenshtein distance between two strings
+func editDistance(a, b string) int {
+	aRunes, bRunes := []rune(a), []rune(b)
+	previous := make([]int, len(bRunes)+1)
+	current := make([]int, len(bRunes)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(aRunes); i++ {
+		current[0] = i
+		for j := 1; j <= len(bRunes); j++ {
+			cost := 1
+			if aRunes[i-1] == bRunes[j-1] {
+				cost = 0
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+	return previous[len(bRunes)]
+}
+
+// offsetRange returns the range between two offsets of the text
+func offsetRange(converter *position.Converter, text string, begin, end int) protocol.Range {
+	return protocol.Range{Start: offsetPosition(converter, text, begin), End: offsetPosition(converter, text, end)}
+}
+
+// offsetPosition returns the position of an offset of the text
+func offsetPosition(converter *position.Converter, text string, offset int) protocol.Position {
+	before := text[:offset]
+	lineBegin := strings.LastIndexByte(before, '\n') + 1
+	return converter.ASTToProtocol(ast.Location{Line: strings.Count(before, "\n") + 1, Column: offset - lineBegin + 1})
+}
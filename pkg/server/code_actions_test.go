@@ -0,0 +1,220 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/grafana/jsonnet-language-server/pkg/stdlib"
+	"github.com/jdbaldry/go-language-server-protocol/lsp/protocol"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// codeActionResult is a code action and the text of the document once it's applied
+type codeActionResult struct {
+	title     string
+	preferred bool
+	result    string
+}
+
+// documentQuickFixes returns the quick fixes of all the diagnostics of a document, applied to its text
+func documentQuickFixes(t *testing.T, server *Server, uri protocol.DocumentURI) []codeActionResult {
+	t.Helper()
+
+	doc, err := server.cache.Get(uri)
+	require.NoError(t, err)
+	diags := append(server.getEvalDiags(doc), server.getLintDiags(doc)...)
+
+	// The diagnostics are sent back by the client as JSON
+	var clientDiags []protocol.Diagnostic
+	require.NoError(t, unmarshalParams(diags, &clientDiags))
+
+	actions, err := server.CodeAction(context.Background(), &protocol.CodeActionParams{
+		TextDocument: protocol.TextDocumentIdentifier{URI: uri},
+		Context:      protocol.CodeActionContext{Diagnostics: clientDiags},
+	})
+	require.NoError(t, err)
+
+	results := []codeActionResult{}
+	for _, action := range actions {
+		assert.Equal(t, protocol.QuickFix, action.Kind)
+		results = append(results, codeActionResult{
+			title:     action.Title,
+			preferred: action.IsPreferred,
+			result:    applyTextEdits(t, doc.Item.Text, action.Edit.Changes[string(uri)]),
+		})
+	}
+	return results
+}
+
+func TestCodeActionQuickFixes(t *testing.T) {
+	functions, err := stdlib.Functions()
+	require.NoError(t, err)
+
+	for _, tc := range []struct {
+		name        string
+		fileContent string
+		expected    []codeActionResult
+	}{
+		{
+			name:        "no diagnostics",
+			fileContent: "{}\n",
+			expected:    []codeActionResult{},
+		},
+		{
+			name:        "remove unused local",
+			fileContent: "local unused = 'test';\n{}\n",
+			expected: []codeActionResult{
+				{title: "Remove unused local 'unused'", preferred: true, result: "{}\n"},
+			},
+		},
+		{
+			name:        "remove first of multiple binds",
+			fileContent: "local a = 1, b = 2;\nb\n",
+			expected: []codeActionResult{
+				{title: "Remove unused local 'a'", preferred: true, result: "local b = 2;\nb\n"},
+			},
+		},
+		{
+			name:        "remove last of multiple binds",
+			fileContent: "local a = 1, b = 2;\na\n",
+			expected: []codeActionResult{
+				{title: "Remove unused local 'b'", preferred: true, result: "local a = 1;\na\n"},
+			},
+		},
+		{
+			name:        "remove unused object local",
+			fileContent: "{\n  local unused = 1,\n  a: 2,\n}\n",
+			expected: []codeActionResult{
+				{title: "Remove unused local 'unused'", preferred: true, result: "{\n  a: 2,\n}\n"},
+			},
+		},
+		{
+			name:        "remove unused local on the line of its body",
+			fileContent: "local unused = 1; {}\n",
+			expected: []codeActionResult{
+				{title: "Remove unused local 'unused'", preferred: true, result: "{}\n"},
+			},
+		},
+		{
+			name:        "unknown variable",
+			fileContent: "local config = {};\nconfg\n",
+			expected: []codeActionResult{
+				{title: "Change to 'config'", preferred: true, result: "local config = {};\nconfig\n"},
+			},
+		},
+		{
+			name:        "unknown variable with several suggestions",
+			fileContent: "local replica = 1, replicas = 2;\nfunction(replicaz) replicas + replicaz + replicax\n",
+			expected: []codeActionResult{
+				{title: "Change to 'replica'", result: "local replica = 1, replicas = 2;\nfunction(replicaz) replicas + replicaz + replica\n"},
+				{title: "Change to 'replicas'", result: "local replica = 1, replicas = 2;\nfunction(replicaz) replicas + replicaz + replicas\n"},
+				{title: "Change to 'replicaz'", result: "local replica = 1, replicas = 2;\nfunction(replicaz) replicas + replicaz + replicaz\n"},
+			},
+		},
+		{
+			name:        "unknown variable out of scope",
+			fileContent: "{\n  a: local config = {}; config,\n  b: confg,\n}\n",
+			expected:    []codeActionResult{},
+		},
+		{
+			name:        "unknown field",
+			fileContent: "local o = { name: 'a', namespace: 'b' };\no.nam\n",
+			expected: []codeActionResult{
+				{title: "Change to 'name'", preferred: true, result: "local o = { name: 'a', namespace: 'b' };\no.name\n"},
+			},
+		},
+		{
+			name:        "deprecated std function",
+			fileContent: "{ a: std.base64Decode('YQ==') }\n",
+			expected: []codeActionResult{
+				{
+					title:     "Replace with std.decodeUTF8(std.base64DecodeBytes(...))",
+					preferred: true,
+					result:    "{ a: std.decodeUTF8(std.base64DecodeBytes('YQ==')) }\n",
+				},
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			server, uri := testServerWithFile(t, functions, tc.fileContent)
+			server.configuration.EnableEvalDiagnostics = true
+
+			assert.Equal(t, tc.expected, documentQuickFixes(t, server, uri))
+		})
+	}
+}
+
+func TestCodeActionDeprecatedDiagnostic(t *testing.T) {
+	functions, err := stdlib.Functions()
+	require.NoError(t, err)
+	server, uri := testServerWithFile(t, functions, "std.base64Decode('YQ==')")
+	doc, err := server.cache.Get(uri)
+	require.NoError(t, err)
+
+	assert.Equal(t, []protocol.Diagnostic{
+		{
+			Range: protocol.Range{
+				Start: protocol.Position{Line: 0, Character: 4},
+				End:   protocol.Position{Line: 0, Character: 16},
+			},
+			Severity: protocol.SeverityWarning,
+			Code:     deprecatedStdCode,
+			Source:   "lint",
+			Message:  "std.base64Decode is deprecated, use std.base64DecodeBytes instead",
+			Tags:     []protocol.DiagnosticTag{protocol.Deprecated},
+			Data:     diagnosticData{Name: "base64Decode", Replacement: "base64DecodeBytes"},
+		},
+	}, server.getLintDiags(doc))
+}
+
+func TestCodeActionImport(t *testing.T) {
+	server := NewServer("any", "test version", nil, Configuration{
+		JPaths: []string{"testdata/code-actions/lib"},
+	})
+	server.setWorkspaceRoots(&protocol.ParamInitialize{
+		InitializeParams: protocol.InitializeParams{RootURI: absURI(t, "testdata/code-actions")},
+	})
+	uri := serverOpenTestFile(t, server, "testdata/code-actions/main.jsonnet")
+
+	imported := func(importLine string) string {
+		return "// Deployment of the frontend\n" +
+			"local config = import 'config.libsonnet';\n" +
+			importLine +
+			"\n{\n  name: config.name,\n  labels: helpers.labels(config.name),\n}\n"
+	}
+	expected := []codeActionResult{
+		{title: "Import 'helpers.libsonnet' as helpers", result: imported("local helpers = import 'helpers.libsonnet';\n")},
+		{title: "Import 'helpers/main.libsonnet' as helpers", result: imported("local helpers = import 'helpers/main.libsonnet';\n")},
+	}
+
+	// The first code action starts indexing the workspace without waiting for it
+	documentQuickFixes(t, server, uri)
+	require.Eventually(t, server.workspaceSymbols.isBuilt, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, expected, documentQuickFixes(t, server, uri))
+}
+
+func TestCodeActionOnlyKinds(t *testing.T) {
+	server, uri := testServerWithFile(t, nil, "local unused = 'test';\n{}\n")
+	doc, err := server.cache.Get(uri)
+	require.NoError(t, err)
+
+	var diags []protocol.Diagnostic
+	require.NoError(t, unmarshalParams(server.getLintDiags(doc), &diags))
+	for _, tc := range []struct {
+		only     []protocol.CodeActionKind
+		expected int
+	}{
+		{only: nil, expected: 1},
+		{only: []protocol.CodeActionKind{protocol.QuickFix}, expected: 1},
+		{only: []protocol.CodeActionKind{protocol.Refactor}, expected: 0},
+	} {
+		actions, err := server.CodeAction(context.Background(), &protocol.CodeActionParams{
+			TextDocument: protocol.TextDocumentIdentifier{URI: uri},
+			Context:      protocol.CodeActionContext{Diagnostics: diags, Only: tc.only},
+		})
+		require.NoError(t, err)
+		assert.Len(t, actions, tc.expected, "only: %v", tc.only)
+	}
+}
@@ -0,0 +1,165 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/google/go-jsonnet/ast"
+	"github.com/grafana/jsonnet-language-server/pkg/ast/processing"
+	"github.com/grafana/jsonnet-language-server/pkg/utils"
+	"github.com/jdbaldry/go-language-server-protocol/lsp/protocol"
+	log "github.com/sirupsen/logrus"
+)
+
+// codeLensRefreshDelay is the time without changes to wait for before asking the client to request the code lenses again
+const codeLensRefreshDelay = 500 * time.Millisecond
+
+// codeLensData is kept in the reference count lenses until they are resolved
+type codeLensData struct {
+	URI      protocol.DocumentURI `json:"uri"`
+	Position protocol.Position    `json:"position"`
+}
+
+func (s *Server) CodeLens(_ context.Context, params *protocol.CodeLensParams) ([]protocol.CodeLens, error) {
+	doc, err := s.cache.Get(params.TextDocument.URI)
+	if err != nil {
+		return nil, utils.LogErrorf("CodeLens: %s: %w", errorRetrievingDocument, err)
+	}
+
+	lenses := []protocol.CodeLens{}
+	// Lenses are positioned relatively to the text, they can't be computed from an outdated AST
+	if doc.AST == nil || len(doc.LinesChangedSinceAST) > 0 {
+		log.Debugf("CodeLens: document was changed since last successful parse")
+		return lenses, nil
+	}
+
+	filename := doc.Item.URI.SpanURI().Filename()
+	locals, object := topLevelDefinitions(doc.AST)
+	converter := s.converter(doc.Item.Text)
+	switch filepath.Ext(filename) {
+	case ".libsonnet":
+		// The reference counts are resolved lazily, finding the importers of the file is expensive
+		addReferencesLens := func(nameRange ast.LocationRange) {
+			lensRange := converter.RangeASTToProtocol(nameRange)
+			lenses = append(lenses, protocol.CodeLens{
+				Range: lensRange,
+				Data:  codeLensData{URI: doc.Item.URI, Position: lensRange.Start},
+			})
+		}
+		for _, bind := range locals {
+			addReferencesLens(processing.LocalBindToRange(bind).SelectionRange)
+		}
+		if object != nil {
+			for _, field := range object.Fields {
+				if nameRange, ok := processing.FieldNameRange(field); ok {
+					addReferencesLens(nameRange)
+				}
+			}
+		}
+	case ".jsonnet":
+		rootRange := converter.RangeASTToProtocol(*doc.AST.Loc())
+		lenses = append(lenses, protocol.CodeLens{
+			Range:   protocol.Range{Start: rootRange.Start, End: rootRange.Start},
+			Command: evalCommand("Evaluate", "jsonnet.evalFile", filename),
+		})
+		if object != nil {
+			for _, field := range object.Fields {
+				nameRange, ok := processing.FieldNameRange(field)
+				name, isString := field.Name.(*ast.LiteralString)
+				if !ok || !isString || !identifierRegexp.MatchString(name.Value) {
+					continue
+				}
+				lenses = append(lenses, protocol.CodeLens{
+					Range:   converter.RangeASTToProtocol(nameRange),
+					Command: evalCommand("Evaluate field", "jsonnet.evalExpression", filename, name.Value),
+				})
+			}
+		}
+	}
+	return lenses, nil
+}
+
+func (s *Server) ResolveCodeLens(_ context.Context, params *protocol.CodeLens) (*protocol.CodeLens, error) {
+	if params.Command.Command != "" || params.Command.Title != "" {
+		return params, nil
+	}
+
+	var data codeLensData
+	if err := unmarshalParams(params.Data, &data); err != nil {
+		return nil, utils.LogErrorf("ResolveCodeLens: %w", err)
+	}
+	filename := data.URI.SpanURI().Filename()
+	processor := processing.NewProcessor(s.cache, s.getVM(filename))
+	root, err := processor.GetAST(filename)
+	if err != nil {
+		return nil, utils.LogErrorf("ResolveCodeLens: %s: %w", errorParsingDocument, err)
+	}
+	symbol, err := findTargetSymbol(processor, root, s.fileConverter(data.URI).ProtocolToAST(data.Position))
+	if err != nil {
+		return nil, utils.LogErrorf("ResolveCodeLens: %w", err)
+	}
+	usages, _, err := processor.FindUsages(symbol.files(), symbol.name, symbol.definitions)
+	if err != nil {
+		return nil, utils.LogErrorf("ResolveCodeLens: error finding usages: %w", err)
+	}
+
+	title := fmt.Sprintf("%d references", len(usages))
+	if len(usages) == 1 {
+		title = "1 reference"
+	}
+	return &protocol.CodeLens{
+		Range: params.Range,
+		// The count is informative, there's no standard command to show the references
+		Command: protocol.Command{Title: title},
+	}, nil
+}
+
+// refreshCodeLenses asks the client to request the code lenses again once the changes settle, the reference counts may have changed.
+// Every lens is resolved again after a refresh, so a burst of changes refreshes them once
+func (s *Server) refreshCodeLenses() {
+	refresher, ok := s.client.(codeLensRefresher)
+	if !s.codeLensRefreshSupport || !ok {
+		return
+	}
+
+	s.codeLensRefreshMutex.Lock()
+	defer s.codeLensRefreshMutex.Unlock()
+	if s.codeLensRefreshTimer == nil {
+		s.codeLensRefreshTimer = time.AfterFunc(codeLensRefreshDelay, func() {
+			if err := refresher.CodeLensRefresh(context.Background()); err != nil {
+				log.Debugf("CodeLensRefresh: %v", err)
+			}
+		})
+	} else {
+		s.codeLensRefreshTimer.Reset(codeLensRefreshDelay)
+	}
+}
+
+// topLevelDefinitions returns the locals defined at the top of a file, and the object it evaluates to, if any
+func topLevelDefinitions(root ast.Node) (ast.LocalBinds, *ast.DesugaredObject) {
+	var locals ast.LocalBinds
+	node := root
+	for {
+		local, ok := node.(*ast.Local)
+		if !ok {
+			break
+		}
+		locals = append(locals, local.Binds...)
+		node = local.Body
+	}
+	object, _ := node.(*ast.DesugaredObject)
+	return locals, object
+}
+
+func evalCommand(title, command string, arguments ...string) protocol.Command {
+	result := protocol.Command{Title: title, Command: command}
+	for _, argument := range arguments {
+		// Marshalling a string can't fail
+		marshalled, _ := json.Marshal(argument)
+		result.Arguments = append(result.Arguments, marshalled)
+	}
+	return result
+}
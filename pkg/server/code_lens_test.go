@@ -0,0 +1,180 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jdbaldry/go-language-server-protocol/lsp/protocol"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func codeLensServer(t *testing.T) *Server {
+	t.Helper()
+
+	server := NewServer("any", "test version", nil, Configuration{
+		JPaths: []string{"testdata/code-lens"},
+	})
+	serverOpenTestFile(t, server, "testdata/code-lens/lib.libsonnet")
+	serverOpenTestFile(t, server, "testdata/code-lens/main.jsonnet")
+	return server
+}
+
+func TestCodeLensReferences(t *testing.T) {
+	server := codeLensServer(t)
+	uri := protocol.URIFromPath("testdata/code-lens/lib.libsonnet")
+
+	lenses, err := server.CodeLens(context.Background(), &protocol.CodeLensParams{
+		TextDocument: protocol.TextDocumentIdentifier{URI: uri},
+	})
+	require.NoError(t, err)
+
+	// The lenses are resolved by the client, which sends back their data as JSON
+	var clientLenses []protocol.CodeLens
+	require.NoError(t, unmarshalParams(lenses, &clientLenses))
+
+	type resolvedLens struct {
+		line  uint32
+		title string
+	}
+	var resolved []resolvedLens
+	for _, lens := range clientLenses {
+		assert.Empty(t, lens.Command.Title)
+		result, err := server.ResolveCodeLens(context.Background(), &lens)
+		require.NoError(t, err)
+		assert.Equal(t, lens.Range, result.Range)
+		resolved = append(resolved, resolvedLens{line: result.Range.Start.Line, title: result.Command.Title})
+	}
+
+	assert.Equal(t, []resolvedLens{
+		{line: 0, title: "1 reference"},
+		{line: 1, title: "0 references"},
+		{line: 3, title: "1 reference"},
+		{line: 4, title: "3 references"},
+		{line: 5, title: "0 references"},
+	}, resolved)
+}
+
+func TestCodeLensEvaluate(t *testing.T) {
+	server := codeLensServer(t)
+	filename, err := filepath.Abs("testdata/code-lens/main.jsonnet")
+	require.NoError(t, err)
+
+	lenses, err := server.CodeLens(context.Background(), &protocol.CodeLensParams{
+		TextDocument: protocol.TextDocumentIdentifier{URI: protocol.URIFromPath("testdata/code-lens/main.jsonnet")},
+	})
+	require.NoError(t, err)
+
+	lensRange := func(line, startCharacter, endCharacter uint32) protocol.Range {
+		return protocol.Range{
+			Start: protocol.Position{Line: line, Character: startCharacter},
+			End:   protocol.Position{Line: line, Character: endCharacter},
+		}
+	}
+	arguments := func(values ...string) []json.RawMessage {
+		var result []json.RawMessage
+		for _, value := range values {
+			marshalled, err := json.Marshal(value)
+			require.NoError(t, err)
+			result = append(result, marshalled)
+		}
+		return result
+	}
+
+	// Quoted field names can't be evaluated as an expression
+	assert.Equal(t, []protocol.CodeLens{
+		{
+			Range:   lensRange(0, 0, 0),
+			Command: protocol.Command{Title: "Evaluate", Command: "jsonnet.evalFile", Arguments: arguments(filename)},
+		},
+		{
+			Range:   lensRange(2, 2, 6),
+			Command: protocol.Command{Title: "Evaluate field", Command: "jsonnet.evalExpression", Arguments: arguments(filename, "name")},
+		},
+		{
+			Range:   lensRange(3, 2, 10),
+			Command: protocol.Command{Title: "Evaluate field", Command: "jsonnet.evalExpression", Arguments: arguments(filename, "replicas")},
+		},
+	}, lenses)
+
+	// The lens commands evaluate the file
+	for _, lens := range lenses {
+		_, err := server.ExecuteCommand(context.Background(), &protocol.ExecuteCommandParams{
+			Command:   lens.Command.Command,
+			Arguments: lens.Command.Arguments,
+		})
+		require.NoError(t, err)
+	}
+}
+
+func TestCodeLensStaleAST(t *testing.T) {
+	server := codeLensServer(t)
+	uri := protocol.URIFromPath("testdata/code-lens/lib.libsonnet")
+	doc, err := server.cache.Get(uri)
+	require.NoError(t, err)
+
+	require.NoError(t, server.DidChange(context.Background(), &protocol.DidChangeTextDocumentParams{
+		TextDocument:   protocol.VersionedTextDocumentIdentifier{Version: doc.Item.Version + 1, TextDocumentIdentifier: protocol.TextDocumentIdentifier{URI: uri}},
+		ContentChanges: []protocol.TextDocumentContentChangeEvent{{Text: doc.Item.Text + "{"}},
+	}))
+
+	lenses, err := server.CodeLens(context.Background(), &protocol.CodeLensParams{
+		TextDocument: protocol.TextDocumentIdentifier{URI: uri},
+	})
+	require.NoError(t, err)
+	assert.Empty(t, lenses)
+}
+
+func TestRemoveEmptyCommands(t *testing.T) {
+	lenses, err := removeEmptyCommands([]protocol.CodeLens{
+		{Data: codeLensData{URI: "file:///lib.libsonnet"}},
+		{Command: protocol.Command{Title: "Evaluate", Command: "jsonnet.evalFile"}},
+	})
+	require.NoError(t, err)
+
+	require.Len(t, lenses, 2)
+	assert.NotContains(t, lenses[0], "command")
+	assert.Contains(t, lenses[0], "data")
+	assert.Contains(t, lenses[1], "command")
+}
+
+// refreshingClient counts the code lens refresh requests
+type refreshingClient struct {
+	protocol.ClientCloser
+	refreshes atomic.Int32
+}
+
+func (c *refreshingClient) CodeLensRefresh(context.Context) error {
+	c.refreshes.Add(1)
+	return nil
+}
+
+func TestCodeLensRefreshDebounced(t *testing.T) {
+	client := &refreshingClient{}
+	server := NewServer("any", "test version", client, Configuration{})
+	server.codeLensRefreshSupport = true
+	uri := protocol.URIFromPath(filepath.Join(t.TempDir(), "main.jsonnet"))
+	require.NoError(t, server.DidOpen(context.Background(), &protocol.DidOpenTextDocumentParams{
+		TextDocument: protocol.TextDocumentItem{URI: uri, Text: "{}", Version: 1},
+	}))
+
+	// Each change parses, but the lenses are refreshed once the changes settle
+	for version := int32(2); version <= 20; version++ {
+		require.NoError(t, server.DidChange(context.Background(), &protocol.DidChangeTextDocumentParams{
+			TextDocument: protocol.VersionedTextDocumentIdentifier{
+				TextDocumentIdentifier: protocol.TextDocumentIdentifier{URI: uri},
+				Version:                version,
+			},
+			ContentChanges: []protocol.TextDocumentContentChangeEvent{{Text: fmt.Sprintf("{ a: %d }", version)}},
+		}))
+	}
+	assert.Equal(t, int32(0), client.refreshes.Load())
+	require.Eventually(t, func() bool { return client.refreshes.Load() == 1 }, 5*time.Second, 10*time.Millisecond)
+	time.Sleep(2 * codeLensRefreshDelay)
+	assert.Equal(t, int32(1), client.refreshes.Load())
+}
@@ -11,7 +11,6 @@ import (
 	"github.com/google/go-jsonnet/formatter"
 	"github.com/grafana/jsonnet-language-server/pkg/ast/processing"
 	"github.com/grafana/jsonnet-language-server/pkg/nodestack"
-	position "github.com/grafana/jsonnet-language-server/pkg/position_conversion"
 	"github.com/grafana/jsonnet-language-server/pkg/utils"
 	"github.com/jdbaldry/go-language-server-protocol/lsp/protocol"
 	log "github.com/sirupsen/logrus"
@@ -23,7 +22,8 @@ func (s *Server) Completion(_ context.Context, params *protocol.CompletionParams
 		return nil, utils.LogErrorf("Completion: %s: %w", errorRetrievingDocument, err)
 	}
 
-	line := getCompletionLine(doc.Item.Text, params.Position)
+	location := s.converter(doc.Item.Text).ProtocolToAST(params.Position)
+	line := getCompletionLine(doc.Item.Text, location)
 
 	// Short-circuit if it's a stdlib completion
 	if items := s.completionStdLib(line); len(items) > 0 {
@@ -36,7 +36,7 @@ func (s *Server) Completion(_ context.Context, params *protocol.CompletionParams
 		return nil, nil
 	}
 
-	searchStack, err := processing.FindNodeByPosition(doc.AST, position.ProtocolToAST(params.Position))
+	searchStack, err := processing.FindNodeByPosition(doc.AST, location)
 	if err != nil {
 		log.Errorf("Completion: error computing node: %v", err)
 		return nil, nil
@@ -60,9 +60,10 @@ func (s *Server) Completion(_ context.Context, params *protocol.CompletionParams
 	return &protocol.CompletionList{IsIncomplete: false, Items: items}, nil
 }
 
-func getCompletionLine(fileContent string, position protocol.Position) string {
-	line := strings.Split(fileContent, "\n")[position.Line]
-	charIndex := int(position.Character)
+// getCompletionLine returns the text of the line before the location
+func getCompletionLine(fileContent string, location ast.Location) string {
+	line := strings.Split(fileContent, "\n")[location.Line-1]
+	charIndex := location.Column - 1
 	if charIndex > len(line) {
 		charIndex = len(line)
 	}
@@ -3,7 +3,9 @@ package server
 import (
 	"context"
 	"fmt"
+	"math"
 	"reflect"
+	"time"
 
 	"github.com/google/go-jsonnet"
 	"github.com/google/go-jsonnet/formatter"
@@ -20,8 +22,16 @@ type Configuration struct {
 	ExtCode               map[string]string
 	FormattingOptions     formatter.Options
 
+	// Limits of the evaluations. Zero values use the defaults
+	EvalTimeout time.Duration
+	MaxStack    int
+
+	// Time without changes to wait for before diagnosing a document. Zero uses the default
+	DiagnosticsDebounce time.Duration
+
 	EnableEvalDiagnostics     bool
 	EnableLintDiagnostics     bool
+	EnableEvalInlayHints      bool
 	ShowDocstringInCompletion bool
 }
 
@@ -47,6 +57,8 @@ func (s *Server) DidChangeConfiguration(_ context.Context, params *protocol.DidC
 		case "resolve_paths_with_tanka":
 			if boolVal, ok := sv.(bool); ok {
 				s.configuration.ResolvePathsWithTanka = boolVal
+				// The imports resolve differently
+				s.cache.ResetImports()
 			} else {
 				return fmt.Errorf("%w: unsupported settings value for resolve_paths_with_tanka. expected boolean. got: %T", jsonrpc2.ErrInvalidParams, sv)
 			}
@@ -63,6 +75,9 @@ func (s *Server) DidChangeConfiguration(_ context.Context, params *protocol.DidC
 			} else {
 				return fmt.Errorf("%w: unsupported settings value for jpath. expected array of strings. got: %T", jsonrpc2.ErrInvalidParams, sv)
 			}
+			// The JPaths are indexed for workspace symbols, and the imports resolve differently
+			s.workspaceSymbols.reset()
+			s.cache.ResetImports()
 
 		case "enable_eval_diagnostics":
 			if boolVal, ok := sv.(bool); ok {
@@ -76,12 +91,44 @@ func (s *Server) DidChangeConfiguration(_ context.Context, params *protocol.DidC
 			} else {
 				return fmt.Errorf("%w: unsupported settings value for enable_lint_diagnostics. expected boolean. got: %T", jsonrpc2.ErrInvalidParams, sv)
 			}
+		case "enable_eval_inlay_hints":
+			if boolVal, ok := sv.(bool); ok {
+				s.configuration.EnableEvalInlayHints = boolVal
+			} else {
+				return fmt.Errorf("%w: unsupported settings value for enable_eval_inlay_hints. expected boolean. got: %T", jsonrpc2.ErrInvalidParams, sv)
+			}
 		case "show_docstring_in_completion":
 			if boolVal, ok := sv.(bool); ok {
 				s.configuration.ShowDocstringInCompletion = boolVal
 			} else {
 				return fmt.Errorf("%w: unsupported settings value for show_docstring_in_completion. expected boolean. got: %T", jsonrpc2.ErrInvalidParams, sv)
 			}
+		case "eval_timeout":
+			strVal, ok := sv.(string)
+			if !ok {
+				return fmt.Errorf("%w: unsupported settings value for eval_timeout. expected duration string. got: %T", jsonrpc2.ErrInvalidParams, sv)
+			}
+			timeout, err := time.ParseDuration(strVal)
+			if err != nil || timeout < 0 {
+				return fmt.Errorf("%w: unsupported settings value for eval_timeout. expected positive duration. got: %q", jsonrpc2.ErrInvalidParams, strVal)
+			}
+			s.configuration.EvalTimeout = timeout
+		case "diagnostics_debounce":
+			strVal, ok := sv.(string)
+			if !ok {
+				return fmt.Errorf("%w: unsupported settings value for diagnostics_debounce. expected duration string. got: %T", jsonrpc2.ErrInvalidParams, sv)
+			}
+			debounce, err := time.ParseDuration(strVal)
+			if err != nil || debounce < 0 {
+				return fmt.Errorf("%w: unsupported settings value for diagnostics_debounce. expected positive duration. got: %q", jsonrpc2.ErrInvalidParams, strVal)
+			}
+			s.configuration.DiagnosticsDebounce = debounce
+		case "max_stack":
+			maxStack, ok := parseInt(sv)
+			if !ok || maxStack < 0 {
+				return fmt.Errorf("%w: unsupported settings value for max_stack. expected positive integer. got: %v", jsonrpc2.ErrInvalidParams, sv)
+			}
+			s.configuration.MaxStack = maxStack
 		case "ext_vars":
 			newVars, err := s.parseExtVars(sv)
 			if err != nil {
@@ -111,6 +158,17 @@ func (s *Server) DidChangeConfiguration(_ context.Context, params *protocol.DidC
 	return nil
 }
 
+// parseInt returns the integer value of a setting, JSON numbers are decoded as floats
+func parseInt(unparsed interface{}) (int, bool) {
+	switch value := unparsed.(type) {
+	case int:
+		return value, true
+	case float64:
+		return int(value), value == math.Trunc(value)
+	}
+	return 0, false
+}
+
 func (s *Server) parseExtVars(unparsed interface{}) (map[string]string, error) {
 	newVars, ok := unparsed.(map[string]interface{})
 	if !ok {
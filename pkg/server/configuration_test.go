@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"testing"
+	"time"
 
 	"github.com/google/go-jsonnet/formatter"
 	"github.com/jdbaldry/go-language-server-protocol/lsp/protocol"
@@ -286,6 +287,10 @@ func TestConfiguration_Formatting(t *testing.T) {
 				"jpath":                    []interface{}{"blabla", "blabla2"},
 				"enable_eval_diagnostics":  false,
 				"enable_lint_diagnostics":  true,
+				"enable_eval_inlay_hints":  true,
+				"eval_timeout":             "10s",
+				"max_stack":                1000.0,
+				"diagnostics_debounce":     "50ms",
 			},
 			expectedConfiguration: Configuration{
 				FormattingOptions: func() formatter.Options {
@@ -314,8 +319,33 @@ func TestConfiguration_Formatting(t *testing.T) {
 				JPaths:                []string{"blabla", "blabla2"},
 				EnableEvalDiagnostics: false,
 				EnableLintDiagnostics: true,
+				EnableEvalInlayHints:  true,
+				EvalTimeout:           10 * time.Second,
+				MaxStack:              1000,
+				DiagnosticsDebounce:   50 * time.Millisecond,
 			},
 		},
+		{
+			name: "invalid eval timeout",
+			settings: map[string]interface{}{
+				"eval_timeout": "soon",
+			},
+			expectedErr: errors.New(`JSON RPC invalid params: unsupported settings value for eval_timeout. expected positive duration. got: "soon"`),
+		},
+		{
+			name: "invalid diagnostics debounce",
+			settings: map[string]interface{}{
+				"diagnostics_debounce": "-1s",
+			},
+			expectedErr: errors.New(`JSON RPC invalid params: unsupported settings value for diagnostics_debounce. expected positive duration. got: "-1s"`),
+		},
+		{
+			name: "invalid max stack",
+			settings: map[string]interface{}{
+				"max_stack": 1.5,
+			},
+			expectedErr: errors.New("JSON RPC invalid params: unsupported settings value for max_stack. expected positive integer. got: 1.5"),
+		},
 	}
 
 	for _, tc := range testCases {
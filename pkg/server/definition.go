@@ -10,7 +10,6 @@ import (
 	"github.com/google/go-jsonnet/ast"
 	"github.com/grafana/jsonnet-language-server/pkg/ast/processing"
 	"github.com/grafana/jsonnet-language-server/pkg/nodestack"
-	position "github.com/grafana/jsonnet-language-server/pkg/position_conversion"
 	"github.com/grafana/jsonnet-language-server/pkg/utils"
 	"github.com/jdbaldry/go-language-server-protocol/lsp/protocol"
 	log "github.com/sirupsen/logrus"
@@ -62,10 +61,10 @@ func (s *Server) definitionLink(params *protocol.DefinitionParams) ([]protocol.D
 }
 
 func (s *Server) findDefinition(root ast.Node, params *protocol.DefinitionParams, vm *jsonnet.VM) ([]protocol.DefinitionLink, error) {
-	var response []protocol.DefinitionLink
+	var targets []processing.ObjectRange
 	processor := processing.NewProcessor(s.cache, vm)
 
-	searchStack, _ := processing.FindNodeByPosition(root, position.ProtocolToAST(params.Position))
+	searchStack, _ := processing.FindNodeByPosition(root, s.fileConverter(params.TextDocument.URI).ProtocolToAST(params.Position))
 	deepestNode := searchStack.Pop()
 	switch deepestNode := deepestNode.(type) {
 	case *ast.Var:
@@ -85,11 +84,7 @@ func (s *Server) findDefinition(root ast.Node, params *protocol.DefinitionParams
 			return nil, fmt.Errorf("no matching bind found for %s", deepestNode.Id)
 		}
 
-		response = append(response, protocol.DefinitionLink{
-			TargetURI:            protocol.DocumentURI(objectRange.Filename),
-			TargetRange:          position.RangeASTToProtocol(objectRange.FullRange),
-			TargetSelectionRange: position.RangeASTToProtocol(objectRange.SelectionRange),
-		})
+		targets = append(targets, objectRange)
 	case *ast.SuperIndex, *ast.Index:
 		indexSearchStack := nodestack.NewNodeStack(deepestNode)
 		indexList := indexSearchStack.BuildIndexList()
@@ -98,33 +93,36 @@ func (s *Server) findDefinition(root ast.Node, params *protocol.DefinitionParams
 		if err != nil {
 			return nil, err
 		}
-		for _, o := range objectRanges {
-			response = append(response, protocol.DefinitionLink{
-				TargetURI:            protocol.DocumentURI(o.Filename),
-				TargetRange:          position.RangeASTToProtocol(o.FullRange),
-				TargetSelectionRange: position.RangeASTToProtocol(o.SelectionRange),
-			})
-		}
+		targets = append(targets, objectRanges...)
 	case *ast.Import:
 		filename := deepestNode.File.Value
 		importedFile, _ := vm.ResolveImport(string(params.TextDocument.URI), filename)
-		response = append(response, protocol.DefinitionLink{
-			TargetURI: protocol.DocumentURI(importedFile),
-		})
+		// Imports link to the whole file, without a range
+		targets = append(targets, processing.ObjectRange{Filename: importedFile})
 	default:
 		log.Debugf("cannot find definition for node type %T", deepestNode)
 		return nil, fmt.Errorf("cannot find definition")
 	}
 
-	for i, item := range response {
-		link := string(item.TargetURI)
-		if !strings.HasPrefix(link, "file://") {
-			targetFile, err := filepath.Abs(link)
+	var response []protocol.DefinitionLink
+	for _, target := range targets {
+		targetURI := protocol.DocumentURI(target.Filename)
+		if !strings.HasPrefix(target.Filename, "file://") {
+			targetFile, err := filepath.Abs(target.Filename)
 			if err != nil {
 				return nil, err
 			}
-			response[i].TargetURI = protocol.URIFromPath(targetFile)
+			targetURI = protocol.URIFromPath(targetFile)
+		}
+
+		link := protocol.DefinitionLink{TargetURI: targetURI}
+		if target.FullRange.Begin.IsSet() {
+			// The ranges are converted with the text of the target file
+			converter := s.fileConverter(targetURI)
+			link.TargetRange = converter.RangeASTToProtocol(target.FullRange)
+			link.TargetSelectionRange = converter.RangeASTToProtocol(target.SelectionRange)
 		}
+		response = append(response, link)
 	}
 
 	return response, nil
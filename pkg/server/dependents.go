@@ -0,0 +1,87 @@
+package server
+
+import (
+	"time"
+
+	"github.com/google/go-jsonnet"
+	"github.com/google/go-jsonnet/ast"
+	"github.com/jdbaldry/go-language-server-protocol/lsp/protocol"
+	log "github.com/sirupsen/logrus"
+)
+
+// dependentDiagnosticsDelay is the time without changes to wait for before the importers of changed files are diagnosed again
+const dependentDiagnosticsDelay = 500 * time.Millisecond
+
+// recordImports adds the imports of an evaluated document to the import graph of the cache, transitively.
+// The imports of each visited file replace its previous ones. The files imported by the evaluation are cached by its VM,
+// so they're not read again
+func (s *Server) recordImports(vm *jsonnet.VM, filename, text string) {
+	root, err := jsonnet.SnippetToAST(filename, text)
+	if err != nil {
+		return
+	}
+
+	type importer struct {
+		filename string
+		root     ast.Node
+	}
+	visited := map[string]bool{filename: true}
+	queue := []importer{{filename, root}}
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+		var imports []string
+		for _, path := range findImportPaths(current.root) {
+			foundAt, err := vm.ResolveImport(current.filename, path.Value)
+			if err != nil {
+				log.Debugf("recordImports: could not resolve import %s from %s: %v", path.Value, current.filename, err)
+				continue
+			}
+			imports = append(imports, foundAt)
+			if visited[foundAt] || !isJsonnetFile(foundAt) {
+				continue
+			}
+			visited[foundAt] = true
+			if imported, _, err := vm.ImportAST(current.filename, path.Value); err == nil {
+				queue = append(queue, importer{foundAt, imported})
+			}
+		}
+		s.cache.SetImports(current.filename, imports)
+	}
+}
+
+// queueDependentDiagnostics queues the open documents, and the files whose diagnostics were pulled, importing a changed file, directly or not, for diagnostics.
+// They're queued once the changes settle, so that a burst of changes evaluates them once
+func (s *Server) queueDependentDiagnostics(uri protocol.DocumentURI) {
+	s.dependentsMutex.Lock()
+	defer s.dependentsMutex.Unlock()
+
+	s.dependentsPending[uri] = struct{}{}
+	if s.dependentsTimer == nil {
+		s.dependentsTimer = time.AfterFunc(dependentDiagnosticsDelay, s.flushDependentDiagnostics)
+	} else {
+		s.dependentsTimer.Reset(dependentDiagnosticsDelay)
+	}
+}
+
+func (s *Server) flushDependentDiagnostics() {
+	s.dependentsMutex.Lock()
+	changed := s.dependentsPending
+	s.dependentsPending = make(map[protocol.DocumentURI]struct{})
+	s.dependentsMutex.Unlock()
+
+	for uri := range changed {
+		// The files that aren't open are diagnosed again if the client pulled their diagnostics
+		if _, err := s.cache.Get(uri); err != nil && s.hasDiagnosticsResult(uri) {
+			s.queueDiagnostics(uri)
+		}
+		for _, importer := range s.cache.TransitiveImporters(uri.SpanURI().Filename()) {
+			importerURI := protocol.URIFromPath(importer)
+			if _, err := s.cache.Get(importerURI); err != nil && !s.hasDiagnosticsResult(importerURI) {
+				continue
+			}
+			log.Debugf("Queueing diagnostics for %s, it imports %s", importerURI, uri)
+			s.queueDiagnostics(importerURI)
+		}
+	}
+}
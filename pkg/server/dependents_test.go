@@ -0,0 +1,149 @@
+package server
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-jsonnet/formatter"
+	"github.com/grafana/jsonnet-language-server/pkg/stdlib"
+	"github.com/jdbaldry/go-language-server-protocol/lsp/protocol"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// publishingClient records the last diagnostics published for each document
+type publishingClient struct {
+	protocol.ClientCloser
+
+	mu          sync.Mutex
+	diagnostics map[protocol.DocumentURI][]protocol.Diagnostic
+}
+
+func (c *publishingClient) PublishDiagnostics(_ context.Context, params *protocol.PublishDiagnosticsParams) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.diagnostics[params.URI] = params.Diagnostics
+	return nil
+}
+
+func (c *publishingClient) published(uri protocol.DocumentURI) ([]protocol.Diagnostic, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	diags, ok := c.diagnostics[uri]
+	return diags, ok
+}
+
+func TestRecordImports(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"main.jsonnet":   "local lib = import 'lib.libsonnet';\n{ lib: lib, text: importstr 'text.txt' }\n",
+		"lib.libsonnet":  "(import 'base.libsonnet') + (import 'data.json')",
+		"base.libsonnet": "{ lib: import 'lib.libsonnet' }",
+		"data.json":      "{}",
+		"text.txt":       "text",
+	}
+	for name, content := range files {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(content), 0o600))
+	}
+	path := func(name string) string { return filepath.Join(dir, name) }
+
+	server := testServer(t, nil)
+	server.recordImports(server.getVM(path("main.jsonnet")), path("main.jsonnet"), files["main.jsonnet"])
+
+	assert.Equal(t, []string{path("base.libsonnet"), path("main.jsonnet")}, server.cache.TransitiveImporters(path("lib.libsonnet")))
+	assert.Equal(t, []string{path("base.libsonnet"), path("lib.libsonnet"), path("main.jsonnet")}, server.cache.TransitiveImporters(path("data.json")))
+	assert.Equal(t, []string{path("main.jsonnet")}, server.cache.TransitiveImporters(path("text.txt")))
+	assert.Empty(t, server.cache.TransitiveImporters(path("main.jsonnet")))
+}
+
+func TestSavedImportDiagnosesOpenImporters(t *testing.T) {
+	dir := t.TempDir()
+	libFilename, mainFilename := filepath.Join(dir, "lib.libsonnet"), filepath.Join(dir, "main.jsonnet")
+	require.NoError(t, os.WriteFile(libFilename, []byte("{ a: error 'broken' }"), 0o600))
+	require.NoError(t, os.WriteFile(mainFilename, []byte("(import 'lib.libsonnet').a\n"), 0o600))
+
+	client := &publishingClient{diagnostics: map[protocol.DocumentURI][]protocol.Diagnostic{}}
+	server := NewServer("jsonnet-language-server", "dev", client, Configuration{
+		EnableEvalDiagnostics: true,
+		FormattingOptions:     formatter.DefaultOptions(),
+	})
+	server.stdlib = []stdlib.Function{}
+	_, err := server.Initialize(context.Background(), &protocol.ParamInitialize{})
+	require.NoError(t, err)
+	mainURI, libURI := serverOpenTestFile(t, server, mainFilename), serverOpenTestFile(t, server, libFilename)
+
+	require.Eventually(t, func() bool {
+		diags, _ := client.published(mainURI)
+		return len(diags) == 1 && strings.Contains(diags[0].Message, "broken")
+	}, 10*time.Second, 50*time.Millisecond)
+
+	// The library is fixed and saved, the document importing it is evaluated again
+	require.NoError(t, server.DidChange(context.Background(), &protocol.DidChangeTextDocumentParams{
+		TextDocument: protocol.VersionedTextDocumentIdentifier{
+			TextDocumentIdentifier: protocol.TextDocumentIdentifier{URI: libURI},
+			Version:                2,
+		},
+		ContentChanges: []protocol.TextDocumentContentChangeEvent{{Text: "{ a: 1 }"}},
+	}))
+	require.NoError(t, os.WriteFile(libFilename, []byte("{ a: 1 }"), 0o600))
+	require.NoError(t, server.DidSave(context.Background(), &protocol.DidSaveTextDocumentParams{
+		TextDocument: protocol.TextDocumentIdentifier{URI: libURI},
+	}))
+
+	require.Eventually(t, func() bool {
+		diags, ok := client.published(mainURI)
+		return ok && len(diags) == 0
+	}, 10*time.Second, 50*time.Millisecond)
+}
+
+func TestChangedImportDiagnosesOpenImporters(t *testing.T) {
+	dir := t.TempDir()
+	libFilename, mainFilename := filepath.Join(dir, "lib.libsonnet"), filepath.Join(dir, "main.jsonnet")
+	require.NoError(t, os.WriteFile(libFilename, []byte("{ a: error 'broken' }"), 0o600))
+	require.NoError(t, os.WriteFile(mainFilename, []byte("(import 'lib.libsonnet').a\n"), 0o600))
+
+	client := &publishingClient{diagnostics: map[protocol.DocumentURI][]protocol.Diagnostic{}}
+	server := NewServer("jsonnet-language-server", "dev", client, Configuration{
+		EnableEvalDiagnostics: true,
+		FormattingOptions:     formatter.DefaultOptions(),
+	})
+	server.stdlib = []stdlib.Function{}
+	_, err := server.Initialize(context.Background(), &protocol.ParamInitialize{})
+	require.NoError(t, err)
+	mainURI, libURI := serverOpenTestFile(t, server, mainFilename), serverOpenTestFile(t, server, libFilename)
+
+	brokenDiagnostics := func() bool {
+		diags, _ := client.published(mainURI)
+		return len(diags) == 1 && strings.Contains(diags[0].Message, "broken")
+	}
+	require.Eventually(t, brokenDiagnostics, 10*time.Second, 50*time.Millisecond)
+
+	// The library is fixed without being saved, the document importing it is evaluated again with the unsaved text
+	require.NoError(t, server.DidChange(context.Background(), &protocol.DidChangeTextDocumentParams{
+		TextDocument: protocol.VersionedTextDocumentIdentifier{
+			TextDocumentIdentifier: protocol.TextDocumentIdentifier{URI: libURI},
+			Version:                2,
+		},
+		ContentChanges: []protocol.TextDocumentContentChangeEvent{{Text: "{ a: 1 }"}},
+	}))
+	require.Eventually(t, func() bool {
+		diags, ok := client.published(mainURI)
+		return ok && len(diags) == 0
+	}, 10*time.Second, 50*time.Millisecond)
+
+	// Only the diagnostics read the unsaved text, the other features read the files on disk
+	_, err = server.getVM(mainFilename).EvaluateAnonymousSnippet(mainFilename, "(import 'lib.libsonnet').a")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "broken")
+
+	// The library is closed, its unsaved changes are discarded
+	require.NoError(t, server.DidClose(context.Background(), &protocol.DidCloseTextDocumentParams{
+		TextDocument: protocol.TextDocumentIdentifier{URI: libURI},
+	}))
+	require.Eventually(t, brokenDiagnostics, 10*time.Second, 50*time.Millisecond)
+}
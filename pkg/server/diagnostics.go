@@ -3,15 +3,22 @@ package server
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
+	"path/filepath"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
 
+	"github.com/google/go-jsonnet"
+	"github.com/google/go-jsonnet/ast"
 	"github.com/google/go-jsonnet/linter"
+	"github.com/grafana/jsonnet-language-server/pkg/ast/processing"
 	"github.com/grafana/jsonnet-language-server/pkg/cache"
 	position "github.com/grafana/jsonnet-language-server/pkg/position_conversion"
+	"github.com/grafana/jsonnet-language-server/pkg/stdlib"
 	"github.com/jdbaldry/go-language-server-protocol/lsp/protocol"
 	log "github.com/sirupsen/logrus"
 )
@@ -23,14 +30,75 @@ var (
 	// 3. file:line:col-endCol msg
 	// 4. file:(line:col)-(endLine:endCol) msg
 	// https://regex101.com/r/tL5VWi/2
-	errRegexp = regexp.MustCompile(`/[^:]*:` +
+	errRegexp = regexp.MustCompile(`(?P<file>/[^:]*):` +
 		`(?:(?P<startLine1>\d+)` +
 		`|(?P<startLine2>\d+):(?P<startCol2>\d+)` +
 		`|(?:(?P<startLine3>\d+):(?P<startCol3>\d+)-(?P<endCol3>\d+))` +
 		`|(?:\((?P<startLine4>\d+):(?P<startCol4>\d+)\)-\((?P<endLine4>\d+):(?P<endCol4>\d+))\))` +
 		`\s(?P<message>.*)`)
+
+	unusedVariableRegexp  = regexp.MustCompile(`^Unused variable: (\S+)`)
+	unknownVariableRegexp = regexp.MustCompile(`^Unknown variable: (\S+)`)
+	unknownFieldRegexp    = regexp.MustCompile(`^RUNTIME ERROR: Field does not exist: (\S+)`)
+
+	// deprecatedStdRegexp matches the documentation of deprecated std functions, capturing the function to use instead
+	deprecatedStdRegexp = regexp.MustCompile(`Deprecated, use <code>std\.(\w+)</code>`)
+)
+
+// defaultEvalTimeout is the evaluation timeout when none is configured
+const defaultEvalTimeout = 30 * time.Second
+
+var (
+	errEvalTimeout  = errors.New("evaluation timed out")
+	errEvalCanceled = errors.New("evaluation canceled")
 )
 
+// evalAbortedCode is the code of the diagnostic replacing the errors of an evaluation that timed out
+const evalAbortedCode = "eval-aborted"
+
+// Codes of the diagnostics that can be fixed by a code action. Their data is a diagnosticData
+const (
+	unusedVariableCode  = "unused-variable"
+	unknownVariableCode = "unknown-variable"
+	unknownFieldCode    = "unknown-field"
+	deprecatedStdCode   = "deprecated-std"
+)
+
+// diagnosticData holds what's needed to compute the fixes of a diagnostic
+type diagnosticData struct {
+	Name        string `json:"name"`
+	Replacement string `json:"replacement,omitempty"`
+}
+
+// setDiagnosticCode sets the code and data of the diagnostic if the message matches the regexp
+func setDiagnosticCode(diag *protocol.Diagnostic, re *regexp.Regexp, code, message string) {
+	if match := re.FindStringSubmatch(message); match != nil {
+		diag.Code = code
+		diag.Data = diagnosticData{Name: match[1]}
+	}
+}
+
+// evalFrame is a frame of the stack trace of a runtime error. Like in the error, the characters of its range are counted in bytes
+type evalFrame struct {
+	filename string
+	rang     protocol.Range
+	name     string
+}
+
+// parseStackFrames returns the frames of a runtime error that have a location in a file, innermost first
+func parseStackFrames(lines []string) []evalFrame {
+	var frames []evalFrame
+	for _, line := range lines {
+		match := errRegexp.FindStringSubmatch(line)
+		if match == nil {
+			continue
+		}
+		name, rang := parseErrRegexpMatch(match)
+		frames = append(frames, evalFrame{filename: match[errRegexp.SubexpIndex("file")], rang: rang, name: name})
+	}
+	return frames
+}
+
 func parseErrRegexpMatch(match []string) (string, protocol.Range) {
 	get := func(name string) string {
 		idx := errRegexp.SubexpIndex(name)
@@ -74,85 +142,223 @@ func parseErrRegexpMatch(match []string) (string, protocol.Range) {
 	return message, position.NewProtocolRange(line-1, col-1, endLine-1, endCol-1)
 }
 
+// errorRange converts the range of a Jsonnet error, whose characters are counted in bytes, to the encoding of the converter
+func errorRange(converter *position.Converter, rang protocol.Range) protocol.Range {
+	return converter.RangeASTToProtocol(ast.LocationRange{
+		Begin: position.ProtocolToAST(rang.Start),
+		End:   position.ProtocolToAST(rang.End),
+	})
+}
+
+// queueDiagnostics diagnoses a document once its changes settle
 func (s *Server) queueDiagnostics(uri protocol.DocumentURI) {
-	s.diagMutex.Lock()
-	defer s.diagMutex.Unlock()
-	s.diagQueue[uri] = struct{}{}
+	debounce := s.configuration.DiagnosticsDebounce
+	if debounce <= 0 {
+		debounce = defaultDiagnosticsDebounce
+	}
+	s.diagnostics.schedule(uri, debounce)
 }
 
-func (s *Server) diagnosticsLoop() {
-	go func() {
-		for {
-			s.diagMutex.Lock()
-			for uri := range s.diagQueue {
-				if _, ok := s.diagRunning.Load(uri); ok {
-					continue
-				}
+// diagnose evaluates and lints a document, and publishes its diagnostics unless the diagnostics were canceled by a change.
+// The document is diagnosed on a copy, the cached one may be read by other requests at the same time
+func (s *Server) diagnose(ctx context.Context, uri protocol.DocumentURI) {
+	log.Debug("Publishing diagnostics for ", uri)
+	doc, err := s.cache.Get(uri)
+	open := err == nil
+	if !open && s.pullDiagnosticsSupport {
+		// The files of the workspace that aren't open are diagnosed when the client pulls their diagnostics
+		doc, err = s.diskDocument(uri)
+	}
+	if err != nil {
+		// The document was closed since it was queued
+		log.Debugf("publishDiagnostics: %s: %v\n", errorRetrievingDocument, err)
+		return
+	}
+	snapshot := *doc
+	doc = &snapshot
 
-				go func() {
-					s.diagRunning.Store(uri, true)
+	stop := context.AfterFunc(ctx, func() {
+		s.cancelEvaluation(uri)
+	})
+	defer stop()
 
-					log.Debug("Publishing diagnostics for ", uri)
-					doc, err := s.cache.Get(uri)
-					if err != nil {
-						log.Errorf("publishDiagnostics: %s: %v\n", errorRetrievingDocument, err)
-						return
-					}
+	lintChannel := make(chan []protocol.Diagnostic, 1)
+	if s.configuration.EnableLintDiagnostics {
+		go func() {
+			lintChannel <- s.getLintDiags(doc)
+		}()
+	} else {
+		lintChannel <- nil
+	}
+	diags := append([]protocol.Diagnostic{}, s.getEvalDiags(doc)...)
+	diags = append(diags, <-lintChannel...)
+	doc.Diagnostics = diags
+
+	if ctx.Err() != nil || (open && !s.cache.UpdateDiagnostics(doc)) {
+		// The document changed during the diagnostics, it's queued again
+		log.Debug("Discarding outdated diagnostics for ", uri)
+		return
+	}
+	s.publishDiagnostics(uri, diags)
 
-					diags := []protocol.Diagnostic{}
-					evalChannel := make(chan []protocol.Diagnostic, 1)
-					go func() {
-						evalChannel <- s.getEvalDiags(doc)
-					}()
-
-					lintChannel := make(chan []protocol.Diagnostic, 1)
-					if s.configuration.EnableLintDiagnostics {
-						go func() {
-							lintChannel <- s.getLintDiags(doc)
-						}()
-					}
+	// The errors coming from imported files are also shown in these files, if they are open
+	for _, target := range s.setImportedDiags(uri, importedEvalDiags(uri, diags)) {
+		if targetDoc, err := s.cache.Get(target); err == nil {
+			s.publishDiagnostics(target, targetDoc.Diagnostics)
+		}
+	}
 
-					diags = append(diags, <-evalChannel...)
+	log.Debug("Done publishing diagnostics for ", uri)
+}
 
-					if s.configuration.EnableLintDiagnostics {
-						err = s.client.PublishDiagnostics(context.Background(), &protocol.PublishDiagnosticsParams{
-							URI:         uri,
-							Diagnostics: diags,
-						})
-						if err != nil {
-							log.Errorf("publishDiagnostics: unable to publish diagnostics: %v\n", err)
-						}
+// publishDiagnostics publishes the diagnostics of a document, along with the errors coming from it when evaluating other documents.
+// If the client pulls the diagnostics, they're kept until it does
+func (s *Server) publishDiagnostics(uri protocol.DocumentURI, diags []protocol.Diagnostic) {
+	// The diagnostics of a document may be published by the diagnostics of the documents importing it at the same time
+	s.publishMutex.Lock()
+	defer s.publishMutex.Unlock()
+
+	diags = append(append([]protocol.Diagnostic{}, diags...), s.importedDiagsFor(uri)...)
+	if s.pullDiagnosticsSupport {
+		s.storeDiagnostics(uri, diags)
+		return
+	}
+	err := s.client.PublishDiagnostics(context.Background(), &protocol.PublishDiagnosticsParams{
+		URI:         uri,
+		Diagnostics: diags,
+	})
+	if err != nil {
+		log.Errorf("publishDiagnostics: unable to publish diagnostics: %v\n", err)
+	}
+}
 
-						diags = append(diags, <-lintChannel...)
-					}
+// setImportedDiags replaces the diagnostics that the evaluation of a document reports in the files it imports.
+// It returns the files whose diagnostics have changed
+func (s *Server) setImportedDiags(source protocol.DocumentURI, imported map[protocol.DocumentURI][]protocol.Diagnostic) []protocol.DocumentURI {
+	s.importedDiagsMutex.Lock()
+	defer s.importedDiagsMutex.Unlock()
+
+	changed := map[protocol.DocumentURI]bool{}
+	for target, sources := range s.importedDiags {
+		if _, ok := sources[source]; ok {
+			delete(sources, source)
+			changed[target] = true
+		}
+	}
+	for target, diags := range imported {
+		if s.importedDiags[target] == nil {
+			s.importedDiags[target] = map[protocol.DocumentURI][]protocol.Diagnostic{}
+		}
+		s.importedDiags[target][source] = diags
+		changed[target] = true
+	}
 
-					err = s.client.PublishDiagnostics(context.Background(), &protocol.PublishDiagnosticsParams{
-						URI:         uri,
-						Diagnostics: diags,
-					})
-					if err != nil {
-						log.Errorf("publishDiagnostics: unable to publish diagnostics: %v\n", err)
-					}
+	var targets []protocol.DocumentURI
+	for target := range changed {
+		targets = append(targets, target)
+	}
+	sort.Slice(targets, func(i, j int) bool { return targets[i] < targets[j] })
+	return targets
+}
 
-					doc.Diagnostics = diags
+// importedDiagsFor returns the diagnostics that the evaluation of other documents reports in a file
+func (s *Server) importedDiagsFor(target protocol.DocumentURI) []protocol.Diagnostic {
+	s.importedDiagsMutex.Lock()
+	defer s.importedDiagsMutex.Unlock()
 
-					log.Debug("Done publishing diagnostics for ", uri)
+	var sources []protocol.DocumentURI
+	for source := range s.importedDiags[target] {
+		sources = append(sources, source)
+	}
+	sort.Slice(sources, func(i, j int) bool { return sources[i] < sources[j] })
 
-					s.diagRunning.Delete(uri)
-				}()
-				delete(s.diagQueue, uri)
-			}
-			s.diagMutex.Unlock()
+	var diags []protocol.Diagnostic
+	for _, source := range sources {
+		diags = append(diags, s.importedDiags[target][source]...)
+	}
+	return diags
+}
 
-			time.Sleep(1 * time.Second)
+// importedEvalDiags returns the evaluation errors of a document to show in the files of their stack trace
+func importedEvalDiags(uri protocol.DocumentURI, diags []protocol.Diagnostic) map[protocol.DocumentURI][]protocol.Diagnostic {
+	imported := map[protocol.DocumentURI][]protocol.Diagnostic{}
+	for _, diag := range diags {
+		if diag.Source != "jsonnet evaluation" {
+			continue
 		}
-	}()
+		for _, related := range diag.RelatedInformation {
+			imported[related.Location.URI] = append(imported[related.Location.URI], protocol.Diagnostic{
+				Range:    related.Location.Range,
+				Severity: diag.Severity,
+				Source:   diag.Source,
+				Message:  diag.Message,
+				RelatedInformation: []protocol.DiagnosticRelatedInformation{{
+					Location: protocol.Location{URI: uri, Range: diag.Range},
+					Message:  "evaluated from " + filepath.Base(uri.SpanURI().Filename()),
+				}},
+			})
+		}
+	}
+	return imported
+}
+
+// evaluation is a running evaluation of a document
+type evaluation struct {
+	cancel context.CancelFunc
+}
+
+// evaluate evaluates the text of a document, until the timeout or the evaluation of a newer version.
+// A single VM runs for each document, a new evaluation waits for the aborted one to return before starting
+func (s *Server) evaluate(uri protocol.DocumentURI, text string) (string, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	current := &evaluation{cancel: cancel}
+	if previous, loaded := s.evalCancels.Swap(uri, current); loaded {
+		previous.(*evaluation).cancel()
+	}
+	defer s.evalCancels.CompareAndDelete(uri, current)
+
+	filename := uri.SpanURI().Filename()
+	var (
+		vm  *jsonnet.VM
+		val string
+		err error
+	)
+	if abortErr := s.runVM(ctx, uri, func() {
+		vm = s.getDiagnosticsVM(filename)
+		val, err = vm.EvaluateAnonymousSnippet(filename, text)
+	}); abortErr != nil {
+		return "", abortErr
+	}
+	// The imports of an aborted evaluation may be outdated, they're only recorded for the evaluations that are used
+	s.recordImports(vm, filename, text)
+	return val, err
+}
+
+// cancelEvaluation cancels the running evaluation of a document, if any
+func (s *Server) cancelEvaluation(uri protocol.DocumentURI) {
+	if running, ok := s.evalCancels.LoadAndDelete(uri); ok {
+		running.(*evaluation).cancel()
+	}
 }
 
 func (s *Server) getEvalDiags(doc *cache.Document) (diags []protocol.Diagnostic) {
-	if doc.Err == nil && s.configuration.EnableEvalDiagnostics {
-		vm := s.getVM(doc.Item.URI.SpanURI().Filename())
-		doc.Val, doc.Err = vm.EvaluateAnonymousSnippet(doc.Item.URI.SpanURI().Filename(), doc.Item.Text)
+	if (doc.Err == nil || doc.EvalErr) && s.configuration.EnableEvalDiagnostics {
+		val, err := s.evaluate(doc.Item.URI, doc.Item.Text)
+		switch {
+		case errors.Is(err, errEvalCanceled):
+			// The diagnostics of the newer version replace these ones
+			return nil
+		case errors.Is(err, errEvalTimeout):
+			return []protocol.Diagnostic{{
+				Range:    position.NewProtocolRange(0, 0, 0, 0),
+				Severity: protocol.SeverityInformation,
+				Code:     evalAbortedCode,
+				Source:   "jsonnet evaluation",
+				Message:  fmt.Sprintf("Evaluation aborted: %v. The timeout can be changed with the eval_timeout setting", err),
+			}}
+		}
+		doc.Val, doc.Err, doc.EvalErr = val, err, err != nil
 	}
 
 	if doc.Err != nil {
@@ -163,25 +369,43 @@ func (s *Server) getEvalDiags(doc *cache.Document) (diags []protocol.Diagnostic)
 			return diags
 		}
 
-		var match []string
-		// TODO(#22): Runtime errors that come from imported files report an incorrect location
 		runtimeErr := strings.HasPrefix(lines[0], "RUNTIME ERROR:")
 		if runtimeErr {
-			match = errRegexp.FindStringSubmatch(lines[1])
-		} else {
-			match = errRegexp.FindStringSubmatch(lines[0])
-		}
-
-		message, rang := parseErrRegexpMatch(match)
-		if runtimeErr {
+			// The error is reported on the innermost frame of the document. The frames in other files are related to it
 			diag.Message = doc.Err.Error()
 			diag.Severity = protocol.SeverityWarning
-		} else {
-			diag.Message = message
-			diag.Severity = protocol.SeverityError
+			diag.Range = position.NewProtocolRange(0, 0, 0, 0)
+
+			filename := doc.Item.URI.SpanURI().Filename()
+			converter := s.converter(doc.Item.Text)
+			frames := parseStackFrames(lines[1:])
+			if len(frames) > 0 && frames[0].filename == filename {
+				// The error can only be fixed from the document if it comes from it
+				setDiagnosticCode(&diag, unknownFieldRegexp, unknownFieldCode, lines[0])
+			}
+			inDocument := false
+			for _, frame := range frames {
+				if frame.filename == filename {
+					if !inDocument {
+						diag.Range, inDocument = errorRange(converter, frame.rang), true
+					}
+					continue
+				}
+				frameURI := protocol.URIFromPath(frame.filename)
+				diag.RelatedInformation = append(diag.RelatedInformation, protocol.DiagnosticRelatedInformation{
+					Location: protocol.Location{URI: frameURI, Range: errorRange(s.fileConverter(frameURI), frame.rang)},
+					Message:  frame.name,
+				})
+			}
+			return append(diags, diag)
 		}
 
-		diag.Range = rang
+		message, rang := parseErrRegexpMatch(errRegexp.FindStringSubmatch(lines[0]))
+		diag.Message = message
+		diag.Severity = protocol.SeverityError
+		setDiagnosticCode(&diag, unknownVariableRegexp, unknownVariableCode, message)
+
+		diag.Range = errorRange(s.converter(doc.Item.Text), rang)
 		diags = append(diags, diag)
 	}
 
@@ -193,16 +417,83 @@ func (s *Server) getLintDiags(doc *cache.Document) (diags []protocol.Diagnostic)
 	if err != nil {
 		log.Errorf("getLintDiags: %s: %v\n", errorRetrievingDocument, err)
 	} else {
+		converter := s.converter(doc.Item.Text)
 		for _, match := range errRegexp.FindAllStringSubmatch(result, -1) {
 			diag := protocol.Diagnostic{Source: "lint", Severity: protocol.SeverityWarning}
-			diag.Message, diag.Range = parseErrRegexpMatch(match)
+			message, rang := parseErrRegexpMatch(match)
+			diag.Message, diag.Range = message, errorRange(converter, rang)
+			setDiagnosticCode(&diag, unusedVariableRegexp, unusedVariableCode, diag.Message)
 			diags = append(diags, diag)
 		}
 	}
 
+	return append(diags, s.getDeprecationDiags(doc)...)
+}
+
+// getDeprecationDiags reports the uses of deprecated functions of the standard library
+func (s *Server) getDeprecationDiags(doc *cache.Document) (diags []protocol.Diagnostic) {
+	if doc.AST == nil || len(doc.LinesChangedSinceAST) > 0 {
+		return nil
+	}
+	deprecated := deprecatedStdFunctions(s.stdlib)
+	if len(deprecated) == 0 {
+		return nil
+	}
+
+	converter := s.converter(doc.Item.Text)
+	for _, index := range findStdIndexes(doc.AST) {
+		name := index.Index.(*ast.LiteralString).Value
+		replacement, ok := deprecated[name]
+		if !ok {
+			continue
+		}
+		nameRange, ok := processing.IndexNameRange(index)
+		if !ok {
+			continue
+		}
+		diags = append(diags, protocol.Diagnostic{
+			Range:    converter.RangeASTToProtocol(nameRange),
+			Severity: protocol.SeverityWarning,
+			Code:     deprecatedStdCode,
+			Source:   "lint",
+			Message:  fmt.Sprintf("std.%s is deprecated, use std.%s instead", name, replacement),
+			Tags:     []protocol.DiagnosticTag{protocol.Deprecated},
+			Data:     diagnosticData{Name: name, Replacement: replacement},
+		})
+	}
 	return diags
 }
 
+// deprecatedStdFunctions returns the deprecated functions of the standard library and the functions to use instead
+func deprecatedStdFunctions(functions []stdlib.Function) map[string]string {
+	deprecated := map[string]string{}
+	for _, function := range functions {
+		if match := deprecatedStdRegexp.FindStringSubmatch(function.RenderedDescription); match != nil {
+			deprecated[function.Name] = match[1]
+		}
+	}
+	return deprecated
+}
+
+// findStdIndexes returns the `std.name` expressions of a document, in the order of the text
+func findStdIndexes(root ast.Node) []*ast.Index {
+	var indexes []*ast.Index
+	processing.Walk(root, func(node ast.Node) bool {
+		if index, ok := node.(*ast.Index); ok {
+			target, isVar := index.Target.(*ast.Var)
+			_, isString := index.Index.(*ast.LiteralString)
+			if isVar && target.Id == "std" && isString {
+				indexes = append(indexes, index)
+			}
+		}
+		return true
+	})
+	sort.SliceStable(indexes, func(i, j int) bool {
+		return isBefore(indexes[i].LocRange.Begin, indexes[j].LocRange.Begin)
+	})
+	return indexes
+}
+
 func (s *Server) lintWithRecover(doc *cache.Document) (result string, err error) {
 	defer func() {
 		if r := recover(); r != nil {
@@ -0,0 +1,187 @@
+package server
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/jdbaldry/go-language-server-protocol/lsp/protocol"
+)
+
+// defaultDiagnosticsDebounce is the time without changes to wait for before diagnosing a document, when none is configured
+const defaultDiagnosticsDebounce = 300 * time.Millisecond
+
+// diagnosticsScheduler runs the diagnostics of documents in the background. The changes of a document are debounced and
+// coalesced into a single run, a change cancels the run of the previous version, and a bounded number of documents are
+// diagnosed at the same time. A document is never diagnosed by two runs at once
+type diagnosticsScheduler struct {
+	run     func(ctx context.Context, uri protocol.DocumentURI)
+	workers int
+
+	mu sync.Mutex
+	// The documents are only diagnosed once the server is initialized
+	started bool
+	// Documents waiting for their changes to settle
+	timers map[protocol.DocumentURI]*time.Timer
+	// Documents ready to be diagnosed, waiting for a worker, in order
+	waiting   []protocol.DocumentURI
+	isWaiting map[protocol.DocumentURI]bool
+	// Documents being diagnosed, and those that changed since their run started
+	running map[protocol.DocumentURI]context.CancelFunc
+	rerun   map[protocol.DocumentURI]bool
+	// Closed when a run finishes or a document is forgotten, for those waiting for the pending diagnostics
+	changed chan struct{}
+}
+
+func newDiagnosticsScheduler(workers int, run func(ctx context.Context, uri protocol.DocumentURI)) *diagnosticsScheduler {
+	return &diagnosticsScheduler{
+		run:       run,
+		workers:   max(workers, 1),
+		timers:    make(map[protocol.DocumentURI]*time.Timer),
+		isWaiting: make(map[protocol.DocumentURI]bool),
+		running:   make(map[protocol.DocumentURI]context.CancelFunc),
+		rerun:     make(map[protocol.DocumentURI]bool),
+	}
+}
+
+// schedule diagnoses a document once it hasn't changed for the debounce duration. The running diagnostics of the document
+// are outdated, they're canceled
+func (d *diagnosticsScheduler) schedule(uri protocol.DocumentURI, debounce time.Duration) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if cancel, ok := d.running[uri]; ok {
+		cancel()
+	}
+	if timer, ok := d.timers[uri]; ok {
+		timer.Reset(debounce)
+		return
+	}
+	d.timers[uri] = time.AfterFunc(debounce, func() {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		delete(d.timers, uri)
+		d.ready(uri)
+	})
+}
+
+// scheduleOnce diagnoses a document that didn't change, unless it's already scheduled or being diagnosed
+func (d *diagnosticsScheduler) scheduleOnce(uri protocol.DocumentURI) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if _, ok := d.timers[uri]; ok {
+		return
+	}
+	if _, ok := d.running[uri]; ok {
+		return
+	}
+	d.ready(uri)
+}
+
+// startWorkers starts diagnosing the documents, once the client is initialized
+func (d *diagnosticsScheduler) startWorkers() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.started = true
+	d.startWaiting()
+}
+
+// cancel forgets a document, when it's closed
+func (d *diagnosticsScheduler) cancel(uri protocol.DocumentURI) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if timer, ok := d.timers[uri]; ok {
+		timer.Stop()
+		delete(d.timers, uri)
+	}
+	if d.isWaiting[uri] {
+		delete(d.isWaiting, uri)
+		for i, waiting := range d.waiting {
+			if waiting == uri {
+				d.waiting = append(d.waiting[:i], d.waiting[i+1:]...)
+				break
+			}
+		}
+	}
+	if cancel, ok := d.running[uri]; ok {
+		cancel()
+	}
+	delete(d.rerun, uri)
+	d.notifyChanged()
+}
+
+// pending returns a channel closed once the scheduler's state changes, if a document is waiting to be diagnosed or
+// being diagnosed. It returns nil otherwise
+func (d *diagnosticsScheduler) pending(uri protocol.DocumentURI) <-chan struct{} {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if _, ok := d.timers[uri]; !ok && !d.isWaiting[uri] && d.running[uri] == nil {
+		return nil
+	}
+	if d.changed == nil {
+		d.changed = make(chan struct{})
+	}
+	return d.changed
+}
+
+// notifyChanged wakes up those waiting for pending diagnostics. It must be called with the mutex locked
+func (d *diagnosticsScheduler) notifyChanged() {
+	if d.changed != nil {
+		close(d.changed)
+		d.changed = nil
+	}
+}
+
+// ready starts the diagnostics of a document, or queues them until a worker is free. It must be called with the mutex locked
+func (d *diagnosticsScheduler) ready(uri protocol.DocumentURI) {
+	switch {
+	case d.running[uri] != nil:
+		d.rerun[uri] = true
+	case d.isWaiting[uri]:
+	case d.started && len(d.running) < d.workers:
+		d.start(uri)
+	default:
+		d.waiting = append(d.waiting, uri)
+		d.isWaiting[uri] = true
+	}
+}
+
+// start runs the diagnostics of a document. It must be called with the mutex locked
+func (d *diagnosticsScheduler) start(uri protocol.DocumentURI) {
+	ctx, cancel := context.WithCancel(context.Background())
+	d.running[uri] = cancel
+	go func() {
+		defer d.finish(uri, cancel)
+		d.run(ctx, uri)
+	}()
+}
+
+// finish frees the worker of a document, for the documents that changed during their run first, then for the waiting ones
+func (d *diagnosticsScheduler) finish(uri protocol.DocumentURI, cancel context.CancelFunc) {
+	cancel()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	delete(d.running, uri)
+	if d.rerun[uri] {
+		delete(d.rerun, uri)
+		d.ready(uri)
+	}
+	d.startWaiting()
+	d.notifyChanged()
+}
+
+// startWaiting starts the waiting documents while workers are free. It must be called with the mutex locked
+func (d *diagnosticsScheduler) startWaiting() {
+	for len(d.running) < d.workers && len(d.waiting) > 0 {
+		next := d.waiting[0]
+		d.waiting = d.waiting[1:]
+		delete(d.isWaiting, next)
+		d.start(next)
+	}
+}
@@ -0,0 +1,362 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-jsonnet/formatter"
+	"github.com/grafana/jsonnet-language-server/pkg/stdlib"
+	"github.com/jdbaldry/go-language-server-protocol/lsp/protocol"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// runRecorder counts the diagnostics runs of each document
+type runRecorder struct {
+	mu   sync.Mutex
+	runs map[protocol.DocumentURI]int
+}
+
+func (r *runRecorder) record(uri protocol.DocumentURI) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.runs[uri]++
+}
+
+func (r *runRecorder) count(uri protocol.DocumentURI) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.runs[uri]
+}
+
+func TestDiagnosticsSchedulerCoalescesChanges(t *testing.T) {
+	recorder := &runRecorder{runs: map[protocol.DocumentURI]int{}}
+	scheduler := newDiagnosticsScheduler(4, func(_ context.Context, uri protocol.DocumentURI) {
+		recorder.record(uri)
+	})
+
+	// The documents changed before the initialization are diagnosed once it's done
+	scheduler.schedule("file:///a.jsonnet", 0)
+	time.Sleep(20 * time.Millisecond)
+	assert.Equal(t, 0, recorder.count("file:///a.jsonnet"))
+	scheduler.startWorkers()
+	require.Eventually(t, func() bool { return recorder.count("file:///a.jsonnet") == 1 }, time.Second, 5*time.Millisecond)
+
+	// A burst of changes is diagnosed once
+	for range 50 {
+		scheduler.schedule("file:///b.jsonnet", 20*time.Millisecond)
+	}
+	require.Eventually(t, func() bool { return recorder.count("file:///b.jsonnet") == 1 }, time.Second, 5*time.Millisecond)
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, 1, recorder.count("file:///b.jsonnet"))
+
+	// Closed documents aren't diagnosed
+	scheduler.schedule("file:///c.jsonnet", 20*time.Millisecond)
+	scheduler.cancel("file:///c.jsonnet")
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, 0, recorder.count("file:///c.jsonnet"))
+}
+
+func TestDiagnosticsSchedulerBoundsWorkers(t *testing.T) {
+	const workers, documents = 2, 6
+
+	var mu sync.Mutex
+	running, maxRunning := 0, 0
+	release := make(chan struct{})
+	recorder := &runRecorder{runs: map[protocol.DocumentURI]int{}}
+	scheduler := newDiagnosticsScheduler(workers, func(_ context.Context, uri protocol.DocumentURI) {
+		mu.Lock()
+		running++
+		maxRunning = max(maxRunning, running)
+		mu.Unlock()
+
+		<-release
+
+		mu.Lock()
+		running--
+		mu.Unlock()
+		recorder.record(uri)
+	})
+	scheduler.startWorkers()
+
+	for i := range documents {
+		scheduler.schedule(protocol.DocumentURI(fmt.Sprintf("file:///%d.jsonnet", i)), 0)
+	}
+	require.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return running == workers
+	}, time.Second, 5*time.Millisecond)
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+
+	require.Eventually(t, func() bool {
+		for i := range documents {
+			if recorder.count(protocol.DocumentURI(fmt.Sprintf("file:///%d.jsonnet", i))) != 1 {
+				return false
+			}
+		}
+		return true
+	}, time.Second, 5*time.Millisecond)
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, workers, maxRunning)
+}
+
+func TestDiagnosticsSchedulerCancelsOutdatedRuns(t *testing.T) {
+	const uri = protocol.DocumentURI("file:///a.jsonnet")
+
+	var mu sync.Mutex
+	running, runs, canceled := false, 0, 0
+	started := make(chan struct{}, 1)
+	scheduler := newDiagnosticsScheduler(4, func(ctx context.Context, _ protocol.DocumentURI) {
+		mu.Lock()
+		// A document is never diagnosed by two runs at once
+		assert.False(t, running)
+		running = true
+		runs++
+		first := runs == 1
+		mu.Unlock()
+
+		if first {
+			started <- struct{}{}
+			<-ctx.Done()
+		}
+
+		mu.Lock()
+		running = false
+		if ctx.Err() != nil {
+			canceled++
+		}
+		mu.Unlock()
+	})
+	scheduler.startWorkers()
+
+	scheduler.schedule(uri, 0)
+	<-started
+	// The change cancels the running diagnostics, and the new version is diagnosed after them
+	scheduler.schedule(uri, 0)
+
+	require.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return runs == 2 && !running
+	}, time.Second, 5*time.Millisecond)
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, 1, canceled)
+}
+
+func TestDiagnosticsSchedulerPending(t *testing.T) {
+	const uri = protocol.DocumentURI("file:///a.jsonnet")
+
+	release := make(chan struct{})
+	scheduler := newDiagnosticsScheduler(1, func(_ context.Context, _ protocol.DocumentURI) {
+		<-release
+	})
+	scheduler.startWorkers()
+	assert.Nil(t, scheduler.pending(uri))
+
+	// The document is pending while it's debounced and diagnosed, the channel is closed once the run finishes
+	scheduler.schedule(uri, 0)
+	pending := scheduler.pending(uri)
+	require.NotNil(t, pending)
+	select {
+	case <-pending:
+		t.Fatal("the diagnostics aren't done")
+	case <-time.After(20 * time.Millisecond):
+	}
+	close(release)
+	select {
+	case <-pending:
+	case <-time.After(time.Second):
+		t.Fatal("the diagnostics are done")
+	}
+	assert.Nil(t, scheduler.pending(uri))
+}
+
+func TestDidChangeBurstPublishesLastVersion(t *testing.T) {
+	client := &publishingClient{diagnostics: map[protocol.DocumentURI][]protocol.Diagnostic{}}
+	server := NewServer("jsonnet-language-server", "dev", client, Configuration{
+		EnableEvalDiagnostics: true,
+		EnableLintDiagnostics: true,
+		DiagnosticsDebounce:   time.Millisecond,
+		FormattingOptions:     formatter.DefaultOptions(),
+	})
+	server.stdlib = []stdlib.Function{}
+	_, err := server.Initialize(context.Background(), &protocol.ParamInitialize{})
+	require.NoError(t, err)
+	uri := protocol.URIFromPath(filepath.Join(t.TempDir(), "main.jsonnet"))
+	require.NoError(t, server.DidOpen(context.Background(), &protocol.DidOpenTextDocumentParams{
+		TextDocument: protocol.TextDocumentItem{URI: uri, Text: "error 'version 1'", Version: 1},
+	}))
+
+	// The documents are read by other requests while they're changed and diagnosed
+	done := make(chan struct{})
+	var readers sync.WaitGroup
+	readers.Add(1)
+	go func() {
+		defer readers.Done()
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			doc, err := server.cache.Get(uri)
+			if !assert.NoError(t, err) {
+				return
+			}
+			_ = len(doc.Item.Text) + len(doc.Diagnostics)
+		}
+	}()
+
+	const versions = 100
+	for version := int32(2); version <= versions; version++ {
+		require.NoError(t, server.DidChange(context.Background(), &protocol.DidChangeTextDocumentParams{
+			TextDocument: protocol.VersionedTextDocumentIdentifier{
+				TextDocumentIdentifier: protocol.TextDocumentIdentifier{URI: uri},
+				Version:                version,
+			},
+			ContentChanges: []protocol.TextDocumentContentChangeEvent{{Text: fmt.Sprintf("error 'version %d'", version)}},
+		}))
+		if version%10 == 0 {
+			// Some runs are left to finish, others are canceled by the next change
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+
+	expected := fmt.Sprintf("version %d", versions)
+	require.Eventually(t, func() bool {
+		diags, _ := client.published(uri)
+		return len(diags) == 1 && strings.Contains(diags[0].Message, expected)
+	}, 10*time.Second, 10*time.Millisecond)
+	close(done)
+	readers.Wait()
+
+	// The diagnostics of the last version are kept
+	time.Sleep(50 * time.Millisecond)
+	diags, _ := client.published(uri)
+	require.Len(t, diags, 1)
+	assert.Contains(t, diags[0].Message, expected)
+	doc, err := server.cache.Get(uri)
+	require.NoError(t, err)
+	assert.Equal(t, diags, doc.Diagnostics)
+}
+
+// abortingServer returns a server whose evaluations of slow documents are aborted, while their VMs keep running
+func abortingServer(t *testing.T, workers, maxAbandoned int) (*Server, *publishingClient) {
+	t.Helper()
+
+	client := &publishingClient{diagnostics: map[protocol.DocumentURI][]protocol.Diagnostic{}}
+	server := NewServer("jsonnet-language-server", "dev", client, Configuration{
+		EnableEvalDiagnostics: true,
+		DiagnosticsDebounce:   time.Millisecond,
+		EvalTimeout:           10 * time.Millisecond,
+		FormattingOptions:     formatter.DefaultOptions(),
+	})
+	server.stdlib = []stdlib.Function{}
+	server.diagnostics = newDiagnosticsScheduler(workers, server.diagnose)
+	server.maxAbandonedEvaluations = maxAbandoned
+	_, err := server.Initialize(context.Background(), &protocol.ParamInitialize{})
+	require.NoError(t, err)
+
+	// The abandoned VMs are left to return, so that they don't slow down the other tests
+	t.Cleanup(func() {
+		require.Eventually(t, func() bool { return server.liveVMs() == 0 }, 30*time.Second, 10*time.Millisecond)
+	})
+	return server, client
+}
+
+// liveVMs returns the number of VMs evaluating documents, including the abandoned ones
+func (s *Server) liveVMs() int {
+	s.evalRunningMutex.Lock()
+	defer s.evalRunningMutex.Unlock()
+	return len(s.evalRunning)
+}
+
+func TestDiagnosticsFreeWorkersOfAbortedEvaluations(t *testing.T) {
+	const slowContent = "std.foldl(function(acc, i) acc + i, std.range(1, 1000000), 0)"
+	server, client := abortingServer(t, 1, 2)
+	dir := t.TempDir()
+
+	slowURI := protocol.URIFromPath(filepath.Join(dir, "slow.jsonnet"))
+	require.NoError(t, server.DidOpen(context.Background(), &protocol.DidOpenTextDocumentParams{
+		TextDocument: protocol.TextDocumentItem{URI: slowURI, Text: slowContent, Version: 1},
+	}))
+	require.Eventually(t, func() bool {
+		diags, _ := client.published(slowURI)
+		return len(diags) == 1 && diags[0].Code == evalAbortedCode
+	}, 10*time.Second, 5*time.Millisecond)
+
+	// The single worker is free while the VM of the slow document keeps running
+	fastURI := protocol.URIFromPath(filepath.Join(dir, "fast.jsonnet"))
+	require.NoError(t, server.DidOpen(context.Background(), &protocol.DidOpenTextDocumentParams{
+		TextDocument: protocol.TextDocumentItem{URI: fastURI, Text: "error 'fast'", Version: 1},
+	}))
+	require.Eventually(t, func() bool {
+		diags, _ := client.published(fastURI)
+		return len(diags) == 1 && strings.Contains(diags[0].Message, "fast")
+	}, 10*time.Second, 5*time.Millisecond)
+	assert.Equal(t, 1, server.liveVMs())
+}
+
+func TestDiagnosticsBoundsAbortedEvaluations(t *testing.T) {
+	const workers, maxAbandoned, documents, versions = 2, 2, 6, 10
+	const slowContent = "std.foldl(function(acc, i) acc + i, std.range(1, 300000), 0)"
+	server, client := abortingServer(t, workers, maxAbandoned)
+
+	done := make(chan struct{})
+	maxLiveVMs := make(chan int)
+	go func() {
+		maxLive := 0
+		for {
+			select {
+			case <-done:
+				maxLiveVMs <- maxLive
+				return
+			case <-time.After(time.Millisecond):
+				maxLive = max(maxLive, server.liveVMs())
+			}
+		}
+	}()
+
+	dir := t.TempDir()
+	var uris []protocol.DocumentURI
+	for i := range documents {
+		uri := protocol.URIFromPath(filepath.Join(dir, fmt.Sprintf("%d.jsonnet", i)))
+		uris = append(uris, uri)
+		require.NoError(t, server.DidOpen(context.Background(), &protocol.DidOpenTextDocumentParams{
+			TextDocument: protocol.TextDocumentItem{URI: uri, Text: slowContent, Version: 1},
+		}))
+	}
+	for version := int32(2); version <= versions; version++ {
+		for _, uri := range uris {
+			require.NoError(t, server.DidChange(context.Background(), &protocol.DidChangeTextDocumentParams{
+				TextDocument: protocol.VersionedTextDocumentIdentifier{
+					TextDocumentIdentifier: protocol.TextDocumentIdentifier{URI: uri},
+					Version:                version,
+				},
+				ContentChanges: []protocol.TextDocumentContentChangeEvent{{Text: fmt.Sprintf("%s + %d", slowContent, version)}},
+			}))
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	// Every document is diagnosed in the end. The VMs running at once are those of the workers, and the abandoned ones
+	require.Eventually(t, func() bool {
+		for _, uri := range uris {
+			if diags, _ := client.published(uri); len(diags) != 1 || diags[0].Code != evalAbortedCode {
+				return false
+			}
+		}
+		return server.liveVMs() == 0
+	}, 60*time.Second, 10*time.Millisecond)
+	close(done)
+	assert.LessOrEqual(t, <-maxLiveVMs, workers+maxAbandoned)
+}
@@ -1,10 +1,19 @@
 package server
 
 import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
 	"testing"
+	"time"
 
+	position "github.com/grafana/jsonnet-language-server/pkg/position_conversion"
 	"github.com/jdbaldry/go-language-server-protocol/lsp/protocol"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestGetLintDiags(t *testing.T) {
@@ -48,8 +57,10 @@ local unused = 'test';
 						End:   protocol.Position{Line: 1, Character: 21},
 					},
 					Severity: protocol.SeverityWarning,
+					Code:     unusedVariableCode,
 					Source:   "lint",
 					Message:  "Unused variable: unused",
+					Data:     diagnosticData{Name: "unused"},
 				},
 			},
 		},
@@ -141,6 +152,23 @@ func TestGetEvalDiags(t *testing.T) {
 				},
 			},
 		},
+		{
+			name:        "unknown variable",
+			fileContent: `{ a: foo }`,
+			expected: []protocol.Diagnostic{
+				{
+					Range: protocol.Range{
+						Start: protocol.Position{Line: 0, Character: 5},
+						End:   protocol.Position{Line: 0, Character: 8},
+					},
+					Severity: protocol.SeverityError,
+					Code:     unknownVariableCode,
+					Source:   "jsonnet evaluation",
+					Message:  `Unknown variable: foo`,
+					Data:     diagnosticData{Name: "foo"},
+				},
+			},
+		},
 	}
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
@@ -155,3 +183,154 @@ func TestGetEvalDiags(t *testing.T) {
 		})
 	}
 }
+
+func TestGetEvalDiagsImportedFiles(t *testing.T) {
+	dir := t.TempDir()
+	libFilename, mainFilename := filepath.Join(dir, "lib.libsonnet"), filepath.Join(dir, "main.jsonnet")
+	require.NoError(t, os.WriteFile(libFilename, []byte("{\n  f(x):: x.missing,\n}\n"), 0o600))
+	require.NoError(t, os.WriteFile(mainFilename, []byte("local lib = import 'lib.libsonnet';\n{\n  a: lib.f({}),\n}\n"), 0o600))
+
+	server := testServer(t, nil)
+	server.configuration.EnableEvalDiagnostics = true
+	mainURI, libURI := serverOpenTestFile(t, server, mainFilename), protocol.URIFromPath(libFilename)
+	doc, err := server.cache.Get(mainURI)
+	require.NoError(t, err)
+
+	// The error is reported on the call site, it can't be fixed from the document
+	diags := server.getEvalDiags(doc)
+	require.Len(t, diags, 1)
+	callRange := position.NewProtocolRange(2, 5, 2, 14)
+	frameRange := position.NewProtocolRange(1, 9, 1, 18)
+	assert.Equal(t, callRange, diags[0].Range)
+	assert.Equal(t, protocol.SeverityWarning, diags[0].Severity)
+	assert.Empty(t, diags[0].Code)
+	assert.Equal(t, []protocol.DiagnosticRelatedInformation{
+		{Location: protocol.Location{URI: libURI, Range: frameRange}, Message: "function <anonymous>"},
+	}, diags[0].RelatedInformation)
+
+	// It's also reported in the imported file
+	imported := importedEvalDiags(mainURI, diags)
+	assert.Equal(t, map[protocol.DocumentURI][]protocol.Diagnostic{
+		libURI: {{
+			Range:    frameRange,
+			Severity: protocol.SeverityWarning,
+			Source:   "jsonnet evaluation",
+			Message:  diags[0].Message,
+			RelatedInformation: []protocol.DiagnosticRelatedInformation{
+				{Location: protocol.Location{URI: mainURI, Range: callRange}, Message: "evaluated from main.jsonnet"},
+			},
+		}},
+	}, imported)
+
+	assert.Equal(t, []protocol.DocumentURI{libURI}, server.setImportedDiags(mainURI, imported))
+	assert.Equal(t, imported[libURI], server.importedDiagsFor(libURI))
+
+	// Once fixed, the diagnostics of the imported file are cleared
+	assert.Equal(t, []protocol.DocumentURI{libURI}, server.setImportedDiags(mainURI, importedEvalDiags(mainURI, nil)))
+	assert.Empty(t, server.importedDiagsFor(libURI))
+	assert.Empty(t, server.setImportedDiags(mainURI, nil))
+}
+
+func TestParseStackFrames(t *testing.T) {
+	err := "RUNTIME ERROR: boom\n" +
+		"\t/tmp/lib.libsonnet:2:10-19\tfunction <anonymous>\n" +
+		"\t/tmp/main.jsonnet:(3:6)-(4:2)\tobject <anonymous>\n" +
+		"\tField \"a\"\t\n" +
+		"\tDuring manifestation\t\n"
+
+	assert.Equal(t, []evalFrame{
+		{filename: "/tmp/lib.libsonnet", rang: position.NewProtocolRange(1, 9, 1, 18), name: "function <anonymous>"},
+		{filename: "/tmp/main.jsonnet", rang: position.NewProtocolRange(2, 5, 3, 1), name: "object <anonymous>"},
+	}, parseStackFrames(strings.Split(err, "\n")[1:]))
+}
+
+func TestGetEvalDiagsLimits(t *testing.T) {
+	// Takes about a second to evaluate
+	const slowContent = "std.foldl(function(acc, i) acc + i, std.range(1, 300000), 0)"
+
+	t.Run("timeout", func(t *testing.T) {
+		server, uri := testServerWithFile(t, nil, slowContent)
+		server.configuration.EnableEvalDiagnostics = true
+		server.configuration.EvalTimeout = 10 * time.Millisecond
+		doc, err := server.cache.Get(uri)
+		require.NoError(t, err)
+
+		assert.Equal(t, []protocol.Diagnostic{{
+			Range:    position.NewProtocolRange(0, 0, 0, 0),
+			Severity: protocol.SeverityInformation,
+			Code:     evalAbortedCode,
+			Source:   "jsonnet evaluation",
+			Message:  "Evaluation aborted: evaluation timed out after 10ms. The timeout can be changed with the eval_timeout setting",
+		}}, server.getEvalDiags(doc))
+		// The document is evaluated again by the next diagnostics
+		assert.NoError(t, doc.Err)
+	})
+
+	t.Run("newer version", func(t *testing.T) {
+		server, uri := testServerWithFile(t, nil, slowContent)
+
+		errs := make(chan error, 1)
+		go func() {
+			_, err := server.evaluate(uri, slowContent)
+			errs <- err
+		}()
+		require.Eventually(t, func() bool {
+			_, running := server.evalCancels.Load(uri)
+			return running
+		}, time.Second, time.Millisecond)
+
+		require.NoError(t, server.DidChange(context.Background(), &protocol.DidChangeTextDocumentParams{
+			TextDocument:   protocol.VersionedTextDocumentIdentifier{Version: 2, TextDocumentIdentifier: protocol.TextDocumentIdentifier{URI: uri}},
+			ContentChanges: []protocol.TextDocumentContentChangeEvent{{Text: "{}"}},
+		}))
+		assert.ErrorIs(t, <-errs, errEvalCanceled)
+		_, running := server.evalCancels.Load(uri)
+		assert.False(t, running)
+	})
+
+	t.Run("aborted evaluations", func(t *testing.T) {
+		dir := t.TempDir()
+		libFilename := filepath.Join(dir, "lib.libsonnet")
+		require.NoError(t, os.WriteFile(libFilename, []byte("{}"), 0o600))
+		server := testServer(t, nil)
+		server.configuration.EvalTimeout = 10 * time.Millisecond
+		uri := protocol.URIFromPath(filepath.Join(dir, "main.jsonnet"))
+
+		// Each version aborts the evaluation of the previous one, which keeps running. The next ones wait for it
+		goroutines := runtime.NumGoroutine()
+		for i := range 20 {
+			text := fmt.Sprintf("(import 'lib.libsonnet') + { n: %s + %d }", slowContent, i)
+			_, err := server.evaluate(uri, text)
+			assert.ErrorIs(t, err, errEvalTimeout)
+		}
+		assert.LessOrEqual(t, runtime.NumGoroutine()-goroutines, 5)
+		server.evalRunningMutex.Lock()
+		assert.Len(t, server.evalRunning, 1)
+		server.evalRunningMutex.Unlock()
+
+		// The imports of the aborted evaluations aren't recorded
+		require.Eventually(t, func() bool {
+			server.evalRunningMutex.Lock()
+			defer server.evalRunningMutex.Unlock()
+			return len(server.evalRunning) == 0
+		}, 30*time.Second, 10*time.Millisecond)
+		assert.Empty(t, server.cache.TransitiveImporters(libFilename))
+
+		server.configuration.EvalTimeout = 0
+		_, err := server.evaluate(uri, "(import 'lib.libsonnet') + { n: 1 }")
+		require.NoError(t, err)
+		assert.Equal(t, []string{uri.SpanURI().Filename()}, server.cache.TransitiveImporters(libFilename))
+	})
+
+	t.Run("max stack", func(t *testing.T) {
+		server, uri := testServerWithFile(t, nil, "local f(n) = if n == 0 then 0 else 1 + f(n - 1);\nf(100)\n")
+		server.configuration.EnableEvalDiagnostics = true
+		doc, err := server.cache.Get(uri)
+		require.NoError(t, err)
+
+		server.configuration.MaxStack = 20
+		diags := server.getEvalDiags(doc)
+		require.Len(t, diags, 1)
+		assert.Contains(t, diags[0].Message, "max stack frames exceeded")
+	})
+}
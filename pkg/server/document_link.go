@@ -0,0 +1,121 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sort"
+
+	"github.com/google/go-jsonnet/ast"
+	"github.com/grafana/jsonnet-language-server/pkg/ast/processing"
+	"github.com/grafana/jsonnet-language-server/pkg/utils"
+	"github.com/jdbaldry/go-language-server-protocol/lsp/protocol"
+	log "github.com/sirupsen/logrus"
+)
+
+// maxResolvedDocumentLinks is the number of imports resolved when listing the links of a document.
+// Resolving an import reads the imported file, so the links of larger documents are resolved by ResolveDocumentLink
+const maxResolvedDocumentLinks = 50
+
+// documentLinkData is kept in links that are not resolved yet
+type documentLinkData struct {
+	URI  protocol.DocumentURI `json:"uri"`
+	Path string               `json:"path"`
+}
+
+func (s *Server) DocumentLink(_ context.Context, params *protocol.DocumentLinkParams) ([]protocol.DocumentLink, error) {
+	doc, err := s.cache.Get(params.TextDocument.URI)
+	if err != nil {
+		return nil, utils.LogErrorf("DocumentLink: %s: %w", errorRetrievingDocument, err)
+	}
+
+	// Links are positioned relatively to the text, they can't be computed from an outdated AST
+	if doc.AST == nil || len(doc.LinesChangedSinceAST) > 0 {
+		log.Debugf("DocumentLink: document was changed since last successful parse")
+		return nil, nil
+	}
+
+	imports := findImportPaths(doc.AST)
+	lazy := len(imports) > maxResolvedDocumentLinks
+
+	filename := doc.Item.URI.SpanURI().Filename()
+	converter := s.converter(doc.Item.Text)
+	links := []protocol.DocumentLink{}
+	for _, importPath := range imports {
+		link := protocol.DocumentLink{
+			Range: converter.RangeASTToProtocol(importPath.LocRange),
+		}
+		if lazy {
+			link.Data = documentLinkData{URI: doc.Item.URI, Path: importPath.Value}
+			links = append(links, link)
+			continue
+		}
+
+		target, err := s.resolveImportPath(filename, importPath.Value)
+		if err != nil {
+			log.Debugf("DocumentLink: %v", err)
+			continue
+		}
+		link.Target = string(target)
+		links = append(links, link)
+	}
+	return links, nil
+}
+
+func (s *Server) ResolveDocumentLink(_ context.Context, params *protocol.DocumentLink) (*protocol.DocumentLink, error) {
+	if params.Target != "" {
+		return params, nil
+	}
+
+	var data documentLinkData
+	if err := unmarshalParams(params.Data, &data); err != nil {
+		return nil, utils.LogErrorf("ResolveDocumentLink: %w", err)
+	}
+	target, err := s.resolveImportPath(data.URI.SpanURI().Filename(), data.Path)
+	if err != nil {
+		return nil, utils.LogErrorf("ResolveDocumentLink: %w", err)
+	}
+
+	return &protocol.DocumentLink{
+		Range:  params.Range,
+		Target: string(target),
+	}, nil
+}
+
+// resolveImportPath finds the file imported by a path, the same way it's found when the document is evaluated
+func (s *Server) resolveImportPath(filename, importPath string) (protocol.DocumentURI, error) {
+	vm := s.getVM(filename)
+	foundAt, err := vm.ResolveImport(filename, importPath)
+	if err != nil {
+		return "", fmt.Errorf("could not resolve import %s: %w", importPath, err)
+	}
+	foundAt, err = filepath.Abs(foundAt)
+	if err != nil {
+		return "", err
+	}
+	return protocol.URIFromPath(foundAt), nil
+}
+
+// findImportPaths returns the paths of the import, importstr and importbin expressions of a document, in the order of the text
+func findImportPaths(root ast.Node) []*ast.LiteralString {
+	var paths []*ast.LiteralString
+	processing.Walk(root, func(node ast.Node) bool {
+		var path *ast.LiteralString
+		switch node := node.(type) {
+		case *ast.Import:
+			path = node.File
+		case *ast.ImportStr:
+			path = node.File
+		case *ast.ImportBin:
+			path = node.File
+		}
+		if path != nil && path.LocRange.Begin.IsSet() {
+			paths = append(paths, path)
+		}
+		return true
+	})
+	sort.SliceStable(paths, func(i, j int) bool {
+		return isBefore(paths[i].LocRange.Begin, paths[j].LocRange.Begin)
+	})
+	return paths
+}
@@ -0,0 +1,93 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/jdbaldry/go-language-server-protocol/lsp/protocol"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDocumentLink(t *testing.T) {
+	server := NewServer("any", "test version", nil, Configuration{
+		JPaths: []string{"testdata/document-links/lib"},
+	})
+	uri := serverOpenTestFile(t, server, "testdata/document-links/main.jsonnet")
+
+	links, err := server.DocumentLink(context.Background(), &protocol.DocumentLinkParams{
+		TextDocument: protocol.TextDocumentIdentifier{URI: uri},
+	})
+	require.NoError(t, err)
+
+	target := string(absURI(t, "testdata/document-links/lib/links.libsonnet"))
+	// The missing import is omitted
+	assert.Equal(t, []protocol.DocumentLink{
+		{
+			Range: protocol.Range{
+				Start: protocol.Position{Line: 0, Character: 19},
+				End:   protocol.Position{Line: 0, Character: 36},
+			},
+			Target: target,
+		},
+		{
+			Range: protocol.Range{
+				Start: protocol.Position{Line: 1, Character: 23},
+				End:   protocol.Position{Line: 1, Character: 44},
+			},
+			Target: target,
+		},
+		{
+			Range: protocol.Range{
+				Start: protocol.Position{Line: 2, Character: 23},
+				End:   protocol.Position{Line: 2, Character: 44},
+			},
+			Target: target,
+		},
+	}, links)
+}
+
+func TestDocumentLinkLazyResolution(t *testing.T) {
+	server := NewServer("any", "test version", nil, Configuration{
+		JPaths: []string{"testdata/document-links/lib"},
+	})
+
+	// Large documents have their links resolved by ResolveDocumentLink
+	var text strings.Builder
+	for i := 0; i <= maxResolvedDocumentLinks; i++ {
+		fmt.Fprintf(&text, "local lib%d = import 'links.libsonnet';\n", i)
+	}
+	text.WriteString("local missing = import 'missing.libsonnet';\n{}\n")
+
+	uri := absURI(t, "testdata/document-links/large.jsonnet")
+	require.NoError(t, server.DidOpen(context.Background(), &protocol.DidOpenTextDocumentParams{
+		TextDocument: protocol.TextDocumentItem{URI: uri, Text: text.String(), Version: 1},
+	}))
+
+	links, err := server.DocumentLink(context.Background(), &protocol.DocumentLinkParams{
+		TextDocument: protocol.TextDocumentIdentifier{URI: uri},
+	})
+	require.NoError(t, err)
+	require.Len(t, links, maxResolvedDocumentLinks+2)
+	for _, link := range links {
+		assert.Empty(t, link.Target)
+		assert.NotNil(t, link.Data)
+	}
+
+	// The data is sent back by the client as JSON
+	var data interface{}
+	require.NoError(t, unmarshalParams(links[0].Data, &data))
+	resolved, err := server.ResolveDocumentLink(context.Background(), &protocol.DocumentLink{Range: links[0].Range, Data: data})
+	require.NoError(t, err)
+	assert.Equal(t, &protocol.DocumentLink{
+		Range:  links[0].Range,
+		Target: string(absURI(t, "testdata/document-links/lib/links.libsonnet")),
+	}, resolved)
+
+	missing := links[len(links)-1]
+	require.NoError(t, unmarshalParams(missing.Data, &data))
+	_, err = server.ResolveDocumentLink(context.Background(), &protocol.DocumentLink{Range: missing.Range, Data: data})
+	assert.ErrorContains(t, err, "could not resolve import missing.libsonnet")
+}
@@ -0,0 +1,144 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/google/go-jsonnet/ast"
+	"github.com/google/go-jsonnet/formatter"
+)
+
+// evalItemField is the hidden field added to the enclosing objects of an evaluated expression, to evaluate it with their `self` and `super`
+const evalItemField = "__evalItem__"
+
+// evalItemSnippet returns a snippet evaluating the expression enclosing the range in the context of the document:
+// its enclosing locals, objects and function parameters. The parameters, and the variables of comprehensions,
+// take their value from the arguments (Jsonnet code by name), or from their default value
+func evalItemSnippet(filename, text string, begin, end ast.Location, arguments map[string]string) (string, error) {
+	root, _, err := formatter.SnippetToRawAST(filename, text)
+	if err != nil {
+		return "", err
+	}
+	path := findPath(root, func(node ast.Node) bool {
+		loc := node.Loc()
+		return !isBefore(begin, loc.Begin) && !isBefore(loc.End, end)
+	})
+	if len(path) == 0 {
+		return "", errNoExpression
+	}
+
+	nodeText := func(node ast.Node) string {
+		loc := node.Loc()
+		return text[textOffset(text, loc.Begin):textOffset(text, loc.End)]
+	}
+	var build func(i int) string
+	build = func(i int) string {
+		if i == len(path)-1 {
+			return nodeText(path[i])
+		}
+		parent, child := path[i], path[i+1]
+		inner := build(i + 1)
+
+		switch parent := parent.(type) {
+		case *ast.Local:
+			for _, bind := range parent.Binds {
+				if bind.Body == child && bind.Fun != nil {
+					inner = parameterLocals(text, bind.Fun.Parameters, arguments) + inner
+				}
+			}
+			// The binds are kept as written, up to the body of the local
+			return text[textOffset(text, parent.Loc().Begin):textOffset(text, parent.Body.Loc().Begin)] + inner
+		case *ast.Function:
+			return parameterLocals(text, parent.Parameters, arguments) + inner
+		case *ast.ArrayComp:
+			return comprehensionLocals(&parent.Spec, child, arguments) + inner
+		case *ast.ObjectComp:
+			return comprehensionLocals(&parent.Spec, child, arguments) + inner
+		case *ast.Object:
+			for _, field := range parent.Fields {
+				if field.Expr1 == child {
+					// Computed field names are not in the scope of the object
+					return inner
+				}
+			}
+			return objectFieldSnippet(text, path[:i+1], fmt.Sprintf("%s:: (%s),", evalItemField, inner))
+		}
+		return inner
+	}
+	return build(0), nil
+}
+
+// objectFieldSnippet adds a field to the last object of the path, and indexes it from the expression combining the object
+// with the objects it's added to. The field is then evaluated with the same `self` and `super` as the other fields
+func objectFieldSnippet(text string, path []ast.Node, field string) string {
+	object := path[len(path)-1]
+	enclosing := object
+	for i := len(path) - 2; i >= 0; i-- {
+		if binary, ok := path[i].(*ast.Binary); ok && binary.Op == ast.BopPlus {
+			enclosing = binary
+			continue
+		}
+		if _, ok := path[i].(*ast.ApplyBrace); ok {
+			enclosing = path[i]
+			continue
+		}
+		if _, ok := path[i].(*ast.Parens); ok {
+			enclosing = path[i]
+			continue
+		}
+		break
+	}
+
+	begin, end := textOffset(text, enclosing.Loc().Begin), textOffset(text, enclosing.Loc().End)
+	insertAt := textOffset(text, object.Loc().Begin) + len("{")
+	return fmt.Sprintf("(%s %s%s).%s", text[begin:insertAt], field, text[insertAt:end], evalItemField)
+}
+
+// parameterLocals binds the parameters of a function to their argument or default value
+func parameterLocals(text string, parameters []ast.Parameter, arguments map[string]string) string {
+	if len(parameters) == 0 {
+		return ""
+	}
+	var binds []string
+	for _, param := range parameters {
+		value, ok := arguments[string(param.Name)]
+		if !ok && param.DefaultArg != nil {
+			loc := param.DefaultArg.Loc()
+			value, ok = text[textOffset(text, loc.Begin):textOffset(text, loc.End)], true
+		}
+		if !ok {
+			value = missingArgument(param.Name)
+		}
+		binds = append(binds, fmt.Sprintf("%s = %s", param.Name, value))
+	}
+	return fmt.Sprintf("local %s;\n", strings.Join(binds, ", "))
+}
+
+// comprehensionLocals binds the variables of a comprehension visible from the child to their argument
+func comprehensionLocals(spec *ast.ForSpec, child ast.Node, arguments map[string]string) string {
+	var specs []*ast.ForSpec
+	for ; spec != nil; spec = spec.Outer {
+		specs = append([]*ast.ForSpec{spec}, specs...)
+	}
+	var binds []string
+	for _, spec := range specs {
+		// The array of a `for` only sees the variables of the previous ones
+		if spec.Expr == child {
+			break
+		}
+		value, ok := arguments[string(spec.VarName)]
+		if !ok {
+			value = missingArgument(spec.VarName)
+		}
+		binds = append(binds, fmt.Sprintf("%s = %s", spec.VarName, value))
+	}
+	if len(binds) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("local %s;\n", strings.Join(binds, ", "))
+}
+
+// missingArgument is the value of the variables without an argument, it only fails if the variable is used
+func missingArgument(name ast.Identifier) string {
+	return fmt.Sprintf("error 'no argument given for %s'", name)
+}
@@ -0,0 +1,119 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jdbaldry/go-language-server-protocol/lsp/protocol"
+)
+
+// runningVM is a VM evaluating in the background. It's abandoned if its evaluation is aborted before it returns
+type runningVM struct {
+	returned  chan struct{}
+	abandoned bool
+}
+
+// evalTimeout returns the timeout of the evaluations
+func (s *Server) evalTimeout() time.Duration {
+	if s.configuration.EvalTimeout > 0 {
+		return s.configuration.EvalTimeout
+	}
+	return defaultEvalTimeout
+}
+
+// runVM runs an evaluation in the background until it returns, the evaluation times out, or the context is done.
+// It returns errEvalTimeout or errEvalCanceled if the evaluation is aborted. The VM can't be interrupted: an aborted
+// evaluation keeps running, but its result must be dropped. The number of these abandoned VMs is capped, new evaluations
+// wait for them to return past it. If uri isn't empty, a single VM runs for the document at a time
+func (s *Server) runVM(ctx context.Context, uri protocol.DocumentURI, run func()) error {
+	timeout := s.evalTimeout()
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	aborted := func() error {
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return fmt.Errorf("%w after %s", errEvalTimeout, timeout)
+		}
+		return errEvalCanceled
+	}
+
+	vm, ok := s.startVM(ctx, uri)
+	if !ok {
+		return aborted()
+	}
+	go func() {
+		run()
+		s.finishVM(uri, vm)
+	}()
+
+	select {
+	case <-vm.returned:
+		return nil
+	case <-ctx.Done():
+		if !s.abandonVM(vm) {
+			return nil
+		}
+		return aborted()
+	}
+}
+
+// startVM waits for the running VM of a document to return, and for the number of abandoned VMs to be below the cap,
+// and marks a new one as running. It returns false if the context is done first
+func (s *Server) startVM(ctx context.Context, uri protocol.DocumentURI) (*runningVM, bool) {
+	for {
+		s.evalRunningMutex.Lock()
+		var wait chan struct{}
+		if running, ok := s.evalRunning[uri]; ok {
+			wait = running.returned
+		} else if s.evalAbandoned >= s.maxAbandonedEvaluations {
+			wait = s.evalAbandonedReturned
+		}
+		if wait == nil {
+			vm := &runningVM{returned: make(chan struct{})}
+			if uri != "" {
+				s.evalRunning[uri] = vm
+			}
+			s.evalRunningMutex.Unlock()
+			return vm, true
+		}
+		s.evalRunningMutex.Unlock()
+
+		select {
+		case <-wait:
+		case <-ctx.Done():
+			return nil, false
+		}
+	}
+}
+
+// finishVM marks a VM as returned
+func (s *Server) finishVM(uri protocol.DocumentURI, vm *runningVM) {
+	s.evalRunningMutex.Lock()
+	defer s.evalRunningMutex.Unlock()
+
+	if s.evalRunning[uri] == vm {
+		delete(s.evalRunning, uri)
+	}
+	if vm.abandoned {
+		s.evalAbandoned--
+		close(s.evalAbandonedReturned)
+		s.evalAbandonedReturned = make(chan struct{})
+	}
+	close(vm.returned)
+}
+
+// abandonVM marks the VM of an aborted evaluation as abandoned. It returns false if the VM returned in the meantime
+func (s *Server) abandonVM(vm *runningVM) bool {
+	s.evalRunningMutex.Lock()
+	defer s.evalRunningMutex.Unlock()
+
+	select {
+	case <-vm.returned:
+		return false
+	default:
+	}
+	vm.abandoned = true
+	s.evalAbandoned++
+	return true
+}
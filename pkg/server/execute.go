@@ -1,74 +1,138 @@
 package server
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
-	"reflect"
+	"sort"
+	"strings"
 
-	"github.com/grafana/jsonnet-language-server/pkg/ast/processing"
-	position "github.com/grafana/jsonnet-language-server/pkg/position_conversion"
 	"github.com/grafana/jsonnet-language-server/pkg/utils"
 	"github.com/jdbaldry/go-language-server-protocol/lsp/protocol"
 	log "github.com/sirupsen/logrus"
 )
 
-func (s *Server) ExecuteCommand(_ context.Context, params *protocol.ExecuteCommandParams) (interface{}, error) {
+func (s *Server) ExecuteCommand(ctx context.Context, params *protocol.ExecuteCommandParams) (interface{}, error) {
 	switch params.Command {
 	case "jsonnet.evalItem":
-		// WIP
-		return s.evalItem(params)
+		return s.evalItem(ctx, params)
 	case "jsonnet.evalFile":
-		params.Arguments = append(params.Arguments, json.RawMessage("\"\""))
-		return s.evalExpression(params)
+		// The whole file is evaluated with an empty expression, followed by the options
+		if len(params.Arguments) > 0 {
+			params.Arguments = append([]json.RawMessage{params.Arguments[0], json.RawMessage(`""`)}, params.Arguments[1:]...)
+		}
+		return s.evalExpression(ctx, params)
 	case "jsonnet.evalExpression":
-		return s.evalExpression(params)
+		return s.evalExpression(ctx, params)
 	}
 
 	return nil, fmt.Errorf("unknown command: %s", params.Command)
 }
 
-func (s *Server) evalItem(params *protocol.ExecuteCommandParams) (interface{}, error) {
+// evalItemResult is the result of the `jsonnet.evalItem` command
+type evalItemResult struct {
+	Result json.RawMessage `json:"result"`
+	Trace  string          `json:"trace,omitempty"`
+}
+
+// evalItemSelection is either a position or a range
+type evalItemSelection struct {
+	protocol.Position
+	Start *protocol.Position `json:"start,omitempty"`
+	End   *protocol.Position `json:"end,omitempty"`
+}
+
+// evalItem evaluates the expression at a position, or enclosing a range, in its context.
+// Arguments: file name, position or range, and optionally the values of the enclosing function parameters, as Jsonnet code.
+// The evaluation is aborted on timeout, or if the request is canceled
+func (s *Server) evalItem(ctx context.Context, params *protocol.ExecuteCommandParams) (interface{}, error) {
 	args := params.Arguments
-	if len(args) != 2 {
-		return nil, fmt.Errorf("expected 2 arguments, got %d", len(args))
+	if len(args) != 2 && len(args) != 3 {
+		return nil, fmt.Errorf("expected 2 or 3 arguments, got %d", len(args))
 	}
 
 	var fileName string
 	if err := json.Unmarshal(args[0], &fileName); err != nil {
 		return nil, fmt.Errorf("failed to unmarshal file name: %v", err)
 	}
-	var p protocol.Position
-	if err := json.Unmarshal(args[1], &p); err != nil {
+	var selection evalItemSelection
+	if err := json.Unmarshal(args[1], &selection); err != nil {
 		return nil, fmt.Errorf("failed to unmarshal position: %v", err)
 	}
+	start, end := selection.Position, selection.Position
+	if selection.Start != nil && selection.End != nil {
+		start, end = *selection.Start, *selection.End
+	}
+	var arguments map[string]string
+	if len(args) == 3 {
+		if err := json.Unmarshal(args[2], &arguments); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal arguments: %v", err)
+		}
+	}
 
 	doc, err := s.cache.Get(protocol.URIFromPath(fileName))
 	if err != nil {
 		return nil, utils.LogErrorf("evalItem: %s: %w", errorRetrievingDocument, err)
 	}
 
-	stack, err := processing.FindNodeByPosition(doc.AST, position.ProtocolToAST(p))
+	converter := s.converter(doc.Item.Text)
+	snippet, err := evalItemSnippet(fileName, doc.Item.Text, converter.ProtocolToAST(start), converter.ProtocolToAST(end), arguments)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("no expression found at %v: %w", start, err)
 	}
-
-	if stack.IsEmpty() {
-		return nil, fmt.Errorf("no node found at position %v", p)
+	log.Debugf("evalItem: evaluating %s", snippet)
+
+	var trace bytes.Buffer
+	var result string
+	if abortErr := s.runVM(ctx, "", func() {
+		vm := s.getVM(fileName)
+		vm.SetTraceOut(&trace)
+		result, err = vm.EvaluateAnonymousSnippet(fileName, snippet)
+	}); abortErr != nil {
+		return nil, utils.LogErrorf("evalItem: %w", abortErr)
 	}
+	if err != nil {
+		return nil, err
+	}
+	return evalItemResult{Result: json.RawMessage(result), Trace: trace.String()}, nil
+}
 
-	log.Infof("fileName: %s", fileName)
-	log.Infof("position: %+v", p)
+// evalOptions are the optional last argument of the `jsonnet.evalFile` and `jsonnet.evalExpression` commands
+type evalOptions struct {
+	// Top-level arguments, as strings or as Jsonnet code
+	TLAStr  map[string]string `json:"tlaStr,omitempty"`
+	TLACode map[string]string `json:"tlaCode,omitempty"`
+	// Output format: json (default), yaml, yaml-stream, multi or string
+	Format string `json:"format,omitempty"`
+}
 
-	node := stack.Pop()
+// evalResult is the result of an evaluation with options. Multi-file evaluations return the content of each file
+type evalResult struct {
+	Format   string            `json:"format"`
+	Language string            `json:"language"`
+	Output   string            `json:"output,omitempty"`
+	Files    map[string]string `json:"files,omitempty"`
+}
 
-	return nil, fmt.Errorf("%v: %+v", reflect.TypeOf(node), node)
+// evalManifests are the expressions manifesting the evaluated value in each output format, and the language of the output
+var evalManifests = map[string]struct {
+	expression, language string
+}{
+	"json":        {"%s", "json"},
+	"yaml":        {"std.manifestYamlDoc(%s, quote_keys=false)", "yaml"},
+	"yaml-stream": {"std.rstripChars(std.manifestYamlStream(%s, c_document_end=false, quote_keys=false), '\\n')", "yaml"},
+	"multi":       {"%s", "json"},
+	"string":      {"%s", "plaintext"},
 }
 
-func (s *Server) evalExpression(params *protocol.ExecuteCommandParams) (interface{}, error) {
+// evalExpression evaluates an expression on the value of a file.
+// Arguments: file name, expression (empty to evaluate the whole file), and optionally the evaluation options.
+// Without options, the result is the JSON output. The evaluation is aborted on timeout, or if the request is canceled
+func (s *Server) evalExpression(ctx context.Context, params *protocol.ExecuteCommandParams) (interface{}, error) {
 	args := params.Arguments
-	if len(args) != 2 {
-		return nil, fmt.Errorf("expected 2 arguments, got %d", len(args))
+	if len(args) != 2 && len(args) != 3 {
+		return nil, fmt.Errorf("expected 2 or 3 arguments, got %d", len(args))
 	}
 
 	var fileName string
@@ -79,14 +143,72 @@ func (s *Server) evalExpression(params *protocol.ExecuteCommandParams) (interfac
 	if err := json.Unmarshal(args[1], &expression); err != nil {
 		return nil, fmt.Errorf("failed to unmarshal expression: %v", err)
 	}
+	var options evalOptions
+	if len(args) == 3 {
+		if err := json.Unmarshal(args[2], &options); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal options: %v", err)
+		}
+	}
+	if options.Format == "" {
+		options.Format = "json"
+	}
+	manifest, ok := evalManifests[options.Format]
+	if !ok {
+		return nil, fmt.Errorf("unknown output format: %s", options.Format)
+	}
 
 	// TODO: Replace this stuff with Tanka's `eval` code
 	vm := s.getVM(fileName)
+	var tlaNames []string
+	for name, value := range options.TLAStr {
+		vm.TLAVar(name, value)
+		tlaNames = append(tlaNames, name)
+	}
+	for name, value := range options.TLACode {
+		vm.TLACode(name, value)
+		tlaNames = append(tlaNames, name)
+	}
+	script, err := evalScript(fileName, expression, manifest.expression, tlaNames)
+	if err != nil {
+		return nil, err
+	}
 
-	script := fmt.Sprintf("local main = (import '%s');\nmain", fileName)
-	if expression != "" {
-		script += "." + expression
+	result := evalResult{Format: options.Format, Language: manifest.language}
+	if abortErr := s.runVM(ctx, "", func() {
+		if options.Format == "multi" {
+			result.Files, err = vm.EvaluateAnonymousSnippetMulti(fileName, script)
+			return
+		}
+		vm.StringOutput = options.Format != "json"
+		result.Output, err = vm.EvaluateAnonymousSnippet(fileName, script)
+	}); abortErr != nil {
+		return nil, utils.LogErrorf("evalExpression: %w", abortErr)
 	}
 
-	return vm.EvaluateAnonymousSnippet(fileName, script)
+	if len(args) == 2 {
+		return result.Output, err
+	}
+	return result, err
+}
+
+// evalScript returns the snippet evaluating the expression on the value of a file. Like the jsonnet command,
+// files evaluating to a function are called with the top-level arguments, which are passed through the snippet
+func evalScript(fileName, expression, manifest string, tlaNames []string) (string, error) {
+	sort.Strings(tlaNames)
+	var tlaArgs []string
+	for _, name := range tlaNames {
+		if !identifierRegexp.MatchString(name) || jsonnetKeywords[name] {
+			return "", fmt.Errorf("invalid top-level argument name: %s", name)
+		}
+		tlaArgs = append(tlaArgs, fmt.Sprintf("%s=%s", name, name))
+	}
+	// Marshalling a string can't fail. The file is imported instead of bound to a variable that could shadow the arguments
+	importPath, _ := json.Marshal(fileName)
+	file := fmt.Sprintf("(import %s)", importPath)
+
+	value := fmt.Sprintf("(if std.isFunction%s then %s(%s) else %s)", file, file, strings.Join(tlaArgs, ", "), file)
+	if expression != "" {
+		value += "." + expression
+	}
+	return fmt.Sprintf("function(%s)\n  %s", strings.Join(tlaNames, ", "), fmt.Sprintf(manifest, value)), nil
 }
@@ -0,0 +1,280 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/jdbaldry/go-language-server-protocol/lsp/protocol"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEvalItem(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		fileContent string
+		selection   interface{}
+		arguments   map[string]string
+		expected    string
+		trace       string
+		evalTimeout time.Duration
+		expectedErr string
+	}{
+		{
+			name:        "variable in a local body",
+			fileContent: "local a = 1, b = a + 1;\n[a, b]\n",
+			selection:   protocol.Position{Line: 1, Character: 4},
+			expected:    "2",
+		},
+		{
+			name:        "range of an expression",
+			fileContent: "local a = 1;\n{ b: a * 2 + 3 }\n",
+			selection:   selectionRange(1, 5, 1, 10),
+			expected:    "2",
+		},
+		{
+			name:        "self and object locals",
+			fileContent: "{\n  local prefix = 'app-',\n  name: 'web',\n  fullName: prefix + self.name,\n}\n",
+			selection:   selectionRange(3, 12, 3, 30),
+			expected:    `"app-web"`,
+		},
+		{
+			name:        "super and the combined object",
+			fileContent: "{ a: 1, b: 2 } + {\n  a: super.a + self.b,\n} + { b: 10 }\n",
+			selection:   selectionRange(1, 5, 1, 20),
+			expected:    "11",
+		},
+		{
+			name:        "nested objects",
+			fileContent: "{\n  replicas: 2,\n  spec: { count: $.replicas * 2 },\n}\n",
+			selection:   selectionRange(2, 17, 2, 31),
+			expected:    "4",
+		},
+		{
+			name:        "function parameters with arguments",
+			fileContent: "local f(x, y=x + 1) = x * y;\nf(2)\n",
+			selection:   selectionRange(0, 22, 0, 27),
+			arguments:   map[string]string{"x": "3"},
+			expected:    "12",
+		},
+		{
+			name:        "method parameters",
+			fileContent: "{\n  scale: 10,\n  f(x):: x * self.scale,\n}\n",
+			selection:   selectionRange(2, 9, 2, 23),
+			arguments:   map[string]string{"x": "5"},
+			expected:    "50",
+		},
+		{
+			name:        "comprehension variable",
+			fileContent: "[x * 2 for x in [1, 2]]\n",
+			selection:   selectionRange(0, 1, 0, 6),
+			arguments:   map[string]string{"x": "21"},
+			expected:    "42",
+		},
+		{
+			name:        "missing argument",
+			fileContent: "function(x) x + 1\n",
+			selection:   selectionRange(0, 12, 0, 17),
+			expectedErr: "no argument given for x",
+		},
+		{
+			name:        "trace output",
+			fileContent: "local a = 1;\nstd.trace('a is %d' % a, a)\n",
+			selection:   selectionRange(1, 0, 1, 27),
+			expected:    "1",
+			trace:       "a is 1",
+		},
+		{
+			name:        "timeout",
+			fileContent: "local slow = std.foldl(function(acc, i) acc + i, std.range(1, 300000), 0);\nslow + 1\n",
+			selection:   selectionRange(1, 0, 1, 8),
+			evalTimeout: 10 * time.Millisecond,
+			expectedErr: "evaluation timed out after 10ms",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			server, uri := testServerWithFile(t, nil, tc.fileContent)
+			if tc.evalTimeout > 0 {
+				withEvalTimeout(t, server, tc.evalTimeout)
+			}
+
+			arguments := []json.RawMessage{}
+			for _, argument := range []interface{}{uri.SpanURI().Filename(), tc.selection} {
+				marshalled, err := json.Marshal(argument)
+				require.NoError(t, err)
+				arguments = append(arguments, marshalled)
+			}
+			if tc.arguments != nil {
+				marshalled, err := json.Marshal(tc.arguments)
+				require.NoError(t, err)
+				arguments = append(arguments, marshalled)
+			}
+
+			result, err := server.ExecuteCommand(context.Background(), &protocol.ExecuteCommandParams{
+				Command:   "jsonnet.evalItem",
+				Arguments: arguments,
+			})
+			if tc.expectedErr != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tc.expectedErr)
+				return
+			}
+			require.NoError(t, err)
+			require.IsType(t, evalItemResult{}, result)
+			assert.JSONEq(t, tc.expected, string(result.(evalItemResult).Result))
+			if tc.trace == "" {
+				assert.Empty(t, result.(evalItemResult).Trace)
+			} else {
+				assert.Contains(t, result.(evalItemResult).Trace, tc.trace)
+			}
+		})
+	}
+}
+
+func TestEvalExpression(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		fileContent string
+		command     string
+		expression  string
+		options     *evalOptions
+		expected    interface{}
+		evalTimeout time.Duration
+		expectedErr string
+	}{
+		{
+			name:        "file without options",
+			fileContent: "{ a: 1 }",
+			command:     "jsonnet.evalFile",
+			expected:    "{\n   \"a\": 1\n}\n",
+		},
+		{
+			name:        "expression without options",
+			fileContent: "{ a: { b: 1 } }",
+			command:     "jsonnet.evalExpression",
+			expression:  "a",
+			expected:    "{\n   \"b\": 1\n}\n",
+		},
+		{
+			name:        "function with default arguments",
+			fileContent: "function(name='app') { name: name }",
+			command:     "jsonnet.evalExpression",
+			expression:  "name",
+			expected:    "\"app\"\n",
+		},
+		{
+			name:        "top-level arguments",
+			fileContent: "function(name, replicas) { name: name, replicas: replicas }",
+			command:     "jsonnet.evalFile",
+			options: &evalOptions{
+				TLAStr:  map[string]string{"name": "web"},
+				TLACode: map[string]string{"replicas": "1 + 2"},
+			},
+			expected: evalResult{Format: "json", Language: "json", Output: "{\n   \"name\": \"web\",\n   \"replicas\": 3\n}\n"},
+		},
+		{
+			name:        "top-level arguments are ignored by objects",
+			fileContent: "{ a: 1 }",
+			command:     "jsonnet.evalExpression",
+			expression:  "a",
+			options:     &evalOptions{TLAStr: map[string]string{"name": "web"}},
+			expected:    evalResult{Format: "json", Language: "json", Output: "1\n"},
+		},
+		{
+			name:        "yaml",
+			fileContent: "{ name: 'web', ports: [80, 443] }",
+			command:     "jsonnet.evalFile",
+			options:     &evalOptions{Format: "yaml"},
+			expected:    evalResult{Format: "yaml", Language: "yaml", Output: "name: \"web\"\nports:\n- 80\n- 443\n"},
+		},
+		{
+			name:        "yaml stream",
+			fileContent: "[{ kind: 'Service' }, { kind: 'Deployment' }]",
+			command:     "jsonnet.evalFile",
+			options:     &evalOptions{Format: "yaml-stream"},
+			expected:    evalResult{Format: "yaml-stream", Language: "yaml", Output: "---\nkind: \"Service\"\n---\nkind: \"Deployment\"\n"},
+		},
+		{
+			name:        "multiple files",
+			fileContent: "{ 'a.json': { a: 1 }, 'b.json': [2] }",
+			command:     "jsonnet.evalFile",
+			options:     &evalOptions{Format: "multi"},
+			expected: evalResult{Format: "multi", Language: "json", Files: map[string]string{
+				"a.json": "{\n   \"a\": 1\n}\n",
+				"b.json": "[\n   2\n]\n",
+			}},
+		},
+		{
+			name:        "string",
+			fileContent: "{ config: 'key=value\\n' }",
+			command:     "jsonnet.evalExpression",
+			expression:  "config",
+			options:     &evalOptions{Format: "string"},
+			expected:    evalResult{Format: "string", Language: "plaintext", Output: "key=value\n\n"},
+		},
+		{
+			name:        "unknown format",
+			fileContent: "{}",
+			command:     "jsonnet.evalFile",
+			options:     &evalOptions{Format: "toml"},
+			expectedErr: "unknown output format: toml",
+		},
+		{
+			name:        "invalid top-level argument name",
+			fileContent: "function(x) x",
+			command:     "jsonnet.evalFile",
+			options:     &evalOptions{TLACode: map[string]string{"local": "1"}},
+			expectedErr: "invalid top-level argument name: local",
+		},
+		{
+			name:        "timeout",
+			fileContent: "{ slow: std.foldl(function(acc, i) acc + i, std.range(1, 300000), 0) }",
+			command:     "jsonnet.evalFile",
+			evalTimeout: 10 * time.Millisecond,
+			expectedErr: "evaluation timed out after 10ms",
+		},
+		{
+			name:        "timeout of multiple files",
+			fileContent: "{ 'slow.json': std.foldl(function(acc, i) acc + i, std.range(1, 300000), 0) }",
+			command:     "jsonnet.evalFile",
+			options:     &evalOptions{Format: "multi"},
+			evalTimeout: 10 * time.Millisecond,
+			expectedErr: "evaluation timed out after 10ms",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			server, uri := testServerWithFile(t, nil, tc.fileContent)
+			if tc.evalTimeout > 0 {
+				withEvalTimeout(t, server, tc.evalTimeout)
+			}
+
+			values := []interface{}{uri.SpanURI().Filename()}
+			if tc.command == "jsonnet.evalExpression" {
+				values = append(values, tc.expression)
+			}
+			if tc.options != nil {
+				values = append(values, tc.options)
+			}
+			arguments := []json.RawMessage{}
+			for _, value := range values {
+				marshalled, err := json.Marshal(value)
+				require.NoError(t, err)
+				arguments = append(arguments, marshalled)
+			}
+
+			result, err := server.ExecuteCommand(context.Background(), &protocol.ExecuteCommandParams{
+				Command:   tc.command,
+				Arguments: arguments,
+			})
+			if tc.expectedErr != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tc.expectedErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, result)
+		})
+	}
+}
@@ -0,0 +1,168 @@
+package server
+
+import (
+	"context"
+	"sort"
+
+	"github.com/google/go-jsonnet/ast"
+	"github.com/google/go-jsonnet/toolutils"
+	"github.com/grafana/jsonnet-language-server/pkg/utils"
+	"github.com/jdbaldry/go-language-server-protocol/lsp/protocol"
+)
+
+func (s *Server) FoldingRange(_ context.Context, params *protocol.FoldingRangeParams) ([]protocol.FoldingRange, error) {
+	doc, err := s.cache.Get(params.TextDocument.URI)
+	if err != nil {
+		return nil, utils.LogErrorf("FoldingRange: %s: %w", errorRetrievingDocument, err)
+	}
+
+	// Comments are found in the current text, they don't need a valid AST
+	ranges := commentFoldingRanges(doc.Item.Text)
+
+	// The AST is the last one that was successfully parsed. If lines changed since then, the ranges may be slightly off,
+	// but that's better than losing all folding while the user is typing
+	if doc.AST != nil {
+		ranges = append(ranges, astFoldingRanges(doc.AST)...)
+	}
+
+	sort.SliceStable(ranges, func(i, j int) bool {
+		if ranges[i].StartLine != ranges[j].StartLine {
+			return ranges[i].StartLine < ranges[j].StartLine
+		}
+		return ranges[i].EndLine > ranges[j].EndLine
+	})
+
+	return ranges, nil
+}
+
+// astFoldingRanges returns the folding ranges of objects, arrays, functions, multiline strings and local chains
+func astFoldingRanges(root ast.Node) []protocol.FoldingRange {
+	var ranges []protocol.FoldingRange
+	addRange := func(startLine, endLine int, kind protocol.FoldingRangeKind) {
+		// AST lines are 1-based, protocol lines are 0-based
+		if startLine > 0 && endLine > startLine {
+			ranges = append(ranges, protocol.FoldingRange{
+				StartLine: uint32(startLine - 1),
+				EndLine:   uint32(endLine - 1),
+				Kind:      string(kind),
+			})
+		}
+	}
+
+	// Locals that are the body of another local are part of the same chain
+	chained := map[*ast.Local]bool{}
+
+	var visit func(node ast.Node)
+	visit = func(node ast.Node) {
+		if node == nil {
+			return
+		}
+		loc := node.Loc()
+
+		switch node := node.(type) {
+		case *ast.DesugaredObject, *ast.Array, *ast.LiteralString:
+			// Keep the closing bracket or string delimiter visible
+			addRange(loc.Begin.Line, loc.End.Line-1, "")
+		case *ast.Function:
+			// If the body starts on the same line (`function(x) {`), it has its own folding range
+			if bodyLoc := node.Body.Loc(); bodyLoc == nil || bodyLoc.Begin.Line != loc.Begin.Line {
+				addRange(loc.Begin.Line, loc.End.Line, "")
+			}
+		case *ast.Local:
+			if !chained[node] {
+				var binds []ast.LocalBind
+				for current := node; current != nil; {
+					binds = append(binds, current.Binds...)
+					next, ok := current.Body.(*ast.Local)
+					if ok {
+						chained[next] = true
+					}
+					current = next
+				}
+				if len(binds) > 1 {
+					addRange(bindBegin(binds[0]).Line, bindEnd(binds[len(binds)-1]).Line, "")
+				}
+				for _, run := range importRuns(binds) {
+					addRange(run[0], run[1], protocol.Region)
+				}
+			}
+		}
+
+		for _, child := range toolutils.Children(node) {
+			visit(child)
+		}
+	}
+	visit(root)
+
+	return ranges
+}
+
+// importRuns returns the first and last lines of runs of consecutive `local x = import '...'` lines
+func importRuns(binds []ast.LocalBind) [][2]int {
+	var runs [][2]int
+	for _, bind := range binds {
+		switch bind.Body.(type) {
+		case *ast.Import, *ast.ImportStr, *ast.ImportBin:
+		default:
+			continue
+		}
+		begin, end := bindBegin(bind).Line, bindEnd(bind).Line
+		if len(runs) > 0 && runs[len(runs)-1][1] >= begin-1 {
+			runs[len(runs)-1][1] = end
+		} else {
+			runs = append(runs, [2]int{begin, end})
+		}
+	}
+	return runs
+}
+
+// bindBegin returns the beginning of a bind. Binds of functions (`local f(x) = ...`) have no location,
+// their body starts at the name of the function
+func bindBegin(bind ast.LocalBind) ast.Location {
+	if bind.LocRange.Begin.IsSet() {
+		return bind.LocRange.Begin
+	}
+	return bind.Body.Loc().Begin
+}
+
+func bindEnd(bind ast.LocalBind) ast.Location {
+	if bodyLoc := bind.Body.Loc(); bodyLoc != nil && bodyLoc.End.IsSet() {
+		return bodyLoc.End
+	}
+	return bind.LocRange.End
+}
+
+// commentFoldingRanges returns the folding ranges of multiline comments and runs of consecutive single line comments
+func commentFoldingRanges(text string) []protocol.FoldingRange {
+	var ranges []protocol.FoldingRange
+	addRange := func(startLine, endLine int) {
+		if endLine > startLine {
+			ranges = append(ranges, protocol.FoldingRange{
+				StartLine: uint32(startLine),
+				EndLine:   uint32(endLine),
+				Kind:      string(protocol.Comment),
+			})
+		}
+	}
+
+	// Start and end of the current run of lines containing only a single line comment
+	runStart, runEnd := -1, -1
+	for _, token := range scanText(text) {
+		switch token.kind {
+		case lineCommentToken:
+			if !token.firstOnLine {
+				continue
+			}
+			if runStart < 0 || runEnd != token.line-1 {
+				addRange(runStart, runEnd)
+				runStart = token.line
+			}
+			runEnd = token.line
+		case blockCommentToken:
+			addRange(token.line, token.endLine)
+		}
+	}
+	addRange(runStart, runEnd)
+
+	return ranges
+}
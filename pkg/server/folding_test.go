@@ -0,0 +1,56 @@
+package server
+
+import (
+	"context"
+	"testing"
+
+	"github.com/jdbaldry/go-language-server-protocol/lsp/protocol"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFoldingRange(t *testing.T) {
+	server := NewServer("any", "test version", nil, Configuration{})
+	uri := serverOpenTestFile(t, server, "testdata/folding.jsonnet")
+
+	expected := []protocol.FoldingRange{
+		{StartLine: 0, EndLine: 1, Kind: "comment"},
+		{StartLine: 2, EndLine: 6},
+		{StartLine: 2, EndLine: 4, Kind: "region"},
+		{StartLine: 5, EndLine: 6},
+		{StartLine: 8, EndLine: 10, Kind: "comment"},
+		{StartLine: 11, EndLine: 25},
+		{StartLine: 12, EndLine: 14},
+		{StartLine: 16, EndLine: 18},
+		{StartLine: 20, EndLine: 21},
+		{StartLine: 23, EndLine: 24},
+	}
+
+	ranges, err := server.FoldingRange(context.Background(), &protocol.FoldingRangeParams{
+		TextDocument: protocol.TextDocumentIdentifier{URI: uri},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, expected, ranges)
+}
+
+func TestFoldingRangeStaleAST(t *testing.T) {
+	server := NewServer("any", "test version", nil, Configuration{})
+	uri := serverOpenTestFile(t, server, "testdata/folding.jsonnet")
+
+	doc, err := server.cache.Get(uri)
+	require.NoError(t, err)
+	err = server.DidChange(context.Background(), &protocol.DidChangeTextDocumentParams{
+		TextDocument: protocol.VersionedTextDocumentIdentifier{
+			TextDocumentIdentifier: protocol.TextDocumentIdentifier{URI: uri},
+			Version:                doc.Item.Version + 1,
+		},
+		ContentChanges: []protocol.TextDocumentContentChangeEvent{{Text: doc.Item.Text + "{ invalid"}},
+	})
+	require.NoError(t, err)
+
+	ranges, err := server.FoldingRange(context.Background(), &protocol.FoldingRangeParams{
+		TextDocument: protocol.TextDocumentIdentifier{URI: uri},
+	})
+	require.NoError(t, err)
+	assert.Contains(t, ranges, protocol.FoldingRange{StartLine: 11, EndLine: 25})
+}
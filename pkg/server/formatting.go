@@ -2,8 +2,11 @@ package server
 
 import (
 	"context"
+	"strings"
 
+	"github.com/google/go-jsonnet/ast"
 	"github.com/google/go-jsonnet/formatter"
+	"github.com/grafana/jsonnet-language-server/pkg/ast/processing"
 	"github.com/grafana/jsonnet-language-server/pkg/utils"
 	"github.com/hexops/gotextdiff/myers"
 	"github.com/hexops/gotextdiff/span"
@@ -42,3 +45,195 @@ func getTextEdits(before, after string) []protocol.TextEdit {
 
 	return result
 }
+
+func (s *Server) RangeFormatting(_ context.Context, params *protocol.DocumentRangeFormattingParams) ([]protocol.TextEdit, error) {
+	doc, err := s.cache.Get(params.TextDocument.URI)
+	if err != nil {
+		return nil, utils.LogErrorf("RangeFormatting: %s: %w", errorRetrievingDocument, err)
+	}
+
+	// The range is expanded to the nodes of the AST, their locations must match the text
+	if doc.AST == nil || len(doc.LinesChangedSinceAST) > 0 {
+		log.Errorf("RangeFormatting: document was changed since last successful parse, can't format range")
+		return nil, nil
+	}
+
+	filename := params.TextDocument.URI.SpanURI().Filename()
+	formatRange := s.converter(doc.Item.Text).RangeProtocolToAST(params.Range)
+	nodes := enclosingNodes(doc.AST, formatRange.Begin, formatRange.End)
+	// Start with the smallest expression. Nodes whose text isn't a complete expression can't be formatted, their parent is tried instead
+	for i := len(nodes) - 1; i >= 0; i-- {
+		formatted, err := formatNode(filename, doc.Item.Text, nodes[i], s.configuration.FormattingOptions)
+		if err != nil {
+			log.Debugf("RangeFormatting: could not format node: %v", err)
+			continue
+		}
+		return getTextEdits(doc.Item.Text, formatted), nil
+	}
+
+	log.Errorf("RangeFormatting: no expression to format in the range")
+	return nil, nil
+}
+
+func (s *Server) OnTypeFormatting(_ context.Context, params *protocol.DocumentOnTypeFormattingParams) ([]protocol.TextEdit, error) {
+	doc, err := s.cache.Get(params.TextDocument.URI)
+	if err != nil {
+		return nil, utils.LogErrorf("OnTypeFormatting: %s: %w", errorRetrievingDocument, err)
+	}
+
+	switch params.Ch {
+	case "\n":
+		// The document is usually invalid while typing, the new line is indented from the text only
+		return reindentLine(doc.Item.Text, int(params.Position.Line), s.configuration.FormattingOptions.Indent), nil
+	case "}", "]":
+		if doc.AST == nil || len(doc.LinesChangedSinceAST) > 0 {
+			log.Debugf("OnTypeFormatting: document was changed since last successful parse")
+			return nil, nil
+		}
+		block := findClosedBlock(doc.Item.Text, doc.AST, s.converter(doc.Item.Text).ProtocolToAST(params.Position))
+		if block == nil {
+			return nil, nil
+		}
+		formatted, err := formatNode(params.TextDocument.URI.SpanURI().Filename(), doc.Item.Text, block, s.configuration.FormattingOptions)
+		if err != nil {
+			log.Debugf("OnTypeFormatting: could not format block: %v", err)
+			return nil, nil
+		}
+		return getTextEdits(doc.Item.Text, formatted), nil
+	}
+	return nil, nil
+}
+
+// enclosingNodes returns the nodes containing the range, from the outermost to the innermost
+func enclosingNodes(root ast.Node, begin, end ast.Location) []ast.Node {
+	var nodes []ast.Node
+	processing.Walk(root, func(node ast.Node) bool {
+		if loc := node.Loc(); loc.Begin.IsSet() {
+			if isBefore(begin, loc.Begin) || isBefore(loc.End, end) {
+				return false
+			}
+			nodes = append(nodes, node)
+		}
+		// Desugared nodes without a location can still contain the range
+		return true
+	})
+	return nodes
+}
+
+// findClosedBlock returns the outermost object or array ending at the location
+func findClosedBlock(text string, root ast.Node, location ast.Location) ast.Node {
+	var block ast.Node
+	processing.Walk(root, func(node ast.Node) bool {
+		if block != nil {
+			return false
+		}
+		if loc := node.Loc(); loc.Begin.IsSet() && loc.End == location {
+			if opening := textOffset(text, loc.Begin); opening < len(text) && (text[opening] == '{' || text[opening] == '[') {
+				block = node
+				return false
+			}
+		}
+		return true
+	})
+	return block
+}
+
+// formatNode formats the text of a node as a standalone expression, and returns the document with the formatted node.
+// The node is indented like the line it starts on
+func formatNode(filename, text string, node ast.Node, options formatter.Options) (string, error) {
+	from, to := textOffset(text, node.Loc().Begin), textOffset(text, node.Loc().End)
+	formatted, err := formatter.Format(filename, text[from:to], options)
+	if err != nil {
+		return "", err
+	}
+	formatted = strings.TrimSuffix(formatted, "\n")
+
+	lineStart := strings.LastIndexByte(text[:from], '\n') + 1
+	line := text[lineStart:]
+	indentation := line[:len(line)-len(strings.TrimLeft(line, " \t"))]
+
+	return text[:from] + indentContinuationLines(formatted, indentation) + text[to:], nil
+}
+
+// indentContinuationLines indents the lines of formatted code that follow the first one.
+// Lines inside multi-line strings are part of the value, they are not indented. Text blocks can be indented
+func indentContinuationLines(formatted, indentation string) string {
+	rawLines := map[int]bool{}
+	for _, token := range scanText(formatted) {
+		if token.kind == stringToken && !strings.HasPrefix(token.text, "|||") {
+			for l := token.line + 1; l <= token.endLine; l++ {
+				rawLines[l] = true
+			}
+		}
+	}
+	lines := strings.Split(formatted, "\n")
+	for i := 1; i < len(lines); i++ {
+		if lines[i] != "" && !rawLines[i] {
+			lines[i] = indentation + lines[i]
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// reindentLine indents a line one level deeper than the line of the innermost bracket that is still open.
+// Lines starting with a closing bracket are indented like the line of the opening bracket
+func reindentLine(text string, line, indent int) []protocol.TextEdit {
+	lines := strings.Split(text, "\n")
+	if line >= len(lines) {
+		return nil
+	}
+	lineStart := 0
+	for i := 0; i < line; i++ {
+		lineStart += len(lines[i]) + 1
+	}
+
+	var openBrackets []int
+	tokens := scanText(text)
+	for i := 0; i < lineStart; i++ {
+		// Brackets in strings and comments are ignored
+		if len(tokens) > 0 && i >= tokens[0].start {
+			if tokens[0].kind != wordToken {
+				if tokens[0].end >= lineStart {
+					// The line is part of a multi-line string or comment
+					return nil
+				}
+				i = tokens[0].end - 1
+			}
+			tokens = tokens[1:]
+			continue
+		}
+		switch text[i] {
+		case '{', '[', '(':
+			openBrackets = append(openBrackets, i)
+		case '}', ']', ')':
+			if len(openBrackets) > 0 {
+				openBrackets = openBrackets[:len(openBrackets)-1]
+			}
+		}
+	}
+	if len(openBrackets) == 0 {
+		return nil
+	}
+
+	opening := openBrackets[len(openBrackets)-1]
+	openingLine := text[strings.LastIndexByte(text[:opening], '\n')+1:]
+	expected := openingLine[:len(openingLine)-len(strings.TrimLeft(openingLine, " \t"))]
+	content := strings.TrimLeft(lines[line], " \t")
+	if !strings.HasPrefix(content, "}") && !strings.HasPrefix(content, "]") && !strings.HasPrefix(content, ")") {
+		expected += strings.Repeat(" ", indent)
+	}
+
+	current := lines[line][:len(lines[line])-len(content)]
+	if current == expected {
+		return nil
+	}
+	return []protocol.TextEdit{
+		{
+			Range: protocol.Range{
+				Start: protocol.Position{Line: uint32(line), Character: 0},
+				End:   protocol.Position{Line: uint32(line), Character: uint32(len(current))},
+			},
+			NewText: expected,
+		},
+	}
+}
@@ -3,6 +3,7 @@ package server
 import (
 	"context"
 	"fmt"
+	"strings"
 	"testing"
 
 	"github.com/jdbaldry/go-language-server-protocol/lsp/protocol"
@@ -156,3 +157,240 @@ func makeRange(t *testing.T, rangeStr string) protocol.Range {
 	require.Equal(t, 4, n)
 	return ret
 }
+
+// applyTextEdits applies sorted, non-overlapping edits to a text
+func applyTextEdits(t *testing.T, text string, edits []protocol.TextEdit) string {
+	t.Helper()
+
+	lines := strings.SplitAfter(text, "\n")
+	offset := func(pos protocol.Position) int {
+		result := 0
+		for i := 0; i < int(pos.Line) && i < len(lines); i++ {
+			result += len(lines[i])
+		}
+		return result + int(pos.Character)
+	}
+
+	var result strings.Builder
+	cursor := 0
+	for _, edit := range edits {
+		start, end := offset(edit.Range.Start), offset(edit.Range.End)
+		require.GreaterOrEqual(t, start, cursor, "edits must be sorted and must not overlap")
+		result.WriteString(text[cursor:start])
+		result.WriteString(edit.NewText)
+		cursor = end
+	}
+	result.WriteString(text[cursor:])
+	return result.String()
+}
+
+func TestRangeFormatting(t *testing.T) {
+	testCases := []struct {
+		name        string
+		fileContent string
+		rangeStr    string
+		expected    string
+	}{
+		{
+			name: "field value",
+			fileContent: `{
+  a:   {b:1,   c:   2},
+  d:   [1,2],
+}
+`,
+			rangeStr: "1:7-1:11",
+			expected: `{
+  a:   { b: 1, c: 2 },
+  d:   [1,2],
+}
+`,
+		},
+		{
+			name: "selection expanded to the enclosing object",
+			fileContent: `{
+  a:   1,
+  d:   [1,2],
+}
+`,
+			rangeStr: "1:2-1:8",
+			expected: `{
+  a: 1,
+  d: [1, 2],
+}
+`,
+		},
+		{
+			name: "nested block keeps the indentation of its line",
+			fileContent: `local x = {
+  nested: {
+        a: 1,
+     b: [
+  1, 2,
+          ],
+  },
+};
+x
+`,
+			rangeStr: "2:8-2:8",
+			expected: `local x = {
+  nested: {
+    a: 1,
+    b: [
+      1,
+      2,
+    ],
+  },
+};
+x
+`,
+		},
+		{
+			name: "multi-line verbatim strings are not indented",
+			fileContent: `{
+  nested: {a: @"one
+two",
+    b: |||
+      three
+    |||},
+}
+`,
+			rangeStr: "1:11-1:12",
+			expected: `{
+  nested: {
+    a: @"one
+two",
+    b: |||
+      three
+    |||,
+  },
+}
+`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			s, fileURI := testServerWithFile(t, nil, tc.fileContent)
+
+			edits, err := s.RangeFormatting(context.TODO(), &protocol.DocumentRangeFormattingParams{
+				TextDocument: protocol.TextDocumentIdentifier{URI: fileURI},
+				Range:        makeRange(t, tc.rangeStr),
+			})
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, applyTextEdits(t, tc.fileContent, edits))
+		})
+	}
+}
+
+func TestOnTypeFormatting(t *testing.T) {
+	testCases := []struct {
+		name        string
+		fileContent string
+		position    protocol.Position
+		ch          string
+		expected    string
+	}{
+		{
+			name: "closed object",
+			fileContent: `{
+  a: {
+  b:   1,
+      c: [1,2]},
+}
+`,
+			position: protocol.Position{Line: 3, Character: 15},
+			ch:       "}",
+			expected: `{
+  a: {
+    b: 1,
+    c: [1, 2],
+  },
+}
+`,
+		},
+		{
+			name: "closed array",
+			fileContent: `local a = [
+1,   2
+    ];
+a
+`,
+			position: protocol.Position{Line: 2, Character: 5},
+			ch:       "]",
+			expected: `local a = [
+  1,
+  2,
+];
+a
+`,
+		},
+		{
+			name: "new line in an object",
+			fileContent: `{
+  a: {
+b
+  },
+}
+`,
+			position: protocol.Position{Line: 2, Character: 0},
+			ch:       "\n",
+			expected: `{
+  a: {
+    b
+  },
+}
+`,
+		},
+		{
+			name: "new line before a closing bracket",
+			fileContent: `{
+  a: [
+      ],
+}
+`,
+			position: protocol.Position{Line: 2, Character: 6},
+			ch:       "\n",
+			expected: `{
+  a: [
+  ],
+}
+`,
+		},
+		{
+			name: "new line in an invalid document, brackets in strings are ignored",
+			fileContent: `{
+  a: f('{', // [
+`,
+			position: protocol.Position{Line: 2, Character: 0},
+			ch:       "\n",
+			expected: "{\n  a: f('{', // [\n    ",
+		},
+		{
+			name: "new line in a text block",
+			fileContent: `{
+  a: |||
+    text
+`,
+			position: protocol.Position{Line: 3, Character: 0},
+			ch:       "\n",
+			expected: `{
+  a: |||
+    text
+`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			s, fileURI := testServerWithFile(t, nil, tc.fileContent)
+
+			edits, err := s.OnTypeFormatting(context.TODO(), &protocol.DocumentOnTypeFormattingParams{
+				TextDocument: protocol.TextDocumentIdentifier{URI: fileURI},
+				Position:     tc.position,
+				Ch:           tc.ch,
+			})
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, applyTextEdits(t, tc.fileContent, edits))
+		})
+	}
+}
@@ -0,0 +1,67 @@
+package server
+
+import (
+	"context"
+
+	"github.com/grafana/jsonnet-language-server/pkg/ast/processing"
+	"github.com/grafana/jsonnet-language-server/pkg/utils"
+	"github.com/jdbaldry/go-language-server-protocol/lsp/protocol"
+	log "github.com/sirupsen/logrus"
+)
+
+func (s *Server) DocumentHighlight(_ context.Context, params *protocol.DocumentHighlightParams) ([]protocol.DocumentHighlight, error) {
+	doc, err := s.cache.Get(params.TextDocument.URI)
+	if err != nil {
+		return nil, utils.LogErrorf("DocumentHighlight: %s: %w", errorRetrievingDocument, err)
+	}
+
+	// Only highlight if the line hasn't changed since last successful AST parse
+	if doc.AST == nil {
+		return nil, utils.LogErrorf("DocumentHighlight: document was never successfully parsed, can't highlight")
+	}
+	if doc.LinesChangedSinceAST[int(params.Position.Line)] {
+		return nil, utils.LogErrorf("DocumentHighlight: document line %d was changed since last successful parse, can't highlight", params.Position.Line)
+	}
+
+	filename := doc.Item.URI.SpanURI().Filename()
+	processor := processing.NewProcessor(s.cache, s.getVM(filename))
+	converter := s.converter(doc.Item.Text)
+	target, err := findTargetSymbol(processor, doc.AST, converter.ProtocolToAST(params.Position))
+	if err != nil {
+		// Highlights are requested on every cursor move, not finding a symbol is expected
+		log.Debugf("DocumentHighlight: %v", err)
+		return nil, nil
+	}
+
+	// Only search the current document, highlights don't need the importers
+	usages, definitions, err := processor.FindUsages([]string{filename}, target.name, target.definitions)
+	if err != nil {
+		return nil, utils.LogErrorf("DocumentHighlight: error finding usages: %w", err)
+	}
+
+	var highlights []protocol.DocumentHighlight
+	for _, definition := range definitions {
+		if utils.AbsFilename(definition.Filename) != utils.AbsFilename(filename) {
+			continue
+		}
+		if nameRange, ok := target.definitionNameRange(processor, definition); ok {
+			highlights = append(highlights, protocol.DocumentHighlight{
+				Range: converter.RangeASTToProtocol(nameRange),
+				Kind:  protocol.Write,
+			})
+		}
+	}
+	for _, usage := range usages {
+		if utils.AbsFilename(usage.Filename) != utils.AbsFilename(filename) {
+			continue
+		}
+		if nameRange, ok := usageNameRange(usage); ok {
+			highlights = append(highlights, protocol.DocumentHighlight{
+				Range: converter.RangeASTToProtocol(nameRange),
+				Kind:  protocol.Read,
+			})
+		}
+	}
+
+	return highlights, nil
+}
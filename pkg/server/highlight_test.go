@@ -0,0 +1,89 @@
+package server
+
+import (
+	"context"
+	"testing"
+
+	"github.com/jdbaldry/go-language-server-protocol/lsp/protocol"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDocumentHighlight(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		filename string
+		position protocol.Position
+		expected []protocol.DocumentHighlight
+	}{
+		{
+			name:     "local function",
+			filename: "testdata/rename-lib.libsonnet",
+			position: protocol.Position{Line: 3, Character: 9},
+			expected: []protocol.DocumentHighlight{
+				{Range: protocol.Range{Start: protocol.Position{Line: 0, Character: 6}, End: protocol.Position{Line: 0, Character: 12}}, Kind: protocol.Write},
+				{Range: protocol.Range{Start: protocol.Position{Line: 3, Character: 9}, End: protocol.Position{Line: 3, Character: 15}}, Kind: protocol.Read},
+				{Range: protocol.Range{Start: protocol.Position{Line: 4, Character: 18}, End: protocol.Position{Line: 4, Character: 24}}, Kind: protocol.Read},
+			},
+		},
+		{
+			name:     "object local shadowed by a parameter",
+			filename: "testdata/rename-lib.libsonnet",
+			position: protocol.Position{Line: 2, Character: 8},
+			expected: []protocol.DocumentHighlight{
+				{Range: protocol.Range{Start: protocol.Position{Line: 2, Character: 8}, End: protocol.Position{Line: 2, Character: 13}}, Kind: protocol.Write},
+				{Range: protocol.Range{Start: protocol.Position{Line: 3, Character: 16}, End: protocol.Position{Line: 3, Character: 21}}, Kind: protocol.Read},
+			},
+		},
+		{
+			name:     "parameter",
+			filename: "testdata/rename-lib.libsonnet",
+			position: protocol.Position{Line: 4, Character: 9},
+			expected: []protocol.DocumentHighlight{
+				{Range: protocol.Range{Start: protocol.Position{Line: 4, Character: 9}, End: protocol.Position{Line: 4, Character: 14}}, Kind: protocol.Write},
+				{Range: protocol.Range{Start: protocol.Position{Line: 4, Character: 25}, End: protocol.Position{Line: 4, Character: 30}}, Kind: protocol.Read},
+			},
+		},
+		{
+			name:     "self field",
+			filename: "testdata/rename-lib.libsonnet",
+			position: protocol.Position{Line: 4, Character: 40},
+			expected: []protocol.DocumentHighlight{
+				{Range: protocol.Range{Start: protocol.Position{Line: 3, Character: 2}, End: protocol.Position{Line: 3, Character: 7}}, Kind: protocol.Write},
+				{Range: protocol.Range{Start: protocol.Position{Line: 4, Character: 39}, End: protocol.Position{Line: 4, Character: 44}}, Kind: protocol.Read},
+				{Range: protocol.Range{Start: protocol.Position{Line: 6, Character: 23}, End: protocol.Position{Line: 6, Character: 28}}, Kind: protocol.Read},
+			},
+		},
+		{
+			name:     "dollar field",
+			filename: "testdata/dollar-simple.jsonnet",
+			position: protocol.Position{Line: 1, Character: 4},
+			expected: []protocol.DocumentHighlight{
+				{Range: protocol.Range{Start: protocol.Position{Line: 1, Character: 2}, End: protocol.Position{Line: 1, Character: 11}}, Kind: protocol.Write},
+				{Range: protocol.Range{Start: protocol.Position{Line: 7, Character: 12}, End: protocol.Position{Line: 7, Character: 21}}, Kind: protocol.Read},
+				{Range: protocol.Range{Start: protocol.Position{Line: 8, Character: 16}, End: protocol.Position{Line: 8, Character: 25}}, Kind: protocol.Read},
+			},
+		},
+		{
+			name:     "no symbol",
+			filename: "testdata/rename-lib.libsonnet",
+			position: protocol.Position{Line: 0, Character: 1},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			server := NewServer("any", "test version", nil, Configuration{
+				JPaths: []string{"testdata"},
+			})
+			uri := serverOpenTestFile(t, server, tc.filename)
+
+			highlights, err := server.DocumentHighlight(context.Background(), &protocol.DocumentHighlightParams{
+				TextDocumentPositionParams: protocol.TextDocumentPositionParams{
+					TextDocument: protocol.TextDocumentIdentifier{URI: uri},
+					Position:     tc.position,
+				},
+			})
+			require.NoError(t, err)
+			assert.ElementsMatch(t, tc.expected, highlights)
+		})
+	}
+}
@@ -7,7 +7,6 @@ import (
 
 	"github.com/google/go-jsonnet/ast"
 	"github.com/grafana/jsonnet-language-server/pkg/ast/processing"
-	position "github.com/grafana/jsonnet-language-server/pkg/position_conversion"
 	"github.com/grafana/jsonnet-language-server/pkg/utils"
 	"github.com/jdbaldry/go-language-server-protocol/lsp/protocol"
 	log "github.com/sirupsen/logrus"
@@ -25,7 +24,8 @@ func (s *Server) Hover(_ context.Context, params *protocol.HoverParams) (*protoc
 		return nil, nil
 	}
 
-	stack, err := processing.FindNodeByPosition(doc.AST, position.ProtocolToAST(params.Position))
+	converter := s.converter(doc.Item.Text)
+	stack, err := processing.FindNodeByPosition(doc.AST, converter.ProtocolToAST(params.Position))
 	if err != nil {
 		return nil, err
 	}
@@ -39,21 +39,22 @@ func (s *Server) Hover(_ context.Context, params *protocol.HoverParams) (*protoc
 
 	_, isIndex := node.(*ast.Index)
 	_, isVar := node.(*ast.Var)
-	lineIndex := uint32(node.Loc().Begin.Line) - 1
-	startIndex := uint32(node.Loc().Begin.Column) - 1
-	line := strings.Split(doc.Item.Text, "\n")[lineIndex]
+	begin := node.Loc().Begin
+	line := strings.Split(doc.Item.Text, "\n")[begin.Line-1]
+	startIndex := begin.Column - 1
 	if (isIndex || isVar) && strings.HasPrefix(line[startIndex:], "std") {
 		functionNameIndex := startIndex + 4
-		if functionNameIndex < uint32(len(line)) {
+		if functionNameIndex < len(line) {
 			functionName := utils.FirstWord(line[functionNameIndex:])
 			functionName = strings.TrimSpace(functionName)
 
 			for _, function := range s.stdlib {
 				if function.Name == functionName {
 					return &protocol.Hover{
-						Range: protocol.Range{
-							Start: protocol.Position{Line: lineIndex, Character: startIndex},
-							End:   protocol.Position{Line: lineIndex, Character: functionNameIndex + uint32(len(functionName))}},
+						Range: converter.RangeASTToProtocol(ast.LocationRange{
+							Begin: begin,
+							End:   ast.Location{Line: begin.Line, Column: functionNameIndex + len(functionName) + 1},
+						}),
 						Contents: protocol.MarkupContent{
 							Kind:  protocol.Markdown,
 							Value: fmt.Sprintf("`%s`\n\n%s", function.Signature(), function.MarkdownDescription),
@@ -89,7 +90,7 @@ func (s *Server) Hover(_ context.Context, params *protocol.HoverParams) (*protoc
 			contentBuilder.WriteString(fmt.Sprintf("## `%s`\n", header))
 		}
 
-		targetContent, err := s.cache.GetContents(def.TargetURI, def.TargetRange)
+		targetContent, err := s.cache.GetContents(def.TargetURI, s.fileConverter(def.TargetURI).RangeProtocolToAST(def.TargetRange))
 		if err != nil {
 			log.Debugf("Hover: error reading target content: %s", err)
 			return nil, nil
@@ -112,7 +113,7 @@ func (s *Server) Hover(_ context.Context, params *protocol.HoverParams) (*protoc
 		},
 	}
 	if loc := node.Loc(); loc != nil {
-		result.Range = position.RangeASTToProtocol(*loc)
+		result.Range = converter.RangeASTToProtocol(*loc)
 	}
 
 	return result, nil
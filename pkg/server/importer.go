@@ -0,0 +1,53 @@
+package server
+
+import (
+	"github.com/google/go-jsonnet"
+	"github.com/grafana/jsonnet-language-server/pkg/cache"
+	"github.com/grafana/jsonnet-language-server/pkg/utils"
+	"github.com/jdbaldry/go-language-server-protocol/lsp/protocol"
+)
+
+// openDocumentsImporter imports the documents open in the editor with their text from the cache, unsaved changes included.
+// The imports are resolved, and the other files are read, by the wrapped importer
+type openDocumentsImporter struct {
+	importer jsonnet.Importer
+	cache    *cache.Cache
+	// The contents returned for each file, an importer must always return the same ones
+	contents map[string]jsonnet.Contents
+}
+
+func newOpenDocumentsImporter(importer jsonnet.Importer, cache *cache.Cache) *openDocumentsImporter {
+	return &openDocumentsImporter{
+		importer: importer,
+		cache:    cache,
+		contents: make(map[string]jsonnet.Contents),
+	}
+}
+
+func (i *openDocumentsImporter) Import(importedFrom, importedPath string) (jsonnet.Contents, string, error) {
+	contents, foundAt, err := i.importer.Import(importedFrom, importedPath)
+	if err != nil {
+		return contents, foundAt, err
+	}
+	if cached, ok := i.contents[foundAt]; ok {
+		return cached, foundAt, nil
+	}
+	if doc, err := i.cache.Get(protocol.URIFromPath(utils.AbsFilename(foundAt))); err == nil {
+		contents = jsonnet.MakeContents(doc.Item.Text)
+	}
+	i.contents[foundAt] = contents
+	return contents, foundAt, nil
+}
+
+// vmImporter imports files with the importer of a VM, when the importer itself isn't accessible
+type vmImporter struct {
+	vm *jsonnet.VM
+}
+
+func (i vmImporter) Import(importedFrom, importedPath string) (jsonnet.Contents, string, error) {
+	contents, foundAt, err := i.vm.ImportData(importedFrom, importedPath)
+	if err != nil {
+		return jsonnet.Contents{}, "", err
+	}
+	return jsonnet.MakeContents(contents), foundAt, nil
+}
@@ -0,0 +1,313 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/google/go-jsonnet/ast"
+	"github.com/grafana/jsonnet-language-server/pkg/ast/processing"
+	"github.com/grafana/jsonnet-language-server/pkg/cache"
+	position "github.com/grafana/jsonnet-language-server/pkg/position_conversion"
+	"github.com/grafana/jsonnet-language-server/pkg/utils"
+	"github.com/jdbaldry/go-language-server-protocol/lsp/protocol"
+	log "github.com/sirupsen/logrus"
+)
+
+// evalInlayHintsMaxLength is the maximum length, in characters, of an evaluated value shown in a hint
+const evalInlayHintsMaxLength = 40
+
+// InlayHintKind and the types below are part of the 3.17 specification, which the protocol library doesn't support
+type InlayHintKind uint32
+
+const (
+	TypeInlayHint      InlayHintKind = 1
+	ParameterInlayHint InlayHintKind = 2
+)
+
+type InlayHintParams struct {
+	TextDocument protocol.TextDocumentIdentifier `json:"textDocument"`
+	Range        protocol.Range                  `json:"range"`
+}
+
+type InlayHint struct {
+	Position     protocol.Position `json:"position"`
+	Label        string            `json:"label"`
+	Kind         InlayHintKind     `json:"kind,omitempty"`
+	Tooltip      string            `json:"tooltip,omitempty"`
+	PaddingLeft  bool              `json:"paddingLeft,omitempty"`
+	PaddingRight bool              `json:"paddingRight,omitempty"`
+}
+
+func (s *Server) InlayHint(_ context.Context, params *InlayHintParams) ([]InlayHint, error) {
+	doc, err := s.cache.Get(params.TextDocument.URI)
+	if err != nil {
+		return nil, utils.LogErrorf("InlayHint: %s: %w", errorRetrievingDocument, err)
+	}
+
+	// Hints are positioned relatively to the text, they can't be computed from an outdated AST
+	if doc.AST == nil || len(doc.LinesChangedSinceAST) > 0 {
+		log.Debugf("InlayHint: document was changed since last successful parse")
+		return nil, nil
+	}
+
+	filename := doc.Item.URI.SpanURI().Filename()
+	processor := processing.NewProcessor(s.cache, s.getVM(filename))
+
+	hints := s.parameterInlayHints(s.converter(doc.Item.Text), processor, doc.AST, params.Range)
+	if s.configuration.EnableEvalInlayHints {
+		hints = append(hints, s.evalInlayHints(doc, params.Range)...)
+	}
+
+	sort.SliceStable(hints, func(i, j int) bool {
+		if hints[i].Position.Line != hints[j].Position.Line {
+			return hints[i].Position.Line < hints[j].Position.Line
+		}
+		return hints[i].Position.Character < hints[j].Position.Character
+	})
+	return hints, nil
+}
+
+// parameterInlayHints shows the name of the parameters before the positional arguments of function calls
+func (s *Server) parameterInlayHints(converter *position.Converter, processor *processing.Processor, root ast.Node, hintRange protocol.Range) []InlayHint {
+	var hints []InlayHint
+	for _, apply := range findApplies(root, hintRange) {
+		stack, err := processing.FindParentsByNode(root, apply)
+		if err != nil {
+			log.Debugf("InlayHint: could not find the scope of a function call: %v", err)
+			continue
+		}
+		_, paramNames := s.resolveSignature(processor, stack, apply.Target)
+
+		for i, arg := range apply.Arguments.Positional {
+			if i >= len(paramNames) {
+				break
+			}
+			// Naming the argument like the parameter is already explicit enough
+			if varNode, ok := arg.Expr.(*ast.Var); ok && string(varNode.Id) == paramNames[i] {
+				continue
+			}
+			hints = append(hints, InlayHint{
+				Position:     converter.ASTToProtocol(arg.Expr.Loc().Begin),
+				Label:        paramNames[i] + ":",
+				Kind:         ParameterInlayHint,
+				PaddingRight: true,
+			})
+		}
+	}
+	return hints
+}
+
+// findApplies returns the function calls with arguments starting in the given range
+func findApplies(root ast.Node, hintRange protocol.Range) []*ast.Apply {
+	var applies []*ast.Apply
+	processing.Walk(root, func(node ast.Node) bool {
+		if apply, ok := node.(*ast.Apply); ok && len(apply.Arguments.Positional) > 0 && inLineRange(apply.LocRange, hintRange) {
+			applies = append(applies, apply)
+		}
+		return true
+	})
+	return applies
+}
+
+// inLineRange returns true if the AST range overlaps the lines of the protocol range
+func inLineRange(locRange ast.LocationRange, hintRange protocol.Range) bool {
+	if !locRange.Begin.IsSet() {
+		return false
+	}
+	return locRange.End.Line-1 >= int(hintRange.Start.Line) && locRange.Begin.Line-1 <= int(hintRange.End.Line)
+}
+
+// evaluatedLocals is the evaluation of the top-level locals of a document, for the inlay hints
+type evaluatedLocals struct {
+	// Values of the locals, by end of their body, and the version of the document they were evaluated for
+	values  map[ast.Location]string
+	version int32
+	// Whether the locals are being evaluated, and the last version the hints were requested for
+	running   bool
+	requested int32
+}
+
+// evalInlayHints shows the evaluated value of the top-level locals of a document, when they are simple values.
+// The locals are evaluated in the background, once per version of the document, the hints are shown once they're ready
+func (s *Server) evalInlayHints(doc *cache.Document, hintRange protocol.Range) []InlayHint {
+	values, ok := s.evaluatedLocals(doc)
+	if !ok {
+		return nil
+	}
+
+	converter := s.converter(doc.Item.Text)
+	binds, _ := evaluableLocals(doc.AST)
+	var hints []InlayHint
+	for _, bind := range binds {
+		value, ok := values[bind.Body.Loc().End]
+		if !ok || !inLineRange(processing.LocalBindToRange(bind).FullRange, hintRange) {
+			continue
+		}
+		hints = append(hints, InlayHint{
+			Position:    converter.ASTToProtocol(bind.Body.Loc().End),
+			Label:       "= " + truncateValue(value),
+			Kind:        TypeInlayHint,
+			Tooltip:     strings.TrimSpace(value),
+			PaddingLeft: true,
+		})
+	}
+	return hints
+}
+
+// evaluatedLocals returns the values of the top-level locals of a version of a document, if they were evaluated.
+// Otherwise, they're evaluated in the background and the client is asked to request the hints again once they're ready
+func (s *Server) evaluatedLocals(doc *cache.Document) (map[ast.Location]string, bool) {
+	s.evalHintsMutex.Lock()
+	defer s.evalHintsMutex.Unlock()
+
+	uri := doc.Item.URI
+	state, ok := s.evalHints[uri]
+	if !ok {
+		state = &evaluatedLocals{}
+		s.evalHints[uri] = state
+	}
+	if state.values != nil && state.version == doc.Item.Version {
+		return state.values, true
+	}
+	state.requested = doc.Item.Version
+	if !state.running {
+		state.running = true
+		go s.evaluateLocals(uri, state)
+	}
+	return nil, false
+}
+
+// evaluateLocals evaluates the top-level locals of the last version of a document, until the version the hints
+// were last requested for is evaluated. A single evaluation runs for each document, until the evaluation timeout
+func (s *Server) evaluateLocals(uri protocol.DocumentURI, state *evaluatedLocals) {
+	for {
+		doc, err := s.cache.Get(uri)
+		if err != nil {
+			// The document was closed
+			s.evalHintsMutex.Lock()
+			state.running = false
+			if s.evalHints[uri] == state {
+				delete(s.evalHints, uri)
+			}
+			s.evalHintsMutex.Unlock()
+			return
+		}
+
+		values := map[ast.Location]string{}
+		if doc.AST != nil && len(doc.LinesChangedSinceAST) == 0 {
+			values = s.evaluateLocalValues(doc.Item.URI.SpanURI().Filename(), doc.Item.Text, doc.AST)
+		}
+
+		s.evalHintsMutex.Lock()
+		state.values, state.version = values, doc.Item.Version
+		if state.requested > doc.Item.Version {
+			s.evalHintsMutex.Unlock()
+			continue
+		}
+		state.running = false
+		s.evalHintsMutex.Unlock()
+
+		s.refreshInlayHints()
+		return
+	}
+}
+
+// forgetEvaluatedLocals drops the values of the top-level locals of a closed document. A running evaluation
+// drops them once it's done, so that a reopened document isn't evaluated twice at the same time
+func (s *Server) forgetEvaluatedLocals(uri protocol.DocumentURI) {
+	s.evalHintsMutex.Lock()
+	defer s.evalHintsMutex.Unlock()
+
+	if state, ok := s.evalHints[uri]; ok {
+		state.values = nil
+		if !state.running {
+			delete(s.evalHints, uri)
+		}
+	}
+}
+
+// evaluableLocals returns the top-level locals of a document whose values can be shown, and the body following them
+func evaluableLocals(root ast.Node) ([]ast.LocalBind, ast.Node) {
+	var binds []ast.LocalBind
+	var body ast.Node
+	for local, ok := root.(*ast.Local); ok; local, ok = body.(*ast.Local) {
+		for _, bind := range local.Binds {
+			switch bind.Body.(type) {
+			case *ast.Function, *ast.Import, *ast.ImportStr, *ast.ImportBin:
+				// Functions can't be manifested and imports are usually too big to be shown
+				continue
+			}
+			binds = append(binds, bind)
+		}
+		body = local.Body
+	}
+	return binds, body
+}
+
+// evaluateLocalValues evaluates the top-level locals of a document, by end of their body. They're evaluated at once, in an
+// array following the text defining them, until the evaluation timeout
+func (s *Server) evaluateLocalValues(filename, text string, root ast.Node) map[ast.Location]string {
+	values := map[ast.Location]string{}
+	binds, body := evaluableLocals(root)
+	if len(binds) == 0 || !body.Loc().Begin.IsSet() {
+		return values
+	}
+	// The text before the body of the last local defines all of them
+	prefix := textBetween(text, ast.Location{Line: 1, Column: 1}, body.Loc().Begin)
+	var names []string
+	for _, bind := range binds {
+		names = append(names, string(bind.Variable))
+	}
+	snippet := prefix + "[" + strings.Join(names, ", ") + "]"
+
+	var result string
+	var err error
+	if abortErr := s.runVM(context.Background(), "", func() {
+		result, err = s.getVM(filename).EvaluateAnonymousSnippet(filename, snippet)
+	}); abortErr != nil {
+		err = abortErr
+	}
+	if err != nil {
+		log.Debugf("InlayHint: could not evaluate the locals of %s: %v", filename, err)
+		return values
+	}
+
+	var evaluated []json.RawMessage
+	if err := json.Unmarshal([]byte(result), &evaluated); err != nil || len(evaluated) != len(binds) {
+		log.Debugf("InlayHint: unexpected values of the locals of %s: %s", filename, result)
+		return values
+	}
+	for i, bind := range binds {
+		values[bind.Body.Loc().End] = string(evaluated[i])
+	}
+	return values
+}
+
+// refreshInlayHints asks the client to request the inlay hints again, once the values of the locals are evaluated
+func (s *Server) refreshInlayHints() {
+	refresher, ok := s.client.(inlayHintRefresher)
+	if !s.inlayHintRefreshSupport || !ok {
+		return
+	}
+	if err := refresher.InlayHintRefresh(context.Background()); err != nil {
+		log.Debugf("InlayHintRefresh: %v", err)
+	}
+}
+
+// truncateValue returns the JSON value on a single line, truncated if it's too long
+func truncateValue(value string) string {
+	var compact bytes.Buffer
+	if err := json.Compact(&compact, []byte(value)); err == nil {
+		value = compact.String()
+	} else {
+		value = strings.TrimSpace(value)
+	}
+	if utf8.RuneCountInString(value) > evalInlayHintsMaxLength {
+		value = string([]rune(value)[:evalInlayHintsMaxLength-3]) + "..."
+	}
+	return value
+}
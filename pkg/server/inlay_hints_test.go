@@ -0,0 +1,205 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/grafana/jsonnet-language-server/pkg/stdlib"
+	"github.com/jdbaldry/go-language-server-protocol/jsonrpc2"
+	"github.com/jdbaldry/go-language-server-protocol/lsp/protocol"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func parameterHint(line, character uint32, label string) InlayHint {
+	return InlayHint{
+		Position:     protocol.Position{Line: line, Character: character},
+		Label:        label,
+		Kind:         ParameterInlayHint,
+		PaddingRight: true,
+	}
+}
+
+func evalHint(line, character uint32, value string) InlayHint {
+	return InlayHint{
+		Position:    protocol.Position{Line: line, Character: character},
+		Label:       "= " + value,
+		Kind:        TypeInlayHint,
+		Tooltip:     value,
+		PaddingLeft: true,
+	}
+}
+
+func TestInlayHint(t *testing.T) {
+	testCases := []struct {
+		name       string
+		hintRange  protocol.Range
+		enableEval bool
+		expected   []InlayHint
+	}{
+		{
+			name:      "parameter names",
+			hintRange: protocol.Range{Start: protocol.Position{Line: 0}, End: protocol.Position{Line: 10}},
+			expected: []InlayHint{
+				parameterHint(2, 19, "a:"),
+				parameterHint(2, 23, "b:"),
+				// The first argument of `add(a, 2)` is named like the parameter
+				parameterHint(6, 14, "b:"),
+				parameterHint(7, 22, "name:"),
+				parameterHint(7, 31, "greeting:"),
+				parameterHint(8, 21, "x:"),
+			},
+		},
+		{
+			name:      "only in range",
+			hintRange: protocol.Range{Start: protocol.Position{Line: 7}, End: protocol.Position{Line: 7}},
+			expected: []InlayHint{
+				parameterHint(7, 22, "name:"),
+				parameterHint(7, 31, "greeting:"),
+			},
+		},
+		{
+			name:       "evaluated locals",
+			hintRange:  protocol.Range{Start: protocol.Position{Line: 0}, End: protocol.Position{Line: 5}},
+			enableEval: true,
+			expected: []InlayHint{
+				parameterHint(2, 19, "a:"),
+				parameterHint(2, 23, "b:"),
+				evalHint(2, 25, "42"),
+				evalHint(3, 11, "1"),
+				evalHint(4, 22, `"xy"`),
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			server := testServer(t, []stdlib.Function{{Name: "length", Params: []string{"x"}}})
+			server.configuration.JPaths = []string{"testdata"}
+			server.configuration.EnableEvalInlayHints = tc.enableEval
+			uri := serverOpenTestFile(t, server, "testdata/inlay-hints.jsonnet")
+
+			inlayHint := func() []InlayHint {
+				hints, err := server.InlayHint(context.Background(), &InlayHintParams{
+					TextDocument: protocol.TextDocumentIdentifier{URI: uri},
+					Range:        tc.hintRange,
+				})
+				require.NoError(t, err)
+				return hints
+			}
+			if tc.enableEval {
+				// The locals are evaluated in the background, the hints are shown once they're ready
+				require.Eventually(t, func() bool { return len(inlayHint()) == len(tc.expected) }, 10*time.Second, 10*time.Millisecond)
+			}
+			assert.Equal(t, tc.expected, inlayHint())
+		})
+	}
+}
+
+func TestInlayHintEvalTimeout(t *testing.T) {
+	const slowContent = "local slow = std.foldl(function(acc, i) acc + i, std.range(1, 1000000), 0);\nslow\n"
+	server, uri := testServerWithFile(t, nil, slowContent)
+	server.configuration.EnableEvalInlayHints = true
+	withEvalTimeout(t, server, 100*time.Millisecond)
+	// The VM of the aborted evaluation keeps running, it doesn't prevent the next evaluation
+	server.maxAbandonedEvaluations = 2
+	inlayHint := func() []InlayHint {
+		hints, err := server.InlayHint(context.Background(), &InlayHintParams{
+			TextDocument: protocol.TextDocumentIdentifier{URI: uri},
+			Range:        protocol.Range{End: protocol.Position{Line: 2}},
+		})
+		require.NoError(t, err)
+		var evalHints []InlayHint
+		for _, hint := range hints {
+			if hint.Kind == TypeInlayHint {
+				evalHints = append(evalHints, hint)
+			}
+		}
+		return evalHints
+	}
+	evaluated := func() bool {
+		server.evalHintsMutex.Lock()
+		defer server.evalHintsMutex.Unlock()
+		state, ok := server.evalHints[uri]
+		return ok && !state.running && state.values != nil
+	}
+
+	// The evaluation of the locals is aborted, no value is shown. The parameter hints are still shown
+	assert.Empty(t, inlayHint())
+	require.Eventually(t, evaluated, time.Second, 5*time.Millisecond)
+	assert.Empty(t, inlayHint())
+
+	// The next version is evaluated
+	require.NoError(t, server.DidChange(context.Background(), &protocol.DidChangeTextDocumentParams{
+		TextDocument:   protocol.VersionedTextDocumentIdentifier{Version: 2, TextDocumentIdentifier: protocol.TextDocumentIdentifier{URI: uri}},
+		ContentChanges: []protocol.TextDocumentContentChangeEvent{{Text: "local fast = 1 + 1;\nfast\n"}},
+	}))
+	require.Eventually(t, func() bool { return len(inlayHint()) == 1 }, 10*time.Second, 10*time.Millisecond)
+	assert.Equal(t, []InlayHint{evalHint(0, 18, "2")}, inlayHint())
+}
+
+func TestTruncateValue(t *testing.T) {
+	for _, tc := range []struct {
+		name, value, expected string
+	}{
+		{name: "number", value: "42\n", expected: "42"},
+		{name: "compacted object", value: "{\n   \"a\": [\n      1,\n      2\n   ]\n}\n", expected: `{"a":[1,2]}`},
+		{name: "spaces in strings are kept", value: "\"a  b\"\n", expected: `"a  b"`},
+		{name: "truncated", value: `"` + strings.Repeat("a", 50) + `"`, expected: `"` + strings.Repeat("a", 36) + "..."},
+		{name: "truncated by character", value: `"` + strings.Repeat("é", 50) + `"`, expected: `"` + strings.Repeat("é", 36) + "..."},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			truncated := truncateValue(tc.value)
+			assert.Equal(t, tc.expected, truncated)
+			assert.True(t, utf8.ValidString(truncated))
+		})
+	}
+}
+
+func TestInlayHintNonstandardRequest(t *testing.T) {
+	server := testServer(t, nil)
+	server.configuration.JPaths = []string{"testdata"}
+	uri := serverOpenTestFile(t, server, "testdata/inlay-hints.jsonnet")
+
+	// Params are received as generic JSON values
+	var params interface{}
+	require.NoError(t, json.Unmarshal([]byte(`{"textDocument": {"uri": "`+string(uri)+`"}, "range": {"start": {"line": 7, "character": 0}, "end": {"line": 7, "character": 0}}}`), &params))
+
+	result, err := server.NonstandardRequest(context.Background(), "textDocument/inlayHint", params)
+	require.NoError(t, err)
+	assert.Equal(t, []InlayHint{parameterHint(7, 22, "name:"), parameterHint(7, 31, "greeting:")}, result)
+
+	_, err = server.NonstandardRequest(context.Background(), "unknown/method", params)
+	assert.ErrorIs(t, err, jsonrpc2.ErrMethodNotFound)
+}
+
+func TestHandlerInitializeCapabilities(t *testing.T) {
+	server := testServer(t, nil)
+	handler := NewHandler(server, jsonrpc2.MethodNotFound)
+
+	call, err := jsonrpc2.NewCall(jsonrpc2.NewIntID(1), "initialize", &protocol.ParamInitialize{})
+	require.NoError(t, err)
+
+	var result interface{}
+	err = handler(context.Background(), func(_ context.Context, r interface{}, err error) error {
+		require.NoError(t, err)
+		result = r
+		return nil
+	}, call)
+	require.NoError(t, err)
+
+	data, err := json.Marshal(result)
+	require.NoError(t, err)
+	var initializeResult struct {
+		Capabilities map[string]interface{} `json:"capabilities"`
+		ServerInfo   map[string]interface{} `json:"serverInfo"`
+	}
+	require.NoError(t, json.Unmarshal(data, &initializeResult))
+	assert.Equal(t, true, initializeResult.Capabilities["inlayHintProvider"])
+	assert.Equal(t, true, initializeResult.Capabilities["hoverProvider"])
+	assert.Equal(t, "jsonnet-language-server", initializeResult.ServerInfo["name"])
+}
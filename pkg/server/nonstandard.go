@@ -0,0 +1,233 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	position "github.com/grafana/jsonnet-language-server/pkg/position_conversion"
+	"github.com/jdbaldry/go-language-server-protocol/jsonrpc2"
+	"github.com/jdbaldry/go-language-server-protocol/lsp/protocol"
+)
+
+// NonstandardRequest handles the requests that are unknown to the protocol library, usually because they were added to
+// the specification after it was generated
+func (s *Server) NonstandardRequest(ctx context.Context, method string, params interface{}) (interface{}, error) {
+	switch method {
+	case "textDocument/inlayHint":
+		var inlayHintParams InlayHintParams
+		if err := unmarshalParams(params, &inlayHintParams); err != nil {
+			return nil, err
+		}
+		return s.InlayHint(ctx, &inlayHintParams)
+	}
+	return nil, notImplemented(method)
+}
+
+// nonstandardCapabilities returns the server capabilities that are unknown to the protocol library.
+// They are added to the result of the initialize request by the handler
+func (s *Server) nonstandardCapabilities() map[string]interface{} {
+	capabilities := map[string]interface{}{
+		"inlayHintProvider": true,
+		"positionEncoding":  s.positionEncoding,
+	}
+	if s.pullDiagnosticsSupport {
+		capabilities["diagnosticProvider"] = map[string]interface{}{
+			"interFileDependencies": true,
+			"workspaceDiagnostics":  true,
+		}
+	}
+	return capabilities
+}
+
+// NewHandler returns the handler of the language server's requests. It wraps the protocol library's handler
+// to support the parts of the specification that the library doesn't know
+func NewHandler(s *Server, handler jsonrpc2.Handler) jsonrpc2.Handler {
+	serverHandler := protocol.ServerHandler(s, handler)
+	return func(ctx context.Context, reply jsonrpc2.Replier, req jsonrpc2.Request) error {
+		switch req.Method() {
+		case "initialize":
+			// The capabilities are set by Initialize, along with the others, before the server starts working
+			var params initializeParams
+			if err := json.Unmarshal(req.Params(), &params); err == nil {
+				ctx = context.WithValue(ctx, initializeParamsKey{}, &params)
+			}
+			return serverHandler(ctx, func(ctx context.Context, result interface{}, err error) error {
+				if err != nil {
+					return reply(ctx, result, err)
+				}
+				extended, err := addCapabilities(result, s.nonstandardCapabilities())
+				if err != nil {
+					return reply(ctx, nil, err)
+				}
+				return reply(ctx, extended, nil)
+			}, req)
+		case "textDocument/diagnostic":
+			// The protocol library expects a string as the parameters
+			var params protocol.DocumentDiagnosticParams
+			if err := json.Unmarshal(req.Params(), &params); err != nil {
+				return reply(ctx, nil, fmt.Errorf("%w: %v", jsonrpc2.ErrParse, err))
+			}
+			report, err := s.DocumentDiagnostic(ctx, &params)
+			return reply(ctx, report, err)
+		case "textDocument/codeAction":
+			// The refactorings creating files can't be expressed with the protocol library's code actions
+			var params protocol.CodeActionParams
+			if err := json.Unmarshal(req.Params(), &params); err != nil {
+				return reply(ctx, nil, fmt.Errorf("%w: %v", jsonrpc2.ErrParse, err))
+			}
+			return serverHandler(ctx, func(ctx context.Context, result interface{}, err error) error {
+				if err != nil {
+					return reply(ctx, result, err)
+				}
+				actions, err := addFileCodeActions(result, s.fileCodeActions(&params))
+				if err != nil {
+					return reply(ctx, nil, err)
+				}
+				return reply(ctx, actions, nil)
+			}, req)
+		case "textDocument/codeLens":
+			return serverHandler(ctx, func(ctx context.Context, result interface{}, err error) error {
+				if err != nil {
+					return reply(ctx, result, err)
+				}
+				unresolved, err := removeEmptyCommands(result)
+				if err != nil {
+					return reply(ctx, nil, err)
+				}
+				return reply(ctx, unresolved, nil)
+			}, req)
+		}
+		return serverHandler(ctx, reply, req)
+	}
+}
+
+// initializeParams are the parameters of the initialize request that are unknown to the protocol library
+type initializeParams struct {
+	Capabilities struct {
+		General struct {
+			PositionEncodings []string `json:"positionEncodings"`
+		} `json:"general"`
+		TextDocument struct {
+			Diagnostic *struct{} `json:"diagnostic"`
+		} `json:"textDocument"`
+		Workspace struct {
+			Diagnostics struct {
+				RefreshSupport bool `json:"refreshSupport"`
+			} `json:"diagnostics"`
+			InlayHint struct {
+				RefreshSupport bool `json:"refreshSupport"`
+			} `json:"inlayHint"`
+		} `json:"workspace"`
+	} `json:"capabilities"`
+}
+
+// initializeParamsKey is the context key of the initializeParams, passed by the handler to Initialize
+type initializeParamsKey struct{}
+
+// setNonstandardCapabilities sets the client capabilities that are unknown to the protocol library, from the parameters
+// passed by the handler, if any
+func (s *Server) setNonstandardCapabilities(ctx context.Context) {
+	params, ok := ctx.Value(initializeParamsKey{}).(*initializeParams)
+	if !ok {
+		return
+	}
+	s.positionEncoding = position.NegotiateEncoding(params.Capabilities.General.PositionEncodings)
+	// The diagnostics computed in the background can only be pulled if the client can be asked to pull them.
+	// They're pushed otherwise
+	s.diagnosticsRefreshSupport = params.Capabilities.Workspace.Diagnostics.RefreshSupport
+	s.pullDiagnosticsSupport = params.Capabilities.TextDocument.Diagnostic != nil && s.diagnosticsRefreshSupport
+	s.inlayHintRefreshSupport = params.Capabilities.Workspace.InlayHint.RefreshSupport
+}
+
+// NewClient returns the client of the language server. It wraps the protocol library's dispatcher
+// to send the requests that the library doesn't know
+func NewClient(conn jsonrpc2.Conn) protocol.ClientCloser {
+	return &client{ClientCloser: protocol.ClientDispatcher(conn), conn: conn}
+}
+
+type client struct {
+	protocol.ClientCloser
+	conn jsonrpc2.Conn
+}
+
+// codeLensRefresher is implemented by the clients that can send the workspace/codeLens/refresh request
+type codeLensRefresher interface {
+	CodeLensRefresh(ctx context.Context) error
+}
+
+func (c *client) CodeLensRefresh(ctx context.Context) error {
+	_, err := c.conn.Call(ctx, "workspace/codeLens/refresh", nil, nil)
+	return err
+}
+
+// inlayHintRefresher is implemented by the clients that can send the workspace/inlayHint/refresh request
+type inlayHintRefresher interface {
+	InlayHintRefresh(ctx context.Context) error
+}
+
+func (c *client) InlayHintRefresh(ctx context.Context) error {
+	_, err := c.conn.Call(ctx, "workspace/inlayHint/refresh", nil, nil)
+	return err
+}
+
+func (c *client) DiagnosticRefresh(ctx context.Context) error {
+	_, err := c.conn.Call(ctx, "workspace/diagnostic/refresh", nil, nil)
+	return err
+}
+
+// addCapabilities adds capabilities to a marshalled initialize result
+func addCapabilities(result interface{}, capabilities map[string]interface{}) (map[string]interface{}, error) {
+	var extended map[string]interface{}
+	if err := unmarshalParams(result, &extended); err != nil {
+		return nil, err
+	}
+	serverCapabilities, ok := extended["capabilities"].(map[string]interface{})
+	if !ok {
+		serverCapabilities = map[string]interface{}{}
+		extended["capabilities"] = serverCapabilities
+	}
+	for key, value := range capabilities {
+		serverCapabilities[key] = value
+	}
+	return extended, nil
+}
+
+// addFileCodeActions adds the code actions creating files to marshalled code actions
+func addFileCodeActions(result interface{}, fileActions []fileCodeAction) ([]interface{}, error) {
+	actions := []interface{}{}
+	if err := unmarshalParams(result, &actions); err != nil {
+		return nil, err
+	}
+	for _, action := range fileActions {
+		actions = append(actions, action)
+	}
+	return actions, nil
+}
+
+// removeEmptyCommands removes the empty commands from marshalled code lenses.
+// The protocol library always marshals them, but clients only resolve the lenses without a command
+func removeEmptyCommands(result interface{}) ([]map[string]interface{}, error) {
+	var lenses []map[string]interface{}
+	if err := unmarshalParams(result, &lenses); err != nil {
+		return nil, err
+	}
+	for _, lens := range lenses {
+		if command, ok := lens["command"].(map[string]interface{}); ok && command["title"] == "" && command["command"] == "" {
+			delete(lens, "command")
+		}
+	}
+	return lenses, nil
+}
+
+// unmarshalParams converts generic JSON values, as received by NonstandardRequest, to the given type
+func unmarshalParams(params interface{}, v interface{}) error {
+	data, err := json.Marshal(params)
+	if err != nil {
+		return fmt.Errorf("%w: %v", jsonrpc2.ErrParse, err)
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("%w: %v", jsonrpc2.ErrInvalidParams, err)
+	}
+	return nil
+}
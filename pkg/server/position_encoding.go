@@ -0,0 +1,24 @@
+package server
+
+import (
+	position "github.com/grafana/jsonnet-language-server/pkg/position_conversion"
+	"github.com/jdbaldry/go-language-server-protocol/lsp/protocol"
+)
+
+// converter returns the converter of the positions of a text, in the negotiated encoding
+func (s *Server) converter(text string) *position.Converter {
+	return position.NewConverter(text, s.positionEncoding)
+}
+
+// fileConverter returns the converter of the positions of a document, open or on disk.
+// If the file can't be read, the characters are counted in bytes
+func (s *Server) fileConverter(uri protocol.DocumentURI) *position.Converter {
+	if s.positionEncoding == position.UTF8 {
+		return s.converter("")
+	}
+	text, err := s.cache.GetText(uri)
+	if err != nil {
+		return s.converter("")
+	}
+	return s.converter(text)
+}
@@ -0,0 +1,69 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	position "github.com/grafana/jsonnet-language-server/pkg/position_conversion"
+	"github.com/jdbaldry/go-language-server-protocol/jsonrpc2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandlerPositionEncoding(t *testing.T) {
+	for _, tc := range []struct {
+		name            string
+		clientEncodings []string
+		expected        position.Encoding
+	}{
+		{
+			name:     "no encodings",
+			expected: position.UTF16,
+		},
+		{
+			name:            "UTF-8 is preferred",
+			clientEncodings: []string{"utf-16", "utf-8"},
+			expected:        position.UTF8,
+		},
+		{
+			name:            "UTF-32",
+			clientEncodings: []string{"utf-32", "utf-16"},
+			expected:        position.UTF32,
+		},
+		{
+			name:            "unknown encodings",
+			clientEncodings: []string{"utf-7"},
+			expected:        position.UTF16,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			server := testServer(t, nil)
+			handler := NewHandler(server, jsonrpc2.MethodNotFound)
+
+			params := map[string]interface{}{
+				"capabilities": map[string]interface{}{
+					"general": map[string]interface{}{"positionEncodings": tc.clientEncodings},
+				},
+			}
+			call, err := jsonrpc2.NewCall(jsonrpc2.NewIntID(1), "initialize", params)
+			require.NoError(t, err)
+
+			var result interface{}
+			require.NoError(t, handler(context.Background(), func(_ context.Context, r interface{}, err error) error {
+				require.NoError(t, err)
+				result = r
+				return nil
+			}, call))
+
+			data, err := json.Marshal(result)
+			require.NoError(t, err)
+			var initializeResult struct {
+				Capabilities map[string]interface{} `json:"capabilities"`
+			}
+			require.NoError(t, json.Unmarshal(data, &initializeResult))
+			assert.Equal(t, string(tc.expected), initializeResult.Capabilities["positionEncoding"])
+			assert.Equal(t, tc.expected, server.positionEncoding)
+		})
+	}
+}
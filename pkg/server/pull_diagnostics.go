@@ -0,0 +1,248 @@
+package server
+
+import (
+	"context"
+	"io/fs"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/go-jsonnet"
+	"github.com/grafana/jsonnet-language-server/pkg/cache"
+	"github.com/jdbaldry/go-language-server-protocol/lsp/protocol"
+	log "github.com/sirupsen/logrus"
+)
+
+// diagnosticsRefreshDelay is the time to wait for other diagnostics before asking the client to pull them again
+const diagnosticsRefreshDelay = 100 * time.Millisecond
+
+// Kinds of the diagnostic reports
+const (
+	fullDiagnosticReport      = "full"
+	unchangedDiagnosticReport = "unchanged"
+)
+
+// diagnosticsResult is the last diagnostics computed for a document, when the client pulls them
+type diagnosticsResult struct {
+	resultID string
+	diags    []protocol.Diagnostic
+}
+
+// workspaceFullDocumentDiagnosticReport is protocol.WorkspaceFullDocumentDiagnosticReport, with a version that can be null
+type workspaceFullDocumentDiagnosticReport struct {
+	URI     protocol.DocumentURI `json:"uri"`
+	Version *int32               `json:"version"`
+	protocol.FullDocumentDiagnosticReport
+}
+
+// workspaceUnchangedDocumentDiagnosticReport is protocol.WorkspaceUnchangedDocumentDiagnosticReport, which the protocol library lacks
+type workspaceUnchangedDocumentDiagnosticReport struct {
+	URI     protocol.DocumentURI `json:"uri"`
+	Version *int32               `json:"version"`
+	protocol.UnchangedDocumentDiagnosticReport
+}
+
+// diagnosticRefresher is implemented by the clients that can send the workspace/diagnostic/refresh request
+type diagnosticRefresher interface {
+	DiagnosticRefresh(ctx context.Context) error
+}
+
+// DocumentDiagnostic returns the diagnostics of a document. The diagnostics are computed in the background when the document
+// changes: the pending ones are waited for, until the request is canceled
+func (s *Server) DocumentDiagnostic(ctx context.Context, params *protocol.DocumentDiagnosticParams) (protocol.DocumentDiagnosticReport, error) {
+	uri := params.TextDocument.URI
+	scheduled := false
+	for {
+		if pending := s.diagnostics.pending(uri); pending != nil {
+			select {
+			case <-pending:
+				continue
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}
+		// The documents that were never diagnosed are diagnosed once
+		if scheduled || s.hasDiagnosticsResult(uri) {
+			break
+		}
+		s.diagnostics.scheduleOnce(uri)
+		scheduled = true
+	}
+
+	result, ok := s.diagnosticsResult(uri)
+	if !ok {
+		return protocol.RelatedFullDocumentDiagnosticReport{
+			FullDocumentDiagnosticReport: protocol.FullDocumentDiagnosticReport{Kind: fullDiagnosticReport, Items: []protocol.Diagnostic{}},
+		}, nil
+	}
+	if result.resultID == params.PreviousResultID {
+		return protocol.RelatedUnchangedDocumentDiagnosticReport{
+			UnchangedDocumentDiagnosticReport: protocol.UnchangedDocumentDiagnosticReport{Kind: unchangedDiagnosticReport, ResultID: result.resultID},
+		}, nil
+	}
+	return protocol.RelatedFullDocumentDiagnosticReport{
+		FullDocumentDiagnosticReport: protocol.FullDocumentDiagnosticReport{Kind: fullDiagnosticReport, ResultID: result.resultID, Items: result.diags},
+	}, nil
+}
+
+// DiagnosticWorkspace returns the diagnostics of the entrypoints of the workspace that aren't open. The open documents are
+// reported by DocumentDiagnostic. The entrypoints without diagnostics yet are diagnosed in the background
+func (s *Server) DiagnosticWorkspace(_ context.Context, params *protocol.WorkspaceDiagnosticParams) (*protocol.WorkspaceDiagnosticReport, error) {
+	previousResultIDs := map[protocol.DocumentURI]string{}
+	for _, previous := range params.PreviousResultIds {
+		previousResultIDs[previous.URI] = previous.Value
+	}
+
+	report := &protocol.WorkspaceDiagnosticReport{Items: []protocol.WorkspaceDocumentDiagnosticReport{}}
+	for _, filename := range s.workspaceEntrypoints() {
+		uri := protocol.URIFromPath(filename)
+		if _, err := s.cache.Get(uri); err == nil {
+			continue
+		}
+		result, ok := s.diagnosticsResult(uri)
+		if !ok {
+			s.diagnostics.scheduleOnce(uri)
+			continue
+		}
+		if previousResultIDs[uri] == result.resultID {
+			report.Items = append(report.Items, workspaceUnchangedDocumentDiagnosticReport{
+				URI:                               uri,
+				UnchangedDocumentDiagnosticReport: protocol.UnchangedDocumentDiagnosticReport{Kind: unchangedDiagnosticReport, ResultID: result.resultID},
+			})
+			continue
+		}
+		report.Items = append(report.Items, workspaceFullDocumentDiagnosticReport{
+			URI:                          uri,
+			FullDocumentDiagnosticReport: protocol.FullDocumentDiagnosticReport{Kind: fullDiagnosticReport, ResultID: result.resultID, Items: result.diags},
+		})
+	}
+	return report, nil
+}
+
+// DiagnosticRefresh asks the client to pull the diagnostics again. The protocol library lists it as a server request
+func (s *Server) DiagnosticRefresh(ctx context.Context) error {
+	refresher, ok := s.client.(diagnosticRefresher)
+	if !s.diagnosticsRefreshSupport || !ok {
+		return nil
+	}
+	return refresher.DiagnosticRefresh(ctx)
+}
+
+func (s *Server) diagnosticsResult(uri protocol.DocumentURI) (diagnosticsResult, bool) {
+	s.diagResultsMutex.Lock()
+	defer s.diagResultsMutex.Unlock()
+	result, ok := s.diagResults[uri]
+	return result, ok
+}
+
+// hasDiagnosticsResult returns whether the diagnostics of a file were computed for the client to pull them
+func (s *Server) hasDiagnosticsResult(uri protocol.DocumentURI) bool {
+	_, ok := s.diagnosticsResult(uri)
+	return ok
+}
+
+// storeDiagnostics keeps the diagnostics of a document until the client pulls them, and asks the client to pull them
+func (s *Server) storeDiagnostics(uri protocol.DocumentURI, diags []protocol.Diagnostic) {
+	s.diagResultsMutex.Lock()
+	defer s.diagResultsMutex.Unlock()
+
+	s.diagResultID++
+	s.diagResults[uri] = diagnosticsResult{resultID: strconv.Itoa(s.diagResultID), diags: diags}
+	s.refreshDiagnostics()
+}
+
+// forgetDiagnostics drops the diagnostics of a document that was closed or deleted
+func (s *Server) forgetDiagnostics(uri protocol.DocumentURI) {
+	s.diagResultsMutex.Lock()
+	defer s.diagResultsMutex.Unlock()
+
+	if _, ok := s.diagResults[uri]; ok {
+		delete(s.diagResults, uri)
+		s.refreshDiagnostics()
+	}
+}
+
+// refreshDiagnostics asks the client to pull the diagnostics again once the other diagnostics are ready.
+// It must be called with the results mutex locked
+func (s *Server) refreshDiagnostics() {
+	if !s.diagnosticsRefreshSupport {
+		return
+	}
+	if s.diagRefreshTimer == nil {
+		s.diagRefreshTimer = time.AfterFunc(diagnosticsRefreshDelay, func() {
+			if err := s.DiagnosticRefresh(context.Background()); err != nil {
+				log.Debugf("DiagnosticRefresh: %v", err)
+			}
+		})
+	} else {
+		s.diagRefreshTimer.Reset(diagnosticsRefreshDelay)
+	}
+}
+
+// diskDocument returns a document for a file that isn't open, to diagnose it from its content on disk
+func (s *Server) diskDocument(uri protocol.DocumentURI) (*cache.Document, error) {
+	text, err := s.cache.GetText(uri)
+	if err != nil {
+		return nil, err
+	}
+	doc := &cache.Document{
+		Item:                 protocol.TextDocumentItem{URI: uri, LanguageID: "jsonnet", Text: text},
+		LinesChangedSinceAST: map[int]bool{},
+	}
+	doc.AST, doc.Err = jsonnet.SnippetToAST(uri.SpanURI().Filename(), text)
+	return doc, nil
+}
+
+// workspaceEntrypoints returns the Jsonnet files of the workspace folders. They're found once, then kept until
+// the watched files are created or deleted
+func (s *Server) workspaceEntrypoints() []string {
+	s.entrypointsMutex.Lock()
+	defer s.entrypointsMutex.Unlock()
+
+	if s.entrypoints == nil {
+		s.entrypoints = findWorkspaceEntrypoints(s.workspaceRoots)
+	}
+	return s.entrypoints
+}
+
+// forgetWorkspaceEntrypoints drops the entrypoints found in the workspace folders, they're found again on the next pull
+func (s *Server) forgetWorkspaceEntrypoints() {
+	s.entrypointsMutex.Lock()
+	defer s.entrypointsMutex.Unlock()
+
+	s.entrypoints = nil
+}
+
+// findWorkspaceEntrypoints returns the Jsonnet files of the workspace folders, libraries excluded.
+// Hidden and vendor directories are skipped
+func findWorkspaceEntrypoints(roots []string) []string {
+	entrypoints := []string{}
+	for _, root := range roots {
+		root, err := filepath.Abs(root)
+		if err != nil {
+			log.Warnf("DiagnosticWorkspace: could not resolve the path of %s: %v", root, err)
+			continue
+		}
+		err = filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				log.Debugf("DiagnosticWorkspace: could not walk %s: %v", path, err)
+				return nil
+			}
+			if entry.IsDir() {
+				if path != root && (strings.HasPrefix(entry.Name(), ".") || entry.Name() == "vendor") {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if filepath.Ext(path) == ".jsonnet" {
+				entrypoints = append(entrypoints, path)
+			}
+			return nil
+		})
+		if err != nil {
+			log.Warnf("DiagnosticWorkspace: could not walk %s: %v", root, err)
+		}
+	}
+	return entrypoints
+}
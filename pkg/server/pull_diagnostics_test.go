@@ -0,0 +1,194 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jdbaldry/go-language-server-protocol/jsonrpc2"
+	"github.com/jdbaldry/go-language-server-protocol/lsp/protocol"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandlerDiagnosticProvider(t *testing.T) {
+	for _, tc := range []struct {
+		name         string
+		capabilities map[string]interface{}
+		expected     interface{}
+	}{
+		{
+			name:         "push diagnostics",
+			capabilities: map[string]interface{}{},
+		},
+		{
+			name: "pull diagnostics",
+			capabilities: map[string]interface{}{
+				"textDocument": map[string]interface{}{"diagnostic": map[string]interface{}{}},
+				"workspace":    map[string]interface{}{"diagnostics": map[string]interface{}{"refreshSupport": true}},
+			},
+			expected: map[string]interface{}{"interFileDependencies": true, "workspaceDiagnostics": true},
+		},
+		{
+			// The client couldn't be asked to pull the diagnostics computed in the background
+			name:         "pull diagnostics without refresh",
+			capabilities: map[string]interface{}{"textDocument": map[string]interface{}{"diagnostic": map[string]interface{}{}}},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			server := testServer(t, nil)
+			handler := NewHandler(server, jsonrpc2.MethodNotFound)
+
+			call, err := jsonrpc2.NewCall(jsonrpc2.NewIntID(1), "initialize", map[string]interface{}{"capabilities": tc.capabilities})
+			require.NoError(t, err)
+			var result interface{}
+			require.NoError(t, handler(context.Background(), func(_ context.Context, r interface{}, err error) error {
+				require.NoError(t, err)
+				result = r
+				return nil
+			}, call))
+
+			capabilities := result.(map[string]interface{})["capabilities"].(map[string]interface{})
+			assert.Equal(t, tc.expected, capabilities["diagnosticProvider"])
+			assert.Equal(t, tc.expected != nil, server.pullDiagnosticsSupport)
+		})
+	}
+}
+
+func TestDocumentDiagnostic(t *testing.T) {
+	server, uri := testServerWithFile(t, nil, "{ a: error 'broken' }")
+	server.configuration.EnableEvalDiagnostics = true
+	server.pullDiagnosticsSupport = true
+	server.diagnostics.startWorkers()
+	handler := NewHandler(server, jsonrpc2.MethodNotFound)
+
+	pull := func(previousResultID string) map[string]interface{} {
+		t.Helper()
+		call, err := jsonrpc2.NewCall(jsonrpc2.NewIntID(1), "textDocument/diagnostic", protocol.DocumentDiagnosticParams{
+			TextDocument:     protocol.TextDocumentIdentifier{URI: uri},
+			PreviousResultID: previousResultID,
+		})
+		require.NoError(t, err)
+		var report map[string]interface{}
+		require.NoError(t, handler(context.Background(), func(_ context.Context, r interface{}, err error) error {
+			require.NoError(t, err)
+			require.NoError(t, unmarshalParams(r, &report))
+			return nil
+		}, call))
+		return report
+	}
+
+	// The diagnostics computed in the background are waited for
+	report := pull("")
+	assert.Equal(t, "full", report["kind"])
+	require.Len(t, report["items"], 1)
+	assert.Contains(t, report["items"].([]interface{})[0].(map[string]interface{})["message"], "broken")
+	resultID := report["resultId"].(string)
+	assert.NotEmpty(t, resultID)
+
+	assert.Equal(t, map[string]interface{}{"kind": "unchanged", "resultId": resultID}, pull(resultID))
+
+	// The document is fixed
+	require.NoError(t, server.DidChange(context.Background(), &protocol.DidChangeTextDocumentParams{
+		TextDocument: protocol.VersionedTextDocumentIdentifier{
+			TextDocumentIdentifier: protocol.TextDocumentIdentifier{URI: uri},
+			Version:                2,
+		},
+		ContentChanges: []protocol.TextDocumentContentChangeEvent{{Text: "{ a: 1 }"}},
+	}))
+	report = pull(resultID)
+	assert.Equal(t, "full", report["kind"])
+	assert.Empty(t, report["items"])
+	assert.NotEqual(t, resultID, report["resultId"])
+
+	// The pull is canceled while the diagnostics are pending
+	server.diagnostics.schedule(uri, time.Hour)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := server.DocumentDiagnostic(ctx, &protocol.DocumentDiagnosticParams{TextDocument: protocol.TextDocumentIdentifier{URI: uri}})
+	assert.ErrorIs(t, err, context.Canceled)
+	server.diagnostics.cancel(uri)
+}
+
+func TestDiagnosticWorkspace(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"main.jsonnet":          "{ a: error 'broken' }",
+		"valid.jsonnet":         "{ a: 1 }",
+		"lib.libsonnet":         "{ a: error 'library' }",
+		"vendor/vendor.jsonnet": "{ a: error 'vendor' }",
+		"open.jsonnet":          "{ a: error 'open' }",
+	}
+	for name, content := range files {
+		require.NoError(t, os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0o700))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(content), 0o600))
+	}
+	mainURI, validURI := protocol.URIFromPath(filepath.Join(dir, "main.jsonnet")), protocol.URIFromPath(filepath.Join(dir, "valid.jsonnet"))
+
+	server := testServer(t, nil)
+	server.configuration.EnableEvalDiagnostics = true
+	server.pullDiagnosticsSupport = true
+	server.setWorkspaceRoots(&protocol.ParamInitialize{
+		InitializeParams: protocol.InitializeParams{RootURI: protocol.URIFromPath(dir)},
+	})
+	server.diagnostics.startWorkers()
+	serverOpenTestFile(t, server, filepath.Join(dir, "open.jsonnet"))
+
+	pull := func(previousResultIDs ...protocol.PreviousResultID) map[protocol.DocumentURI]map[string]interface{} {
+		t.Helper()
+		report, err := server.DiagnosticWorkspace(context.Background(), &protocol.WorkspaceDiagnosticParams{PreviousResultIds: previousResultIDs})
+		require.NoError(t, err)
+		data, err := json.Marshal(report)
+		require.NoError(t, err)
+		var items struct {
+			Items []map[string]interface{} `json:"items"`
+		}
+		require.NoError(t, json.Unmarshal(data, &items))
+		reports := map[protocol.DocumentURI]map[string]interface{}{}
+		for _, item := range items.Items {
+			reports[protocol.DocumentURI(item["uri"].(string))] = item
+		}
+		return reports
+	}
+
+	// The entrypoints are diagnosed in the background. Libraries, vendored and open files aren't reported
+	assert.Empty(t, pull())
+	var reports map[protocol.DocumentURI]map[string]interface{}
+	require.Eventually(t, func() bool {
+		reports = pull()
+		return len(reports) == 2
+	}, 10*time.Second, 50*time.Millisecond)
+
+	mainReport := reports[mainURI]
+	require.NotNil(t, mainReport)
+	assert.Equal(t, "full", mainReport["kind"])
+	assert.Nil(t, mainReport["version"])
+	require.Len(t, mainReport["items"], 1)
+	assert.True(t, strings.Contains(mainReport["items"].([]interface{})[0].(map[string]interface{})["message"].(string), "broken"))
+	assert.Empty(t, reports[validURI]["items"])
+
+	// Known results are unchanged
+	reports = pull(
+		protocol.PreviousResultID{URI: mainURI, Value: mainReport["resultId"].(string)},
+		protocol.PreviousResultID{URI: validURI, Value: "outdated"},
+	)
+	assert.Equal(t, "unchanged", reports[mainURI]["kind"])
+	assert.Equal(t, "full", reports[validURI]["kind"])
+
+	// The entrypoints are found again once the client notifies that files were created
+	createdFilename := filepath.Join(dir, "created.jsonnet")
+	require.NoError(t, os.WriteFile(createdFilename, []byte("{ a: error 'created' }"), 0o600))
+	createdURI := protocol.URIFromPath(createdFilename)
+	assert.NotContains(t, pull(), createdURI)
+	require.NoError(t, server.DidChangeWatchedFiles(context.Background(), &protocol.DidChangeWatchedFilesParams{
+		Changes: []protocol.FileEvent{{URI: createdURI, Type: protocol.Created}},
+	}))
+	require.Eventually(t, func() bool {
+		_, ok := pull()[createdURI]
+		return ok
+	}, 10*time.Second, 50*time.Millisecond)
+}
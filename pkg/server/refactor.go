@@ -0,0 +1,601 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/google/go-jsonnet"
+	"github.com/google/go-jsonnet/ast"
+	"github.com/google/go-jsonnet/formatter"
+	"github.com/google/go-jsonnet/toolutils"
+	"github.com/grafana/jsonnet-language-server/pkg/ast/processing"
+	"github.com/grafana/jsonnet-language-server/pkg/cache"
+	position "github.com/grafana/jsonnet-language-server/pkg/position_conversion"
+	"github.com/jdbaldry/go-language-server-protocol/lsp/protocol"
+	log "github.com/sirupsen/logrus"
+)
+
+// extractedLocalName is the name of the locals created by the extract refactoring, users are expected to rename them
+const extractedLocalName = "extracted"
+
+// fileNameRegexp matches the field names that can be used to name an extracted file
+var fileNameRegexp = regexp.MustCompile(`^[\w-]+$`)
+
+var (
+	errNoExpression       = errors.New("no expression found")
+	errUnsafeRefactoring  = errors.New("the refactoring would change the meaning of the code")
+	errInvalidRefactoring = errors.New("the refactored code is invalid")
+)
+
+// offsetEdit replaces the text between two offsets
+type offsetEdit struct {
+	begin, end int
+	newText    string
+}
+
+// refactorActions returns the refactorings available for the selection
+func (s *Server) refactorActions(doc *cache.Document, selectionRange protocol.Range, only []protocol.CodeActionKind) []protocol.CodeAction {
+	filename := doc.Item.URI.SpanURI().Filename()
+	text := doc.Item.Text
+	converter := s.converter(text)
+	selection := converter.RangeProtocolToAST(selectionRange)
+
+	var actions []protocol.CodeAction
+	addAction := func(title string, kind protocol.CodeActionKind, edits []offsetEdit) {
+		actions = append(actions, protocol.CodeAction{
+			Title: title,
+			Kind:  kind,
+			Edit: protocol.WorkspaceEdit{
+				Changes: map[string][]protocol.TextEdit{string(doc.Item.URI): offsetTextEdits(converter, text, edits)},
+			},
+		})
+	}
+
+	if codeActionKindRequested(only, protocol.RefactorExtract) {
+		if edits, err := extractLocal(filename, text, doc.AST, selection, s.configuration.FormattingOptions); err == nil {
+			addAction(fmt.Sprintf("Extract to local '%s'", extractedLocalName), protocol.RefactorExtract, edits)
+		} else {
+			log.Debugf("CodeAction: can't extract local: %v", err)
+		}
+
+	}
+
+	if codeActionKindRequested(only, protocol.RefactorInline) {
+		if name, edits, err := inlineLocal(filename, text, doc.AST, selection.Begin); err == nil {
+			addAction(fmt.Sprintf("Inline local '%s'", name), protocol.RefactorInline, edits)
+		} else {
+			log.Debugf("CodeAction: can't inline local: %v", err)
+		}
+	}
+	return actions
+}
+
+// extractLocal replaces the selected expression by a new local, defined in the nearest enclosing local or object
+func extractLocal(filename, text string, root ast.Node, selection ast.LocationRange, options formatter.Options) ([]offsetEdit, error) {
+	begin, end := textOffset(text, selection.Begin), textOffset(text, selection.End)
+	if begin > end {
+		return nil, errNoExpression
+	}
+	selected := text[begin:end]
+	begin += len(selected) - len(strings.TrimLeft(selected, " \t\r\n"))
+	end -= len(selected) - len(strings.TrimRight(selected, " \t\r\n"))
+	if begin >= end {
+		return nil, errNoExpression
+	}
+
+	path := findPath(root, func(node ast.Node) bool {
+		loc := node.Loc()
+		return textOffset(text, loc.Begin) == begin && textOffset(text, loc.End) == end
+	})
+	if len(path) == 0 {
+		return nil, errNoExpression
+	}
+	expression := path[len(path)-1]
+	if len(path) > 1 && !isExtractable(path[len(path)-2], expression) {
+		return nil, errNoExpression
+	}
+
+	// Find where the local is defined. Variables bound in between can't be used by the expression
+	free, usesSelf := freeVariables(expression), usesSelf(expression)
+	insertAt, separator := textOffset(text, root.Loc().Begin), ";"
+	child := expression
+scopes:
+	for i := len(path) - 2; i >= 0; i-- {
+		var bound []ast.Identifier
+		switch parent := path[i].(type) {
+		case *ast.Local:
+			if child == parent.Body {
+				insertAt = textOffset(text, parent.Body.Loc().Begin)
+				break scopes
+			}
+			for _, bind := range parent.Binds {
+				bound = append(bound, bind.Variable)
+			}
+		case *ast.DesugaredObject:
+			for _, field := range parent.Fields {
+				if field.Body == child && field.LocRange.Begin.IsSet() {
+					insertAt, separator = textOffset(text, field.LocRange.Begin), ","
+					break scopes
+				}
+			}
+			if usesSelf {
+				return nil, errUnsafeRefactoring
+			}
+			for _, bind := range parent.Locals {
+				bound = append(bound, bind.Variable)
+			}
+		case *ast.Function:
+			for _, param := range parent.Parameters {
+				bound = append(bound, param.Name)
+			}
+		}
+		for _, name := range bound {
+			if free[name] {
+				return nil, errUnsafeRefactoring
+			}
+		}
+		child = path[i]
+	}
+
+	name := uniqueName(root, extractedLocalName)
+	lineBegin := strings.LastIndexByte(text[:insertAt], '\n') + 1
+	line := text[lineBegin:]
+	indentation := line[:len(line)-len(strings.TrimLeft(line, " \t"))]
+	definition := fmt.Sprintf("local %s = %s%s", name, formatExpression(filename, text[begin:end], indentation, options), separator)
+	if strings.TrimSpace(text[lineBegin:insertAt]) == "" {
+		// The local is defined on its own line
+		definition += "\n" + indentation
+	} else {
+		definition += " "
+	}
+
+	var edits []offsetEdit
+	if insertAt == begin {
+		edits = []offsetEdit{{begin: begin, end: end, newText: definition + name}}
+	} else {
+		edits = []offsetEdit{{begin: insertAt, end: insertAt, newText: definition}, {begin: begin, end: end, newText: name}}
+	}
+	return edits, validateEdits(filename, text, edits)
+}
+
+// isExtractable returns false for the nodes that are part of the syntax of their parent rather than expressions
+func isExtractable(parent, node ast.Node) bool {
+	if object, ok := parent.(*ast.DesugaredObject); ok {
+		for _, field := range object.Fields {
+			if _, isString := field.Name.(*ast.LiteralString); isString && field.Name == node {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// formatExpression formats an expression that is inserted after the given indentation
+func formatExpression(filename, expression, indentation string, options formatter.Options) string {
+	formatted, err := formatter.Format(filename, expression, options)
+	if err != nil {
+		return expression
+	}
+	return indentContinuationLines(strings.TrimSuffix(formatted, "\n"), indentation)
+}
+
+// inlineLocal replaces the uses of the local defined or used at the location by its value, and removes the local
+func inlineLocal(filename, text string, root ast.Node, location ast.Location) (string, []offsetEdit, error) {
+	scope, index := findLocalAt(root, location)
+	if scope == nil {
+		return "", nil, errNoExpression
+	}
+
+	var binds ast.LocalBinds
+	var deletion offsetEdit
+	switch scope := scope.(type) {
+	case *ast.Local:
+		binds = scope.Binds
+		deletion.begin, deletion.end = localBindDeletion(text, scope, index)
+	case *ast.DesugaredObject:
+		binds = scope.Locals
+		deletion.begin, deletion.end = objectLocalDeletion(text, binds[index])
+	}
+	bind := binds[index]
+	name := bind.Variable
+	bodyLoc := bind.Body.Loc()
+	if _, isFunction := bind.Body.(*ast.Function); isFunction || !bodyLoc.Begin.IsSet() || deletion.end <= deletion.begin {
+		return "", nil, errUnsafeRefactoring
+	}
+	free, usesSelf := freeVariables(bind.Body), usesSelf(bind.Body)
+	if free[name] {
+		// Recursive locals can't be inlined
+		return "", nil, errUnsafeRefactoring
+	}
+
+	value := text[textOffset(text, bodyLoc.Begin):textOffset(text, bodyLoc.End)]
+	if needsParentheses(bind.Body) {
+		value = "(" + value + ")"
+	}
+
+	edits := []offsetEdit{deletion}
+	var unsafe bool
+	var visit func(node ast.Node, bound map[ast.Identifier]bool, inObject bool)
+	visit = func(node ast.Node, bound map[ast.Identifier]bool, inObject bool) {
+		if node == nil || unsafe {
+			return
+		}
+		names, isObject := boundNames(node)
+		for _, bound := range names {
+			if bound == name {
+				// The local is shadowed
+				return
+			}
+		}
+		if variable, ok := node.(*ast.Var); ok && variable.Id == name {
+			// The value can't be moved to where its variables are bound to something else
+			for freeName := range free {
+				if bound[freeName] {
+					unsafe = true
+				}
+			}
+			if (usesSelf && inObject) || !variable.LocRange.Begin.IsSet() {
+				unsafe = true
+			}
+			edits = append(edits, offsetEdit{begin: textOffset(text, variable.LocRange.Begin), end: textOffset(text, variable.LocRange.End), newText: value})
+			return
+		}
+		if len(names) > 0 {
+			bound = withNames(bound, names)
+		}
+		for _, child := range toolutils.Children(node) {
+			visit(child, bound, inObject || isObject)
+		}
+	}
+
+	// The uses are searched in the scope of the local, except in its own value
+	switch scope := scope.(type) {
+	case *ast.Local:
+		for i, other := range scope.Binds {
+			if i != index {
+				visit(other.Body, nil, false)
+			}
+		}
+		visit(scope.Body, nil, false)
+	case *ast.DesugaredObject:
+		for i, other := range scope.Locals {
+			if i != index {
+				visit(other.Body, nil, false)
+			}
+		}
+		for _, field := range scope.Fields {
+			visit(field.Name, nil, false)
+			visit(field.Body, nil, false)
+		}
+		for _, assert := range scope.Asserts {
+			visit(assert, nil, false)
+		}
+	}
+	if unsafe {
+		return "", nil, errUnsafeRefactoring
+	}
+	if len(edits) == 1 {
+		return "", nil, fmt.Errorf("%s is not used", name)
+	}
+	return string(name), edits, validateEdits(filename, text, edits)
+}
+
+// findLocalAt returns the local expression or the object defining the local whose name is at the location,
+// or whose variable is used at the location. The index of the bind is also returned
+func findLocalAt(root ast.Node, location ast.Location) (ast.Node, int) {
+	path := findPath(root, func(node ast.Node) bool {
+		return processing.InRange(location, *node.Loc())
+	})
+
+	var name ast.Identifier
+	if len(path) > 0 {
+		if variable, ok := path[len(path)-1].(*ast.Var); ok {
+			name = variable.Id
+		}
+	}
+	for i := len(path) - 1; i >= 0; i-- {
+		var binds ast.LocalBinds
+		switch node := path[i].(type) {
+		case *ast.Local:
+			binds = node.Binds
+		case *ast.DesugaredObject:
+			binds = node.Locals
+		case *ast.Function:
+			for _, param := range node.Parameters {
+				if param.Name == name {
+					return nil, 0
+				}
+			}
+		}
+		for j, bind := range binds {
+			if name == "" && bind.LocRange.Begin.IsSet() && processing.InRange(location, processing.LocalBindToRange(bind).SelectionRange) {
+				return path[i], j
+			}
+			if name != "" && bind.Variable == name {
+				return path[i], j
+			}
+		}
+	}
+	return nil, 0
+}
+
+// needsParentheses returns true if the value of an inlined local has to be parenthesized to keep its meaning
+func needsParentheses(node ast.Node) bool {
+	switch node.(type) {
+	case *ast.Var, *ast.Self, *ast.Dollar, *ast.LiteralString, *ast.LiteralNumber, *ast.LiteralBoolean, *ast.LiteralNull,
+		*ast.Index, *ast.SuperIndex, *ast.Apply, *ast.Array, *ast.DesugaredObject, *ast.Object:
+		return false
+	}
+	return true
+}
+
+// fileCodeAction is a code action creating a file, which the workspace edits of the protocol library can't express
+type fileCodeAction struct {
+	Title string                  `json:"title"`
+	Kind  protocol.CodeActionKind `json:"kind"`
+	Edit  fileWorkspaceEdit       `json:"edit"`
+}
+
+// fileWorkspaceEdit is a workspace edit whose document changes are protocol.CreateFile and textDocumentEdit operations, applied in order
+type fileWorkspaceEdit struct {
+	DocumentChanges []interface{} `json:"documentChanges"`
+}
+
+// textDocumentEdit is protocol.TextDocumentEdit, with a version that can be null for the documents that aren't open
+type textDocumentEdit struct {
+	TextDocument struct {
+		URI     protocol.DocumentURI `json:"uri"`
+		Version *int32               `json:"version"`
+	} `json:"textDocument"`
+	Edits []protocol.TextEdit `json:"edits"`
+}
+
+func newTextDocumentEdit(uri protocol.DocumentURI, version *int32, edits []protocol.TextEdit) textDocumentEdit {
+	edit := textDocumentEdit{Edits: edits}
+	edit.TextDocument.URI, edit.TextDocument.Version = uri, version
+	return edit
+}
+
+// fileCodeActions returns the refactorings creating files, when the client can create them. The file is created by the
+// client, which fails the whole edit if the file already exists
+func (s *Server) fileCodeActions(params *protocol.CodeActionParams) []fileCodeAction {
+	if !s.createFileSupport || !codeActionKindRequested(params.Context.Only, protocol.RefactorExtract) {
+		return nil
+	}
+	doc, err := s.cache.Get(params.TextDocument.URI)
+	if err != nil || doc.AST == nil || len(doc.LinesChangedSinceAST) > 0 {
+		return nil
+	}
+
+	filename := doc.Item.URI.SpanURI().Filename()
+	converter := s.converter(doc.Item.Text)
+	location := converter.ProtocolToAST(params.Range.Start)
+	extraction, err := extractFieldToFile(filename, doc.Item.Text, doc.AST, location, s.configuration.FormattingOptions)
+	if err != nil {
+		log.Debugf("CodeAction: can't extract field to a file: %v", err)
+		return nil
+	}
+
+	newURI := protocol.URIFromPath(extraction.filename)
+	version := doc.Item.Version
+	return []fileCodeAction{{
+		Title: fmt.Sprintf("Extract field '%s' to %s", extraction.field, filepath.Base(extraction.filename)),
+		Kind:  protocol.RefactorExtract,
+		Edit: fileWorkspaceEdit{DocumentChanges: []interface{}{
+			protocol.CreateFile{Kind: string(protocol.Create), URI: newURI},
+			newTextDocumentEdit(newURI, nil, []protocol.TextEdit{{NewText: extraction.content}}),
+			newTextDocumentEdit(doc.Item.URI, &version, offsetTextEdits(converter, doc.Item.Text, extraction.edits)),
+		}},
+	}}
+}
+
+// fieldExtraction is the result of the extraction of a field to a new file
+type fieldExtraction struct {
+	field    string
+	filename string
+	content  string
+	edits    []offsetEdit
+}
+
+// extractFieldToFile moves the value of the field named at the location to a new file next to the document, and imports it
+func extractFieldToFile(filename, text string, root ast.Node, location ast.Location, options formatter.Options) (*fieldExtraction, error) {
+	var field *ast.DesugaredObjectField
+	findPath(root, func(node ast.Node) bool {
+		if object, ok := node.(*ast.DesugaredObject); ok {
+			for i := range object.Fields {
+				if nameRange, ok := processing.FieldNameRange(object.Fields[i]); ok && processing.InRange(location, nameRange) {
+					field = &object.Fields[i]
+				}
+			}
+		}
+		return false
+	})
+	if field == nil {
+		return nil, errNoExpression
+	}
+
+	name := field.Name.(*ast.LiteralString).Value
+	bodyLoc := field.Body.Loc()
+	if !fileNameRegexp.MatchString(name) || field.PlusSuper || !bodyLoc.Begin.IsSet() {
+		return nil, fmt.Errorf("field %s can't be extracted", name)
+	}
+	// The new file can only use the standard library
+	for variable := range freeVariables(field.Body) {
+		if variable != "std" && variable != "$std" {
+			return nil, fmt.Errorf("%w: the field uses %s", errUnsafeRefactoring, variable)
+		}
+	}
+	if usesSelf(field.Body) {
+		return nil, fmt.Errorf("%w: the field uses its object", errUnsafeRefactoring)
+	}
+
+	newFilename := filepath.Join(filepath.Dir(filename), name+".libsonnet")
+	begin, end := textOffset(text, bodyLoc.Begin), textOffset(text, bodyLoc.End)
+	content, err := formatter.Format(newFilename, text[begin:end], options)
+	if err != nil {
+		return nil, err
+	}
+
+	edits := []offsetEdit{{begin: begin, end: end, newText: fmt.Sprintf("import '%s'", filepath.Base(newFilename))}}
+	return &fieldExtraction{field: name, filename: newFilename, content: content, edits: edits}, nil
+}
+
+// freeVariables returns the variables used by a node that are not bound in it
+func freeVariables(root ast.Node) map[ast.Identifier]bool {
+	free := map[ast.Identifier]bool{}
+	var visit func(node ast.Node, bound map[ast.Identifier]bool)
+	visit = func(node ast.Node, bound map[ast.Identifier]bool) {
+		if node == nil {
+			return
+		}
+		if variable, ok := node.(*ast.Var); ok && !bound[variable.Id] {
+			free[variable.Id] = true
+		}
+		if names, _ := boundNames(node); len(names) > 0 {
+			bound = withNames(bound, names)
+		}
+		for _, child := range toolutils.Children(node) {
+			visit(child, bound)
+		}
+	}
+	visit(root, nil)
+	return free
+}
+
+// usesSelf returns true if the node uses the object it's part of, through `self` or `super`
+func usesSelf(root ast.Node) bool {
+	found := false
+	var visit func(node ast.Node)
+	visit = func(node ast.Node) {
+		if node == nil || found {
+			return
+		}
+		switch node.(type) {
+		case *ast.Self, *ast.SuperIndex, *ast.InSuper:
+			found = true
+			return
+		case *ast.DesugaredObject:
+			// Nested objects have their own self
+			return
+		}
+		for _, child := range toolutils.Children(node) {
+			visit(child)
+		}
+	}
+	visit(root)
+	return found
+}
+
+// boundNames returns the variables bound by a node for its children, and whether it's an object
+func boundNames(node ast.Node) ([]ast.Identifier, bool) {
+	var names []ast.Identifier
+	switch node := node.(type) {
+	case *ast.Local:
+		for _, bind := range node.Binds {
+			names = append(names, bind.Variable)
+		}
+	case *ast.Function:
+		for _, param := range node.Parameters {
+			names = append(names, param.Name)
+		}
+	case *ast.DesugaredObject:
+		for _, bind := range node.Locals {
+			names = append(names, bind.Variable)
+		}
+		return names, true
+	}
+	return names, false
+}
+
+func withNames(bound map[ast.Identifier]bool, names []ast.Identifier) map[ast.Identifier]bool {
+	result := make(map[ast.Identifier]bool, len(bound)+len(names))
+	for name := range bound {
+		result[name] = true
+	}
+	for _, name := range names {
+		result[name] = true
+	}
+	return result
+}
+
+// uniqueName returns the name, suffixed by a number if a variable of the document already has this name
+func uniqueName(root ast.Node, name string) string {
+	used := map[ast.Identifier]bool{}
+	findPath(root, func(node ast.Node) bool {
+		if variable, ok := node.(*ast.Var); ok {
+			used[variable.Id] = true
+		}
+		names, _ := boundNames(node)
+		for _, bound := range names {
+			used[bound] = true
+		}
+		return false
+	})
+	result := name
+	for i := 2; used[ast.Identifier(result)]; i++ {
+		result = fmt.Sprintf("%s%d", name, i)
+	}
+	return result
+}
+
+// findPath returns the last node in the order of the tree that matches the predicate, preceded by its parents.
+// Nodes without a location are traversed but not matched
+func findPath(root ast.Node, predicate func(ast.Node) bool) []ast.Node {
+	var result, path []ast.Node
+	var visit func(node ast.Node)
+	visit = func(node ast.Node) {
+		if node == nil {
+			return
+		}
+		path = append(path, node)
+		if loc := node.Loc(); loc != nil && loc.Begin.IsSet() && predicate(node) {
+			result = append([]ast.Node{}, path...)
+		}
+		for _, child := range toolutils.Children(node) {
+			visit(child)
+		}
+		path = path[:len(path)-1]
+	}
+	visit(root)
+	return result
+}
+
+// validateEdits checks that the edited document is still valid
+func validateEdits(filename, text string, edits []offsetEdit) error {
+	if _, err := jsonnet.SnippetToAST(filename, applyOffsetEdits(text, edits)); err != nil {
+		return fmt.Errorf("%w: %v", errInvalidRefactoring, err)
+	}
+	return nil
+}
+
+func sortOffsetEdits(edits []offsetEdit) {
+	sort.SliceStable(edits, func(i, j int) bool {
+		return edits[i].begin < edits[j].begin
+	})
+}
+
+func applyOffsetEdits(text string, edits []offsetEdit) string {
+	sortOffsetEdits(edits)
+	var result strings.Builder
+	cursor := 0
+	for _, edit := range edits {
+		result.WriteString(text[cursor:edit.begin])
+		result.WriteString(edit.newText)
+		cursor = edit.end
+	}
+	result.WriteString(text[cursor:])
+	return result.String()
+}
+
+func offsetTextEdits(converter *position.Converter, text string, edits []offsetEdit) []protocol.TextEdit {
+	sortOffsetEdits(edits)
+	var result []protocol.TextEdit
+	for _, edit := range edits {
+		result = append(result, protocol.TextEdit{Range: offsetRange(converter, text, edit.begin, edit.end), NewText: edit.newText})
+	}
+	return result
+}
@@ -0,0 +1,293 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jdbaldry/go-language-server-protocol/jsonrpc2"
+	"github.com/jdbaldry/go-language-server-protocol/lsp/protocol"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// refactorResults returns the refactorings of the given kind for the selection, applied to the text of the document
+func refactorResults(t *testing.T, server *Server, uri protocol.DocumentURI, kind protocol.CodeActionKind, selection protocol.Range) []codeActionResult {
+	t.Helper()
+
+	doc, err := server.cache.Get(uri)
+	require.NoError(t, err)
+	actions, err := server.CodeAction(context.Background(), &protocol.CodeActionParams{
+		TextDocument: protocol.TextDocumentIdentifier{URI: uri},
+		Range:        selection,
+		Context:      protocol.CodeActionContext{Only: []protocol.CodeActionKind{kind}},
+	})
+	require.NoError(t, err)
+
+	results := []codeActionResult{}
+	for _, action := range actions {
+		assert.Equal(t, kind, action.Kind)
+		results = append(results, codeActionResult{
+			title:  action.Title,
+			result: applyTextEdits(t, doc.Item.Text, action.Edit.Changes[string(uri)]),
+		})
+	}
+	return results
+}
+
+func selectionRange(startLine, startCharacter, endLine, endCharacter uint32) protocol.Range {
+	return protocol.Range{
+		Start: protocol.Position{Line: startLine, Character: startCharacter},
+		End:   protocol.Position{Line: endLine, Character: endCharacter},
+	}
+}
+
+func TestExtractLocal(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		fileContent string
+		selection   protocol.Range
+		expected    string
+	}{
+		{
+			name:        "into a local body",
+			fileContent: "local a = 1;\na + 2 * 3\n",
+			selection:   selectionRange(1, 4, 1, 9),
+			expected:    "local a = 1;\nlocal extracted = 2 * 3;\na + extracted\n",
+		},
+		{
+			name:        "into an object",
+			fileContent: "{\n  a: 1,\n  b: { c: self.a + 1 },\n}\n",
+			selection:   selectionRange(2, 5, 2, 22),
+			expected:    "{\n  a: 1,\n  local extracted = { c: self.a + 1 },\n  b: extracted,\n}\n",
+		},
+		{
+			name:        "using self into the nearest object",
+			fileContent: "{\n  a: 1,\n  b: self.a + 1,\n}\n",
+			selection:   selectionRange(2, 5, 2, 11),
+			expected:    "{\n  a: 1,\n  local extracted = self.a,\n  b: extracted + 1,\n}\n",
+		},
+		{
+			name:        "selection with spaces",
+			fileContent: "local a = 1;\n[a, 'b']\n",
+			selection:   selectionRange(1, 3, 1, 7),
+			expected:    "local a = 1;\nlocal extracted = 'b';\n[a, extracted]\n",
+		},
+		{
+			name:        "whole body of a local",
+			fileContent: "local a = 1;\n[a]\n",
+			selection:   selectionRange(1, 0, 1, 3),
+			expected:    "local a = 1;\nlocal extracted = [a];\nextracted\n",
+		},
+		{
+			name:        "without enclosing scope",
+			fileContent: "[1 + 2, 3]\n",
+			selection:   selectionRange(0, 1, 0, 6),
+			expected:    "local extracted = 1 + 2;\n[extracted, 3]\n",
+		},
+		{
+			name:        "unique name",
+			fileContent: "local extracted = 1;\nextracted + 2\n",
+			selection:   selectionRange(1, 12, 1, 13),
+			expected:    "local extracted = 1;\nlocal extracted2 = 2;\nextracted + extracted2\n",
+		},
+		{
+			name:        "formatted",
+			fileContent: "{\n  a: {\n    b: { c:1, d:\"x\" },\n  },\n}\n",
+			selection:   selectionRange(2, 7, 2, 21),
+			expected:    "{\n  a: {\n    local extracted = { c: 1, d: 'x' },\n    b: extracted,\n  },\n}\n",
+		},
+		{
+			name:        "using a function parameter",
+			fileContent: "local f(x) = x + 1;\nf(1)\n",
+			selection:   selectionRange(0, 13, 0, 18),
+		},
+		{
+			name:        "not an expression",
+			fileContent: "local a = 1;\na + 2 * 3\n",
+			selection:   selectionRange(1, 2, 1, 6),
+		},
+		{
+			name:        "field name",
+			fileContent: "{ 'a': 1 }\n",
+			selection:   selectionRange(0, 2, 0, 5),
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			server, uri := testServerWithFile(t, nil, tc.fileContent)
+
+			results := refactorResults(t, server, uri, protocol.RefactorExtract, tc.selection)
+			if tc.expected == "" {
+				assert.Empty(t, results)
+				return
+			}
+			assert.Equal(t, []codeActionResult{{title: "Extract to local 'extracted'", result: tc.expected}}, results)
+		})
+	}
+}
+
+func TestInlineLocal(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		fileContent string
+		position    protocol.Position
+		expected    []codeActionResult
+	}{
+		{
+			name:        "from the definition",
+			fileContent: "local a = 1;\na + a\n",
+			position:    protocol.Position{Line: 0, Character: 6},
+			expected:    []codeActionResult{{title: "Inline local 'a'", result: "1 + 1\n"}},
+		},
+		{
+			name:        "from a use",
+			fileContent: "local a = 1;\n{ b: a }\n",
+			position:    protocol.Position{Line: 1, Character: 5},
+			expected:    []codeActionResult{{title: "Inline local 'a'", result: "{ b: 1 }\n"}},
+		},
+		{
+			name:        "parenthesized value",
+			fileContent: "local a = 1 + 2;\na * 3\n",
+			position:    protocol.Position{Line: 0, Character: 6},
+			expected:    []codeActionResult{{title: "Inline local 'a'", result: "(1 + 2) * 3\n"}},
+		},
+		{
+			name:        "one of multiple binds",
+			fileContent: "local a = 1, b = a + 1;\nb\n",
+			position:    protocol.Position{Line: 0, Character: 6},
+			expected:    []codeActionResult{{title: "Inline local 'a'", result: "local b = 1 + 1;\nb\n"}},
+		},
+		{
+			name:        "object local",
+			fileContent: "{\n  local name = 'app',\n  metadata: { name: name },\n}\n",
+			position:    protocol.Position{Line: 1, Character: 8},
+			expected:    []codeActionResult{{title: "Inline local 'name'", result: "{\n  metadata: { name: 'app' },\n}\n"}},
+		},
+		{
+			name:        "shadowed uses are kept",
+			fileContent: "local a = 1;\n[a, local a = 2; a]\n",
+			position:    protocol.Position{Line: 0, Character: 6},
+			expected:    []codeActionResult{{title: "Inline local 'a'", result: "[1, local a = 2; a]\n"}},
+		},
+		{
+			name:        "value using a shadowed variable",
+			fileContent: "local b = 1;\nlocal a = b;\nfunction(b) a\n",
+			position:    protocol.Position{Line: 1, Character: 6},
+			expected:    []codeActionResult{},
+		},
+		{
+			name:        "value using self in a nested object",
+			fileContent: "{\n  local a = self.x,\n  x: 1,\n  y: { z: a },\n}\n",
+			position:    protocol.Position{Line: 1, Character: 8},
+			expected:    []codeActionResult{},
+		},
+		{
+			name:        "recursive local",
+			fileContent: "local f = function(n) if n == 0 then 1 else f(n - 1);\nf(2)\n",
+			position:    protocol.Position{Line: 0, Character: 6},
+			expected:    []codeActionResult{},
+		},
+		{
+			name:        "function parameter",
+			fileContent: "function(a) a\n",
+			position:    protocol.Position{Line: 0, Character: 12},
+			expected:    []codeActionResult{},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			server, uri := testServerWithFile(t, nil, tc.fileContent)
+
+			selection := protocol.Range{Start: tc.position, End: tc.position}
+			assert.Equal(t, tc.expected, refactorResults(t, server, uri, protocol.RefactorInline, selection))
+		})
+	}
+}
+
+func TestExtractFieldToFile(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "main.jsonnet")
+	content := "local lib = import 'lib.libsonnet';\n{\n  config: { replicas: 1, image:\"nginx\" },\n  service: lib.service,\n  other: self.config,\n}\n"
+	require.NoError(t, os.WriteFile(filename, []byte(content), 0o600))
+
+	server := testServer(t, nil)
+	uri := serverOpenTestFile(t, server, filename)
+	codeActionParams := func(pos protocol.Position) *protocol.CodeActionParams {
+		return &protocol.CodeActionParams{
+			TextDocument: protocol.TextDocumentIdentifier{URI: uri},
+			Range:        protocol.Range{Start: pos, End: pos},
+			Context:      protocol.CodeActionContext{Only: []protocol.CodeActionKind{protocol.RefactorExtract}},
+		}
+	}
+
+	// The action is only offered to the clients that can create files
+	assert.Empty(t, server.fileCodeActions(codeActionParams(protocol.Position{Line: 2, Character: 3})))
+
+	// The client creates files if it supports resource operations in workspace edits
+	handler := NewHandler(server, jsonrpc2.MethodNotFound)
+	call := func(method string, params interface{}) interface{} {
+		t.Helper()
+		request, err := jsonrpc2.NewCall(jsonrpc2.NewIntID(1), method, params)
+		require.NoError(t, err)
+		var result interface{}
+		require.NoError(t, handler(context.Background(), func(_ context.Context, r interface{}, err error) error {
+			require.NoError(t, err)
+			result = r
+			return nil
+		}, request))
+		return result
+	}
+	call("initialize", map[string]interface{}{
+		"capabilities": map[string]interface{}{
+			"workspace": map[string]interface{}{
+				"workspaceEdit": map[string]interface{}{"documentChanges": true, "resourceOperations": []string{"create", "rename"}},
+			},
+		},
+	})
+	require.True(t, server.createFileSupport)
+
+	// Fields using locals or their object can't be moved to another file
+	assert.Empty(t, server.fileCodeActions(codeActionParams(protocol.Position{Line: 3, Character: 3})))
+	assert.Empty(t, server.fileCodeActions(codeActionParams(protocol.Position{Line: 4, Character: 3})))
+
+	actions := server.fileCodeActions(codeActionParams(protocol.Position{Line: 2, Character: 3}))
+	require.Len(t, actions, 1)
+	action := actions[0]
+	assert.Equal(t, "Extract field 'config' to config.libsonnet", action.Title)
+	require.Len(t, action.Edit.DocumentChanges, 3)
+	newURI := protocol.URIFromPath(filepath.Join(dir, "config.libsonnet"))
+	assert.Equal(t, []interface{}{
+		protocol.CreateFile{Kind: "create", URI: newURI},
+		newTextDocumentEdit(newURI, nil, []protocol.TextEdit{{NewText: "{ replicas: 1, image: 'nginx' }\n"}}),
+	}, action.Edit.DocumentChanges[:2])
+	documentEdit, ok := action.Edit.DocumentChanges[2].(textDocumentEdit)
+	require.True(t, ok)
+	assert.Equal(t, uri, documentEdit.TextDocument.URI)
+	require.NotNil(t, documentEdit.TextDocument.Version)
+	assert.Equal(t, int32(1), *documentEdit.TextDocument.Version)
+	assert.Equal(t,
+		"local lib = import 'lib.libsonnet';\n{\n  config: import 'config.libsonnet',\n  service: lib.service,\n  other: self.config,\n}\n",
+		applyTextEdits(t, content, documentEdit.Edits),
+	)
+	_, err := os.Stat(filepath.Join(dir, "config.libsonnet"))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+
+	// The action is added to the other code actions by the handler. The version of the new file is null
+	result := call("textDocument/codeAction", codeActionParams(protocol.Position{Line: 2, Character: 3}))
+	data, err := json.Marshal(result)
+	require.NoError(t, err)
+	var handled []struct {
+		Title string `json:"title"`
+		Edit  struct {
+			DocumentChanges []map[string]interface{} `json:"documentChanges"`
+		} `json:"edit"`
+	}
+	require.NoError(t, json.Unmarshal(data, &handled))
+	require.NotEmpty(t, handled)
+	last := handled[len(handled)-1]
+	assert.Equal(t, action.Title, last.Title)
+	require.Len(t, last.Edit.DocumentChanges, 3)
+	assert.Equal(t, "create", last.Edit.DocumentChanges[0]["kind"])
+	assert.Equal(t, map[string]interface{}{"uri": string(newURI), "version": nil}, last.Edit.DocumentChanges[1]["textDocument"])
+}
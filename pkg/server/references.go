@@ -2,12 +2,13 @@ package server
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"path/filepath"
 
 	"github.com/google/go-jsonnet/ast"
 	"github.com/grafana/jsonnet-language-server/pkg/ast/processing"
-	"github.com/grafana/jsonnet-language-server/pkg/cache"
-	position "github.com/grafana/jsonnet-language-server/pkg/position_conversion"
+	"github.com/grafana/jsonnet-language-server/pkg/nodestack"
 	"github.com/grafana/jsonnet-language-server/pkg/utils"
 	"github.com/jdbaldry/go-language-server-protocol/lsp/protocol"
 	log "github.com/sirupsen/logrus"
@@ -16,48 +17,216 @@ import (
 	"github.com/grafana/tanka/pkg/jsonnet/jpath"
 )
 
-// findSymbolAndFiles finds the symbol identifier and possible files where it might be used
-// based on the AST node at the given position.
-func (s *Server) findSymbolAndFiles(doc *cache.Document, params *protocol.ReferenceParams) (string, []string, error) {
-	searchStack, _ := processing.FindNodeByPosition(doc.AST, position.ProtocolToAST(params.Position))
+var (
+	errNoSymbol         = errors.New("no symbol found at position")
+	errStdSymbol        = errors.New("symbols of the standard library are not supported")
+	errComputedField    = errors.New("computed field names are not supported")
+	errUnsupportedIndex = errors.New("cannot resolve the field of a parameter")
+)
+
+// targetSymbol is a user-defined symbol (local, parameter or field) found at a position
+type targetSymbol struct {
+	name string
+	// nameRange is the range of the symbol's name under the cursor
+	nameRange ast.LocationRange
+	// isField is true for object fields, which can be used from other files. Otherwise, the symbol is a local or a parameter
+	isField     bool
+	definitions []processing.ObjectRange
+}
+
+// files returns the files where the symbol can be used
+func (t *targetSymbol) files() []string {
+	if !t.isField {
+		// Locals and parameters can only be used in the file that defines them
+		return []string{t.definitions[0].Filename}
+	}
+	return definitionImporters(t.definitions)
+}
 
-	// Only match locals and obj fields, as we're trying to find usages of these
-	possibleFiles := []string{}
-	idOfSymbol := ""
+// definitionNameRange returns the range of the symbol's name at one of its definitions
+func (t *targetSymbol) definitionNameRange(processor *processing.Processor, definition processing.ObjectRange) (ast.LocationRange, bool) {
+	if !t.isField {
+		return definition.SelectionRange, true
+	}
+	return findFieldNameRange(processor, definition)
+}
+
+// usageNameRange returns the range of the symbol's name in a usage found by FindUsages.
+// For indexes (`foo.bar`, `foo['bar']`), only the field name is returned
+func usageNameRange(usage processing.ObjectRange) (ast.LocationRange, bool) {
+	if varNode, ok := usage.Node.(*ast.Var); ok {
+		return varNode.LocRange, true
+	}
+	return processing.IndexNameRange(usage.Node)
+}
+
+// findTargetSymbol finds the symbol at the given position, either where it's used or where it's defined
+func findTargetSymbol(processor *processing.Processor, root ast.Node, location ast.Location) (*targetSymbol, error) {
+	searchStack, err := processing.FindNodeByPosition(root, location)
+	if err != nil {
+		return nil, err
+	}
+	if searchStack.IsEmpty() {
+		return nil, errNoSymbol
+	}
+
+	deepestNode := searchStack.Pop()
+	switch deepestNode := deepestNode.(type) {
+	case *ast.Var:
+		switch deepestNode.Id {
+		case "std", "$std":
+			return nil, errStdSymbol
+		case "$":
+			return nil, fmt.Errorf("$ is not a user-defined symbol")
+		}
+		definition := processing.FindVarDefinitionViaStack(searchStack, deepestNode.Id)
+		if definition == nil {
+			return nil, fmt.Errorf("no matching bind found for %s", deepestNode.Id)
+		}
+		return &targetSymbol{
+			name:        string(deepestNode.Id),
+			nameRange:   deepestNode.LocRange,
+			definitions: []processing.ObjectRange{*definition},
+		}, nil
+	case *ast.Self:
+		return nil, fmt.Errorf("self is not a user-defined symbol")
+	case *ast.Index, *ast.SuperIndex:
+		return findIndexTargetSymbol(processor, searchStack, deepestNode, location)
+	case *ast.LiteralString:
+		// `foo['bar']`, the string is the field name
+		if index, ok := searchStack.Peek().(*ast.Index); ok && index.Index == deepestNode {
+			searchStack.Pop()
+			return findIndexTargetSymbol(processor, searchStack, index, location)
+		}
+	}
+
+	// Otherwise, look for a local, parameter or field declared at the position
+	searchStack.Push(deepestNode)
 	for !searchStack.IsEmpty() {
-		deepestNode := searchStack.Pop()
-		switch deepestNode := deepestNode.(type) {
+		var binds ast.LocalBinds
+		switch node := searchStack.Pop().(type) {
 		case *ast.Local:
-			idOfSymbol = string(deepestNode.Binds[0].Variable)
-			possibleFiles = []string{doc.Item.URI.SpanURI().Filename()} // Local variables are always used in the current file
+			binds = node.Binds
+		case *ast.Function:
+			for _, param := range node.Parameters {
+				if paramRange := processing.ParameterToRange(param); param.LocRange.Begin.IsSet() && processing.InRange(location, paramRange.SelectionRange) {
+					return &targetSymbol{
+						name:        string(param.Name),
+						nameRange:   paramRange.SelectionRange,
+						definitions: []processing.ObjectRange{paramRange},
+					}, nil
+				}
+			}
 		case *ast.DesugaredObject:
-			// Find the field on the position
-			for _, field := range deepestNode.Fields {
-				if position.RangeASTToProtocol(field.LocRange).Start.Line == params.Position.Line {
-					fieldName, ok := field.Name.(*ast.LiteralString)
-					if !ok {
-						return "", nil, utils.LogErrorf("References: field name is not a string")
-					}
-					idOfSymbol = fieldName.Value
-					root, err := jpath.FindRoot(doc.Item.URI.SpanURI().Filename())
-					if err != nil {
-						log.Errorf("References: Error resolving Tanka root, using current directory: %v", err)
-						root = filepath.Dir(doc.Item.URI.SpanURI().Filename())
-					}
-					possibleFiles, err = tankaJsonnet.FindTransitiveImportersForFile(root, []string{doc.Item.URI.SpanURI().Filename()})
-					if err != nil {
-						log.Errorf("References: Error finding transitive importers. Using current file only: %v", err)
-						possibleFiles = []string{doc.Item.URI.SpanURI().Filename()}
+			binds = node.Locals
+			for _, field := range node.Fields {
+				nameRange, ok := processing.FieldNameRange(field)
+				if !ok {
+					// The brackets of a computed field name (`[expr]: ...`) are not part of the name node
+					nameLoc := field.Name.Loc()
+					if nameLoc != nil && nameLoc.End.IsSet() && processing.InRange(location, ast.LocationRange{Begin: field.LocRange.Begin, End: ast.Location{Line: nameLoc.End.Line, Column: nameLoc.End.Column + 1}}) {
+						return nil, errComputedField
 					}
-					break
+					continue
 				}
+				if processing.InRange(location, nameRange) {
+					return &targetSymbol{
+						name:        processor.FieldNameToString(field.Name),
+						nameRange:   nameRange,
+						isField:     true,
+						definitions: []processing.ObjectRange{processor.FieldToRange(field)},
+					}, nil
+				}
+			}
+		}
+		for _, bind := range binds {
+			bindRange := processing.LocalBindToRange(bind)
+			if bind.Variable != "$" && processing.InRange(location, bindRange.SelectionRange) {
+				return &targetSymbol{
+					name:        string(bind.Variable),
+					nameRange:   bindRange.SelectionRange,
+					definitions: []processing.ObjectRange{bindRange},
+				}, nil
 			}
 		}
-		if idOfSymbol != "" {
-			break
+	}
+
+	return nil, errNoSymbol
+}
+
+func findIndexTargetSymbol(processor *processing.Processor, stack *nodestack.NodeStack, index ast.Node, location ast.Location) (*targetSymbol, error) {
+	nameRange, ok := processing.IndexNameRange(index)
+	if !ok {
+		return nil, errComputedField
+	}
+	if !processing.InRange(location, nameRange) {
+		if _, isSuper := index.(*ast.SuperIndex); isSuper {
+			return nil, fmt.Errorf("super is not a user-defined symbol")
 		}
+		return nil, errNoSymbol
+	}
+
+	indexList := nodestack.NewNodeStack(index).BuildIndexList()
+	if len(indexList) > 0 && indexList[0] == "std" {
+		return nil, errStdSymbol
+	}
+	ranges, err := processor.FindRangesFromIndexList(stack.Clone(), indexList, false)
+	if err != nil {
+		return nil, err
+	}
+
+	symbol := &targetSymbol{
+		name:      indexList[len(indexList)-1],
+		nameRange: nameRange,
+		isField:   true,
+	}
+	for _, r := range ranges {
+		// Ranges without a field name are parameters, we can't know which fields they hold
+		if r.FieldName != "" {
+			symbol.definitions = append(symbol.definitions, r)
+		}
+	}
+	if len(symbol.definitions) == 0 {
+		return nil, errUnsupportedIndex
 	}
-	return idOfSymbol, possibleFiles, nil
+	return symbol, nil
+}
+
+// definitionImporters returns all the files where the given definitions can be used, including the files defining them
+func definitionImporters(definitions []processing.ObjectRange) []string {
+	var files []string
+	seen := map[string]bool{}
+	searched := map[string]bool{}
+	for _, definition := range definitions {
+		filename := utils.AbsFilename(definition.Filename)
+		if searched[filename] {
+			continue
+		}
+		searched[filename] = true
+		for _, file := range append(findTransitiveImporters(filename), filename) {
+			if !seen[file] {
+				seen[file] = true
+				files = append(files, file)
+			}
+		}
+	}
+	return files
+}
+
+// findTransitiveImporters returns the given file and all the files that import it, directly or not.
+// The search is done from the Tanka root of the file, or from its directory if it's not part of a Tanka project.
+func findTransitiveImporters(filename string) []string {
+	root, err := jpath.FindRoot(filename)
+	if err != nil {
+		log.Errorf("Error resolving Tanka root, using current directory: %v", err)
+		root = filepath.Dir(filename)
+	}
+	importers, err := tankaJsonnet.FindTransitiveImportersForFile(root, []string{filename})
+	if err != nil {
+		log.Errorf("Error finding transitive importers. Using current file only: %v", err)
+		return []string{filename}
+	}
+	return importers
 }
 
 func (s *Server) References(_ context.Context, params *protocol.ReferenceParams) ([]protocol.Location, error) {
@@ -77,23 +246,33 @@ func (s *Server) References(_ context.Context, params *protocol.ReferenceParams)
 	vm := s.getVM(doc.Item.URI.SpanURI().Filename())
 	processor := processing.NewProcessor(s.cache, vm)
 
-	idOfSymbol, possibleFiles, err := s.findSymbolAndFiles(doc, params)
+	symbol, err := findTargetSymbol(processor, doc.AST, s.converter(doc.Item.Text).ProtocolToAST(params.Position))
 	if err != nil {
 		return nil, err
 	}
 
 	// Find all usages of the symbol
-	objectRanges, err := processor.FindUsages(possibleFiles, idOfSymbol)
+	usages, definitions, err := processor.FindUsages(symbol.files(), symbol.name, symbol.definitions)
 	if err != nil {
 		return nil, err
 	}
 
 	// Convert ObjectRanges to protocol.Locations
 	var locations []protocol.Location
-	for _, r := range objectRanges {
+	if params.Context.IncludeDeclaration {
+		for _, r := range definitions {
+			uri := protocol.URIFromPath(r.Filename)
+			locations = append(locations, protocol.Location{
+				URI:   uri,
+				Range: s.fileConverter(uri).RangeASTToProtocol(r.SelectionRange),
+			})
+		}
+	}
+	for _, r := range usages {
+		uri := protocol.URIFromPath(r.Filename)
 		locations = append(locations, protocol.Location{
-			URI:   protocol.URIFromPath(r.Filename),
-			Range: position.RangeASTToProtocol(r.SelectionRange),
+			URI:   uri,
+			Range: s.fileConverter(uri).RangeASTToProtocol(r.SelectionRange),
 		})
 	}
 
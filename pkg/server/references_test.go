@@ -21,10 +21,71 @@ type referenceTestCase struct {
 	filename string
 	position protocol.Position
 
-	results []referenceResult
+	includeDeclaration bool
+	results            []referenceResult
 }
 
 var referenceTestCases = []referenceTestCase{
+	{
+		name:     "object local shadowed by a parameter",
+		filename: "testdata/rename-lib.libsonnet",
+		position: protocol.Position{Line: 2, Character: 8},
+		results: []referenceResult{
+			{
+				targetRange: protocol.Range{
+					Start: protocol.Position{Line: 3, Character: 16},
+					End:   protocol.Position{Line: 3, Character: 21},
+				},
+			},
+		},
+	},
+	{
+		name:               "parameter with declaration",
+		filename:           "testdata/rename-lib.libsonnet",
+		position:           protocol.Position{Line: 4, Character: 26},
+		includeDeclaration: true,
+		results: []referenceResult{
+			{
+				targetRange: protocol.Range{
+					Start: protocol.Position{Line: 4, Character: 9},
+					End:   protocol.Position{Line: 4, Character: 14},
+				},
+			},
+			{
+				targetRange: protocol.Range{
+					Start: protocol.Position{Line: 4, Character: 25},
+					End:   protocol.Position{Line: 4, Character: 30},
+				},
+			},
+		},
+	},
+	{
+		name:     "field with the same name as a nested field",
+		filename: "testdata/rename-main.jsonnet",
+		position: protocol.Position{Line: 2, Character: 10},
+		results: []referenceResult{
+			{
+				targetFilename: "testdata/rename-lib.libsonnet",
+				targetRange: protocol.Range{
+					Start: protocol.Position{Line: 4, Character: 34},
+					End:   protocol.Position{Line: 4, Character: 44},
+				},
+			},
+			{
+				targetFilename: "testdata/rename-lib.libsonnet",
+				targetRange: protocol.Range{
+					Start: protocol.Position{Line: 6, Character: 17},
+					End:   protocol.Position{Line: 6, Character: 30},
+				},
+			},
+			{
+				targetRange: protocol.Range{
+					Start: protocol.Position{Line: 2, Character: 5},
+					End:   protocol.Position{Line: 2, Character: 14},
+				},
+			},
+		},
+	},
 	{
 		name:     "local var",
 		filename: "testdata/test_goto_definition.jsonnet",
@@ -128,6 +189,7 @@ func TestReferences(t *testing.T) {
 					},
 					Position: tc.position,
 				},
+				Context: protocol.ReferenceContext{IncludeDeclaration: tc.includeDeclaration},
 			}
 
 			server := NewServer("any", "test version", nil, Configuration{
@@ -0,0 +1,214 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/google/go-jsonnet/ast"
+	"github.com/grafana/jsonnet-language-server/pkg/ast/processing"
+	position "github.com/grafana/jsonnet-language-server/pkg/position_conversion"
+	"github.com/grafana/jsonnet-language-server/pkg/utils"
+	"github.com/jdbaldry/go-language-server-protocol/jsonrpc2"
+	"github.com/jdbaldry/go-language-server-protocol/lsp/protocol"
+)
+
+var (
+	identifierRegexp = regexp.MustCompile(`^[_a-zA-Z][_a-zA-Z0-9]*$`)
+	jsonnetKeywords  = map[string]bool{
+		"assert": true, "else": true, "error": true, "false": true, "for": true, "function": true, "if": true,
+		"import": true, "importstr": true, "importbin": true, "in": true, "local": true, "null": true,
+		"tailstrict": true, "then": true, "self": true, "super": true, "true": true,
+	}
+)
+
+func (s *Server) PrepareRename(_ context.Context, params *protocol.PrepareRenameParams) (*protocol.Range, error) {
+	doc, err := s.cache.Get(params.TextDocument.URI)
+	if err != nil {
+		return nil, utils.LogErrorf("PrepareRename: %s: %w", errorRetrievingDocument, err)
+	}
+
+	if doc.AST == nil {
+		return nil, utils.LogErrorf("PrepareRename: document was never successfully parsed, can't rename")
+	}
+	if doc.LinesChangedSinceAST[int(params.Position.Line)] {
+		return nil, utils.LogErrorf("PrepareRename: document line %d was changed since last successful parse, can't rename", params.Position.Line)
+	}
+
+	processor := processing.NewProcessor(s.cache, s.getVM(doc.Item.URI.SpanURI().Filename()))
+	converter := s.converter(doc.Item.Text)
+	target, err := findTargetSymbol(processor, doc.AST, converter.ProtocolToAST(params.Position))
+	if err != nil {
+		return nil, err
+	}
+
+	result := converter.RangeASTToProtocol(target.nameRange)
+	return &result, nil
+}
+
+func (s *Server) Rename(_ context.Context, params *protocol.RenameParams) (*protocol.WorkspaceEdit, error) {
+	doc, err := s.cache.Get(params.TextDocument.URI)
+	if err != nil {
+		return nil, utils.LogErrorf("Rename: %s: %w", errorRetrievingDocument, err)
+	}
+
+	if doc.AST == nil {
+		return nil, utils.LogErrorf("Rename: document was never successfully parsed, can't rename")
+	}
+	if doc.LinesChangedSinceAST[int(params.Position.Line)] {
+		return nil, utils.LogErrorf("Rename: document line %d was changed since last successful parse, can't rename", params.Position.Line)
+	}
+
+	processor := processing.NewProcessor(s.cache, s.getVM(doc.Item.URI.SpanURI().Filename()))
+	target, err := findTargetSymbol(processor, doc.AST, s.converter(doc.Item.Text).ProtocolToAST(params.Position))
+	if err != nil {
+		return nil, err
+	}
+	// Fields can have any name, they're quoted when they aren't identifiers
+	if !target.isField && !isIdentifier(params.NewName) {
+		return nil, fmt.Errorf("%w: %q is not a valid Jsonnet identifier", jsonrpc2.ErrInvalidParams, params.NewName)
+	}
+
+	usages, definitions, err := processor.FindUsages(target.files(), target.name, target.definitions)
+	if err != nil {
+		return nil, err
+	}
+
+	edits := newWorkspaceEditBuilder(s.fileConverter)
+	for _, usage := range usages {
+		if nameRange, newText, ok := target.renameUsage(usage, params.NewName); ok {
+			edits.add(usage.Filename, nameRange, newText)
+		}
+	}
+	for _, definition := range definitions {
+		if nameRange, newText, ok := target.renameDefinition(processor, definition, params.NewName); ok {
+			edits.add(definition.Filename, nameRange, newText)
+		}
+	}
+
+	return edits.build(), nil
+}
+
+// isIdentifier returns true if the name can be used for a local or a parameter
+func isIdentifier(name string) bool {
+	return identifierRegexp.MatchString(name) && !jsonnetKeywords[name]
+}
+
+// quoteFieldName returns the name as a Jsonnet string, for field names that aren't identifiers
+func quoteFieldName(name string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`, "\n", `\n`).Replace(name) + "'"
+}
+
+// renameDefinition returns the range and the text replacing the symbol's name at one of its definitions.
+// Field names that aren't identifiers are quoted: `bar: 1` becomes `'new-name': 1`
+func (t *targetSymbol) renameDefinition(processor *processing.Processor, definition processing.ObjectRange, newName string) (ast.LocationRange, string, bool) {
+	if !t.isField {
+		return definition.SelectionRange, newName, true
+	}
+	field, ok := findField(processor, definition)
+	if !ok {
+		return ast.LocationRange{}, "", false
+	}
+	nameRange, ok := processing.FieldNameRange(field)
+	if !ok || isIdentifier(newName) {
+		return nameRange, newName, ok
+	}
+	if name := field.Name.(*ast.LiteralString); name.LocRange.Begin.IsSet() {
+		// The string is replaced with its quotes, they may differ
+		return name.LocRange, quoteFieldName(newName), true
+	}
+	return nameRange, quoteFieldName(newName), true
+}
+
+// renameUsage returns the range and the text replacing the symbol's name in a usage found by FindUsages.
+// Field names that aren't identifiers are indexed with brackets: `foo.bar` becomes `foo['new-name']`
+func (t *targetSymbol) renameUsage(usage processing.ObjectRange, newName string) (ast.LocationRange, string, bool) {
+	nameRange, ok := usageNameRange(usage)
+	if !ok || !t.isField || isIdentifier(newName) {
+		return nameRange, newName, ok
+	}
+
+	var name *ast.LiteralString
+	var dot ast.Location
+	switch node := usage.Node.(type) {
+	case *ast.Index:
+		name, _ = node.Index.(*ast.LiteralString)
+		dot = node.Target.Loc().End
+	case *ast.SuperIndex:
+		name, _ = node.Index.(*ast.LiteralString)
+		dot = ast.Location{Line: node.LocRange.Begin.Line, Column: node.LocRange.Begin.Column + len("super")}
+	default:
+		return ast.LocationRange{}, "", false
+	}
+	if name.LocRange.Begin.IsSet() {
+		return name.LocRange, quoteFieldName(newName), true
+	}
+	// The dot is replaced along with the name
+	return ast.LocationRange{FileName: nameRange.FileName, Begin: dot, End: nameRange.End}, "[" + quoteFieldName(newName) + "]", true
+}
+
+// findFieldNameRange finds the name of the field defined at the given range
+func findFieldNameRange(processor *processing.Processor, definition processing.ObjectRange) (ast.LocationRange, bool) {
+	field, ok := findField(processor, definition)
+	if !ok {
+		return ast.LocationRange{}, false
+	}
+	return processing.FieldNameRange(field)
+}
+
+// findField finds the field defined at the given range
+func findField(processor *processing.Processor, definition processing.ObjectRange) (ast.DesugaredObjectField, bool) {
+	root, err := processor.GetAST(definition.Filename)
+	if err != nil {
+		return ast.DesugaredObjectField{}, false
+	}
+	stack, err := processing.FindNodeByPosition(root, definition.FullRange.Begin)
+	if err != nil {
+		return ast.DesugaredObjectField{}, false
+	}
+	for !stack.IsEmpty() {
+		object, ok := stack.Pop().(*ast.DesugaredObject)
+		if !ok {
+			continue
+		}
+		for _, field := range object.Fields {
+			if field.LocRange.Begin == definition.FullRange.Begin && field.LocRange.End == definition.FullRange.End {
+				return field, true
+			}
+		}
+	}
+	return ast.DesugaredObjectField{}, false
+}
+
+// workspaceEditBuilder collects text edits replacing ranges with a new name, ignoring duplicates
+type workspaceEditBuilder struct {
+	// converter returns the converter of the positions of the edited files
+	converter func(uri protocol.DocumentURI) *position.Converter
+	changes   map[string][]protocol.TextEdit
+	seen      map[string]bool
+}
+
+func newWorkspaceEditBuilder(converter func(uri protocol.DocumentURI) *position.Converter) *workspaceEditBuilder {
+	return &workspaceEditBuilder{
+		converter: converter,
+		changes:   map[string][]protocol.TextEdit{},
+		seen:      map[string]bool{},
+	}
+}
+
+func (b *workspaceEditBuilder) add(filename string, locRange ast.LocationRange, newText string) {
+	documentURI := protocol.URIFromPath(utils.AbsFilename(filename))
+	uri := string(documentURI)
+	editRange := b.converter(documentURI).RangeASTToProtocol(locRange)
+	key := fmt.Sprintf("%s:%v", uri, editRange)
+	if b.seen[key] {
+		return
+	}
+	b.seen[key] = true
+	b.changes[uri] = append(b.changes[uri], protocol.TextEdit{Range: editRange, NewText: newText})
+}
+
+func (b *workspaceEditBuilder) build() *protocol.WorkspaceEdit {
+	return &protocol.WorkspaceEdit{Changes: b.changes}
+}
@@ -0,0 +1,270 @@
+package server
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/jdbaldry/go-language-server-protocol/lsp/protocol"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type renameTestCase struct {
+	name     string
+	filename string
+	position protocol.Position
+
+	// Ranges to edit, by filename
+	expected map[string][]protocol.Range
+}
+
+var renameTestCases = []renameTestCase{
+	{
+		name:     "local function from usage",
+		filename: "testdata/rename-lib.libsonnet",
+		position: protocol.Position{Line: 3, Character: 9},
+		expected: map[string][]protocol.Range{
+			"testdata/rename-lib.libsonnet": {
+				{Start: protocol.Position{Line: 0, Character: 6}, End: protocol.Position{Line: 0, Character: 12}},
+				{Start: protocol.Position{Line: 3, Character: 9}, End: protocol.Position{Line: 3, Character: 15}},
+				{Start: protocol.Position{Line: 4, Character: 18}, End: protocol.Position{Line: 4, Character: 24}},
+			},
+		},
+	},
+	{
+		name:     "object local shadowed by a parameter",
+		filename: "testdata/rename-lib.libsonnet",
+		position: protocol.Position{Line: 2, Character: 8},
+		expected: map[string][]protocol.Range{
+			"testdata/rename-lib.libsonnet": {
+				{Start: protocol.Position{Line: 2, Character: 8}, End: protocol.Position{Line: 2, Character: 13}},
+				{Start: protocol.Position{Line: 3, Character: 16}, End: protocol.Position{Line: 3, Character: 21}},
+			},
+		},
+	},
+	{
+		name:     "parameter of a method",
+		filename: "testdata/rename-lib.libsonnet",
+		position: protocol.Position{Line: 4, Character: 26},
+		expected: map[string][]protocol.Range{
+			"testdata/rename-lib.libsonnet": {
+				{Start: protocol.Position{Line: 4, Character: 9}, End: protocol.Position{Line: 4, Character: 14}},
+				{Start: protocol.Position{Line: 4, Character: 25}, End: protocol.Position{Line: 4, Character: 30}},
+			},
+		},
+	},
+	{
+		name:     "parameter of a local function",
+		filename: "testdata/rename-lib.libsonnet",
+		position: protocol.Position{Line: 0, Character: 14},
+		expected: map[string][]protocol.Range{
+			"testdata/rename-lib.libsonnet": {
+				{Start: protocol.Position{Line: 0, Character: 13}, End: protocol.Position{Line: 0, Character: 18}},
+				{Start: protocol.Position{Line: 0, Character: 22}, End: protocol.Position{Line: 0, Character: 27}},
+			},
+		},
+	},
+	{
+		name:     "field from its definition",
+		filename: "testdata/rename-lib.libsonnet",
+		position: protocol.Position{Line: 3, Character: 3},
+		expected: map[string][]protocol.Range{
+			"testdata/rename-lib.libsonnet": {
+				{Start: protocol.Position{Line: 3, Character: 2}, End: protocol.Position{Line: 3, Character: 7}},
+				{Start: protocol.Position{Line: 4, Character: 39}, End: protocol.Position{Line: 4, Character: 44}},
+				{Start: protocol.Position{Line: 6, Character: 23}, End: protocol.Position{Line: 6, Character: 28}},
+			},
+			"testdata/rename-main.jsonnet": {
+				{Start: protocol.Position{Line: 2, Character: 9}, End: protocol.Position{Line: 2, Character: 14}},
+			},
+		},
+	},
+	{
+		name:     "field from an importer",
+		filename: "testdata/rename-main.jsonnet",
+		position: protocol.Position{Line: 2, Character: 10},
+		expected: map[string][]protocol.Range{
+			"testdata/rename-lib.libsonnet": {
+				{Start: protocol.Position{Line: 3, Character: 2}, End: protocol.Position{Line: 3, Character: 7}},
+				{Start: protocol.Position{Line: 4, Character: 39}, End: protocol.Position{Line: 4, Character: 44}},
+				{Start: protocol.Position{Line: 6, Character: 23}, End: protocol.Position{Line: 6, Character: 28}},
+			},
+			"testdata/rename-main.jsonnet": {
+				{Start: protocol.Position{Line: 2, Character: 9}, End: protocol.Position{Line: 2, Character: 14}},
+			},
+		},
+	},
+}
+
+func TestRename(t *testing.T) {
+	for _, tc := range renameTestCases {
+		t.Run(tc.name, func(t *testing.T) {
+			server := NewServer("any", "test version", nil, Configuration{
+				JPaths: []string{"testdata"},
+			})
+			uri := serverOpenTestFile(t, server, tc.filename)
+
+			prepared, err := server.PrepareRename(context.Background(), &protocol.PrepareRenameParams{
+				TextDocumentPositionParams: protocol.TextDocumentPositionParams{
+					TextDocument: protocol.TextDocumentIdentifier{URI: uri},
+					Position:     tc.position,
+				},
+			})
+			require.NoError(t, err)
+			assert.Contains(t, tc.expected[tc.filename], *prepared)
+
+			response, err := server.Rename(context.Background(), &protocol.RenameParams{
+				TextDocument: protocol.TextDocumentIdentifier{URI: uri},
+				Position:     tc.position,
+				NewName:      "renamed",
+			})
+			require.NoError(t, err)
+
+			require.Len(t, response.Changes, len(tc.expected))
+			for filename, ranges := range tc.expected {
+				var expected []protocol.TextEdit
+				for _, r := range ranges {
+					expected = append(expected, protocol.TextEdit{Range: r, NewText: "renamed"})
+				}
+				assert.ElementsMatch(t, expected, response.Changes[string(absURI(t, filename))], filename)
+			}
+		})
+	}
+}
+
+func TestRenameFieldNotIdentifier(t *testing.T) {
+	editAt := func(line, begin, end uint32, newText string) protocol.TextEdit {
+		return protocol.TextEdit{
+			Range:   protocol.Range{Start: protocol.Position{Line: line, Character: begin}, End: protocol.Position{Line: line, Character: end}},
+			NewText: newText,
+		}
+	}
+	for _, tc := range []struct {
+		name     string
+		newName  string
+		expected map[string][]protocol.TextEdit
+	}{
+		{
+			name:    "keyword",
+			newName: "local",
+			expected: map[string][]protocol.TextEdit{
+				"testdata/rename-lib.libsonnet": {
+					editAt(3, 2, 7, "'local'"),
+					editAt(4, 38, 44, "['local']"),
+					editAt(6, 22, 29, "'local'"),
+				},
+				"testdata/rename-main.jsonnet": {
+					editAt(2, 8, 14, "['local']"),
+				},
+			},
+		},
+		{
+			name:    "quotes are escaped",
+			newName: "it's",
+			expected: map[string][]protocol.TextEdit{
+				"testdata/rename-lib.libsonnet": {
+					editAt(3, 2, 7, `'it\'s'`),
+					editAt(4, 38, 44, `['it\'s']`),
+					editAt(6, 22, 29, `'it\'s'`),
+				},
+				"testdata/rename-main.jsonnet": {
+					editAt(2, 8, 14, `['it\'s']`),
+				},
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			server := NewServer("any", "test version", nil, Configuration{
+				JPaths: []string{"testdata"},
+			})
+			uri := serverOpenTestFile(t, server, "testdata/rename-lib.libsonnet")
+
+			response, err := server.Rename(context.Background(), &protocol.RenameParams{
+				TextDocument: protocol.TextDocumentIdentifier{URI: uri},
+				Position:     protocol.Position{Line: 3, Character: 3},
+				NewName:      tc.newName,
+			})
+			require.NoError(t, err)
+
+			require.Len(t, response.Changes, len(tc.expected))
+			for filename, edits := range tc.expected {
+				assert.ElementsMatch(t, edits, response.Changes[string(absURI(t, filename))], filename)
+			}
+		})
+	}
+}
+
+func TestRenameFail(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		filename string
+		position protocol.Position
+		newName  string
+		expected string
+	}{
+		{
+			name:     "std",
+			filename: "testdata/rename-main.jsonnet",
+			position: protocol.Position{Line: 5, Character: 6},
+			newName:  "renamed",
+			expected: "symbols of the standard library are not supported",
+		},
+		{
+			name:     "std function",
+			filename: "testdata/rename-main.jsonnet",
+			position: protocol.Position{Line: 5, Character: 10},
+			newName:  "renamed",
+			expected: "symbols of the standard library are not supported",
+		},
+		{
+			name:     "self",
+			filename: "testdata/rename-lib.libsonnet",
+			position: protocol.Position{Line: 4, Character: 35},
+			newName:  "renamed",
+			expected: "self is not a user-defined symbol",
+		},
+		{
+			name:     "dollar",
+			filename: "testdata/dollar-simple.jsonnet",
+			position: protocol.Position{Line: 7, Character: 10},
+			newName:  "renamed",
+			expected: "$ is not a user-defined symbol",
+		},
+		{
+			name:     "computed field name",
+			filename: "testdata/computed-field-names.jsonnet",
+			position: protocol.Position{Line: 3, Character: 2},
+			newName:  "renamed",
+			expected: "computed field names are not supported",
+		},
+		{
+			name:     "invalid name of a parameter",
+			filename: "testdata/rename-lib.libsonnet",
+			position: protocol.Position{Line: 4, Character: 26},
+			newName:  "my-value",
+			expected: `JSON RPC invalid params: "my-value" is not a valid Jsonnet identifier`,
+		},
+		{
+			name:     "invalid new name",
+			filename: "testdata/rename-lib.libsonnet",
+			position: protocol.Position{Line: 0, Character: 8},
+			newName:  "local",
+			expected: `JSON RPC invalid params: "local" is not a valid Jsonnet identifier`,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			server := NewServer("any", "test version", nil, Configuration{
+				JPaths: []string{"testdata", filepath.Join(filepath.Dir(tc.filename), "vendor")},
+			})
+			uri := serverOpenTestFile(t, server, tc.filename)
+
+			_, err := server.Rename(context.Background(), &protocol.RenameParams{
+				TextDocument: protocol.TextDocumentIdentifier{URI: uri},
+				Position:     tc.position,
+				NewName:      tc.newName,
+			})
+			require.EqualError(t, err, tc.expected)
+		})
+	}
+}
@@ -0,0 +1,132 @@
+package server
+
+import (
+	"strings"
+)
+
+type textTokenKind int
+
+const (
+	lineCommentToken textTokenKind = iota
+	blockCommentToken
+	stringToken
+	wordToken
+)
+
+// textToken is a token found by scanning the text of a document, without parsing it.
+// Lines and columns are 0-based, columns are counted in bytes like in the AST
+type textToken struct {
+	kind textTokenKind
+	text string
+
+	line, column       int
+	endLine, endColumn int
+	// start and end are the byte offsets of the token in the text
+	start, end int
+
+	// firstOnLine is true if only whitespace precedes the token on its line
+	firstOnLine bool
+}
+
+// scanText splits Jsonnet text into comments, strings and words (identifiers and keywords).
+// Other characters are skipped. It doesn't need the text to be valid Jsonnet, so it can be used while the user is typing
+func scanText(text string) []textToken {
+	var tokens []textToken
+	line, lineStart := 0, 0
+	firstOnLine := true
+
+	column := func(i int) int {
+		return i - lineStart
+	}
+	// indexFrom returns the index of substr in text, starting at from. If it's not found, the end of the text is returned
+	indexFrom := func(from int, substr string) int {
+		if from > len(text) {
+			return len(text)
+		}
+		if end := strings.Index(text[from:], substr); end >= 0 {
+			return from + end
+		}
+		return len(text)
+	}
+	addToken := func(kind textTokenKind, start, end int) {
+		token := textToken{
+			kind:        kind,
+			text:        text[start:end],
+			line:        line,
+			column:      column(start),
+			start:       start,
+			end:         end,
+			firstOnLine: firstOnLine,
+		}
+		if newlines := strings.Count(token.text, "\n"); newlines > 0 {
+			line += newlines
+			lineStart = start + strings.LastIndexByte(token.text, '\n') + 1
+		}
+		token.endLine, token.endColumn = line, column(end)
+		tokens = append(tokens, token)
+	}
+
+	for i := 0; i < len(text); {
+		c := text[i]
+		start := i
+		switch {
+		case c == '\n':
+			line++
+			lineStart = i + 1
+			firstOnLine = true
+			i++
+			continue
+		case c == ' ' || c == '\t' || c == '\r':
+			i++
+			continue
+		case c == '#' || strings.HasPrefix(text[i:], "//"):
+			i = indexFrom(i, "\n")
+			addToken(lineCommentToken, start, i)
+		case strings.HasPrefix(text[i:], "/*"):
+			i = min(indexFrom(i+2, "*/")+2, len(text))
+			addToken(blockCommentToken, start, i)
+		case strings.HasPrefix(text[i:], "|||"):
+			i = min(indexFrom(i+3, "|||")+3, len(text))
+			addToken(stringToken, start, i)
+		case c == '\'' || c == '"' || (c == '@' && i+1 < len(text) && (text[i+1] == '\'' || text[i+1] == '"')):
+			verbatim := c == '@'
+			if verbatim {
+				i++
+			}
+			quote := text[i]
+			for i++; i < len(text); i++ {
+				if text[i] == '\\' && !verbatim {
+					i++
+					continue
+				}
+				if text[i] == quote {
+					// In verbatim strings, quotes are escaped by doubling them
+					if verbatim && i+1 < len(text) && text[i+1] == quote {
+						i++
+						continue
+					}
+					break
+				}
+			}
+			i = min(i+1, len(text))
+			addToken(stringToken, start, i)
+		case isIdentifierChar(c) || (c >= '0' && c <= '9'):
+			for i < len(text) && (isIdentifierChar(text[i]) || (text[i] >= '0' && text[i] <= '9')) {
+				i++
+			}
+			// Numbers are skipped
+			if !(c >= '0' && c <= '9') {
+				addToken(wordToken, start, i)
+			}
+		default:
+			i++
+		}
+		firstOnLine = false
+	}
+
+	return tokens
+}
+
+func isIdentifierChar(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
@@ -0,0 +1,370 @@
+package server
+
+import (
+	"context"
+	"sort"
+	"strconv"
+
+	"github.com/google/go-jsonnet/ast"
+	"github.com/google/go-jsonnet/toolutils"
+	"github.com/grafana/jsonnet-language-server/pkg/ast/processing"
+	"github.com/grafana/jsonnet-language-server/pkg/utils"
+	"github.com/jdbaldry/go-language-server-protocol/lsp/protocol"
+)
+
+// Semantic token types, the values are the indexes in the legend
+const (
+	tokenNamespace uint32 = iota
+	tokenVariable
+	tokenParameter
+	tokenProperty
+	tokenFunction
+	tokenKeyword
+)
+
+// Semantic token modifiers, the values are bit flags matching the indexes in the legend
+const (
+	modifierDeclaration uint32 = 1 << iota
+	modifierDefaultLibrary
+	modifierHidden
+	modifierPlusSuper
+)
+
+var semanticTokensLegend = protocol.SemanticTokensLegend{
+	TokenTypes:     []string{"namespace", "variable", "parameter", "property", "function", "keyword"},
+	TokenModifiers: []string{"declaration", "defaultLibrary", "hidden", "plusSuper"},
+}
+
+// semanticTokensResult is the last result sent for a document, used to compute deltas
+type semanticTokensResult struct {
+	resultID string
+	data     []uint32
+}
+
+type semanticToken struct {
+	line, column, length uint32
+	tokenType, modifiers uint32
+}
+
+func (s *Server) SemanticTokensFull(_ context.Context, params *protocol.SemanticTokensParams) (*protocol.SemanticTokens, error) {
+	tokens, err := s.semanticTokens(params.TextDocument.URI)
+	if err != nil {
+		return nil, utils.LogErrorf("SemanticTokensFull: %w", err)
+	}
+	return s.storeSemanticTokens(params.TextDocument.URI, encodeSemanticTokens(tokens)), nil
+}
+
+func (s *Server) SemanticTokensRange(_ context.Context, params *protocol.SemanticTokensRangeParams) (*protocol.SemanticTokens, error) {
+	tokens, err := s.semanticTokens(params.TextDocument.URI)
+	if err != nil {
+		return nil, utils.LogErrorf("SemanticTokensRange: %w", err)
+	}
+
+	var inRange []semanticToken
+	for _, token := range tokens {
+		if token.line < params.Range.Start.Line || token.line > params.Range.End.Line {
+			continue
+		}
+		if token.line == params.Range.Start.Line && token.column+token.length <= params.Range.Start.Character {
+			continue
+		}
+		if token.line == params.Range.End.Line && token.column >= params.Range.End.Character {
+			continue
+		}
+		inRange = append(inRange, token)
+	}
+
+	// Range results can't be used as a base for deltas, they have no result ID
+	return &protocol.SemanticTokens{Data: encodeSemanticTokens(inRange)}, nil
+}
+
+func (s *Server) SemanticTokensFullDelta(_ context.Context, params *protocol.SemanticTokensDeltaParams) (interface{}, error) {
+	tokens, err := s.semanticTokens(params.TextDocument.URI)
+	if err != nil {
+		return nil, utils.LogErrorf("SemanticTokensFullDelta: %w", err)
+	}
+
+	s.semanticTokensMutex.Lock()
+	previous, ok := s.semanticTokensResults[params.TextDocument.URI]
+	s.semanticTokensMutex.Unlock()
+
+	result := s.storeSemanticTokens(params.TextDocument.URI, encodeSemanticTokens(tokens))
+	if !ok || previous.resultID != params.PreviousResultID {
+		// The client's result is unknown, send everything
+		return result, nil
+	}
+
+	delta := &protocol.SemanticTokensDelta{ResultID: result.ResultID, Edits: []protocol.SemanticTokensEdit{}}
+	if edit, changed := semanticTokensEdit(previous.data, result.Data); changed {
+		delta.Edits = append(delta.Edits, edit)
+	}
+	return delta, nil
+}
+
+// storeSemanticTokens assigns a new result ID to the tokens and keeps them as the base for the next delta request
+func (s *Server) storeSemanticTokens(uri protocol.DocumentURI, data []uint32) *protocol.SemanticTokens {
+	s.semanticTokensMutex.Lock()
+	defer s.semanticTokensMutex.Unlock()
+
+	s.semanticTokensResultID++
+	resultID := strconv.Itoa(s.semanticTokensResultID)
+	s.semanticTokensResults[uri] = semanticTokensResult{resultID: resultID, data: data}
+
+	return &protocol.SemanticTokens{ResultID: resultID, Data: data}
+}
+
+// semanticTokensEdit returns a single edit replacing the part that differs between the old and new data
+func semanticTokensEdit(oldData, newData []uint32) (protocol.SemanticTokensEdit, bool) {
+	prefix := 0
+	for prefix < len(oldData) && prefix < len(newData) && oldData[prefix] == newData[prefix] {
+		prefix++
+	}
+	if prefix == len(oldData) && prefix == len(newData) {
+		return protocol.SemanticTokensEdit{}, false
+	}
+
+	suffix := 0
+	for suffix < len(oldData)-prefix && suffix < len(newData)-prefix && oldData[len(oldData)-1-suffix] == newData[len(newData)-1-suffix] {
+		suffix++
+	}
+
+	return protocol.SemanticTokensEdit{
+		Start:       uint32(prefix),
+		DeleteCount: uint32(len(oldData) - prefix - suffix),
+		Data:        newData[prefix : len(newData)-suffix],
+	}, true
+}
+
+// semanticTokens returns the sorted tokens of a document. Keywords are found in the current text,
+// while other tokens come from the last successfully parsed AST, ignoring the lines that changed since then
+func (s *Server) semanticTokens(uri protocol.DocumentURI) ([]semanticToken, error) {
+	doc, err := s.cache.Get(uri)
+	if err != nil {
+		return nil, err
+	}
+
+	var tokens []semanticToken
+	for _, token := range scanText(doc.Item.Text) {
+		if token.kind == wordToken && jsonnetKeywords[token.text] {
+			tokens = append(tokens, semanticToken{
+				line:      uint32(token.line),
+				column:    uint32(token.column),
+				length:    uint32(token.endColumn - token.column),
+				tokenType: tokenKeyword,
+			})
+		}
+	}
+
+	if doc.AST != nil {
+		builder := &semanticTokensBuilder{stdlib: map[string]bool{}}
+		for _, f := range s.stdlib {
+			builder.stdlib[f.Name] = true
+		}
+		builder.visit(doc.AST, nil)
+		for _, token := range builder.tokens {
+			if !doc.LinesChangedSinceAST[int(token.line)] {
+				tokens = append(tokens, token)
+			}
+		}
+	}
+
+	sort.SliceStable(tokens, func(i, j int) bool {
+		if tokens[i].line != tokens[j].line {
+			return tokens[i].line < tokens[j].line
+		}
+		return tokens[i].column < tokens[j].column
+	})
+
+	// Tokens can't overlap
+	var result []semanticToken
+	for _, token := range tokens {
+		if len(result) > 0 {
+			last := result[len(result)-1]
+			if last.line == token.line && last.column+last.length > token.column {
+				continue
+			}
+		}
+		result = append(result, token)
+	}
+
+	// The columns are counted in bytes until now, like in the AST
+	converter := s.converter(doc.Item.Text)
+	for i, token := range result {
+		tokenRange := converter.RangeASTToProtocol(ast.LocationRange{
+			Begin: ast.Location{Line: int(token.line) + 1, Column: int(token.column) + 1},
+			End:   ast.Location{Line: int(token.line) + 1, Column: int(token.column+token.length) + 1},
+		})
+		result[i].column, result[i].length = tokenRange.Start.Character, tokenRange.End.Character-tokenRange.Start.Character
+	}
+	return result, nil
+}
+
+// encodeSemanticTokens encodes sorted tokens with positions relative to the previous token
+func encodeSemanticTokens(tokens []semanticToken) []uint32 {
+	data := make([]uint32, 0, len(tokens)*5)
+	var line, column uint32
+	for _, token := range tokens {
+		if token.line != line {
+			column = 0
+		}
+		data = append(data, token.line-line, token.column-column, token.length, token.tokenType, token.modifiers)
+		line, column = token.line, token.column
+	}
+	return data
+}
+
+// semanticScope maps the variables in scope to their token type
+type semanticScope struct {
+	parent    *semanticScope
+	variables map[ast.Identifier]uint32
+}
+
+func (s *semanticScope) lookup(id ast.Identifier) (uint32, bool) {
+	for scope := s; scope != nil; scope = scope.parent {
+		if tokenType, ok := scope.variables[id]; ok {
+			return tokenType, true
+		}
+	}
+	return 0, false
+}
+
+type semanticTokensBuilder struct {
+	stdlib map[string]bool
+	tokens []semanticToken
+}
+
+func (b *semanticTokensBuilder) add(locRange ast.LocationRange, tokenType, modifiers uint32) {
+	// Tokens can't span multiple lines
+	if !locRange.Begin.IsSet() || locRange.Begin.Line != locRange.End.Line || locRange.End.Column <= locRange.Begin.Column {
+		return
+	}
+	b.tokens = append(b.tokens, semanticToken{
+		line:      uint32(locRange.Begin.Line - 1),
+		column:    uint32(locRange.Begin.Column - 1),
+		length:    uint32(locRange.End.Column - locRange.Begin.Column),
+		tokenType: tokenType,
+		modifiers: modifiers,
+	})
+}
+
+func bindTokenType(bind ast.LocalBind) uint32 {
+	if _, ok := bind.Body.(*ast.Function); ok {
+		return tokenFunction
+	}
+	return tokenVariable
+}
+
+func (b *semanticTokensBuilder) visit(node ast.Node, scope *semanticScope) {
+	switch node := node.(type) {
+	case nil:
+		return
+	case *ast.Local:
+		scope = &semanticScope{parent: scope, variables: map[ast.Identifier]uint32{}}
+		for _, bind := range node.Binds {
+			scope.variables[bind.Variable] = bindTokenType(bind)
+		}
+		for _, bind := range node.Binds {
+			b.add(processing.LocalBindToRange(bind).SelectionRange, bindTokenType(bind), modifierDeclaration)
+			b.visit(bind.Body, scope)
+		}
+		b.visit(node.Body, scope)
+	case *ast.DesugaredObject:
+		scope = &semanticScope{parent: scope, variables: map[ast.Identifier]uint32{}}
+		for _, bind := range node.Locals {
+			scope.variables[bind.Variable] = bindTokenType(bind)
+		}
+		for _, bind := range node.Locals {
+			if bind.Variable != "$" {
+				b.add(processing.LocalBindToRange(bind).SelectionRange, bindTokenType(bind), modifierDeclaration)
+			}
+			b.visit(bind.Body, scope)
+		}
+		for _, field := range node.Fields {
+			// Quoted and computed field names are left to the client's highlighting of strings and expressions
+			if nameRange, ok := processing.FieldNameRange(field); ok && !field.Name.Loc().Begin.IsSet() {
+				modifiers := modifierDeclaration
+				if field.Hide == ast.ObjectFieldHidden {
+					modifiers |= modifierHidden
+				}
+				if field.PlusSuper {
+					modifiers |= modifierPlusSuper
+				}
+				b.add(nameRange, tokenProperty, modifiers)
+			} else {
+				b.visit(field.Name, scope)
+			}
+			b.visit(field.Body, scope)
+		}
+		for _, assert := range node.Asserts {
+			b.visit(assert, scope)
+		}
+	case *ast.Function:
+		scope = &semanticScope{parent: scope, variables: map[ast.Identifier]uint32{}}
+		for _, param := range node.Parameters {
+			scope.variables[param.Name] = tokenParameter
+		}
+		for _, param := range node.Parameters {
+			if param.LocRange.Begin.IsSet() {
+				b.add(processing.ParameterToRange(param).SelectionRange, tokenParameter, modifierDeclaration)
+			}
+			b.visit(param.DefaultArg, scope)
+		}
+		b.visit(node.Body, scope)
+	case *ast.Var:
+		switch node.Id {
+		case "$":
+			b.add(node.LocRange, tokenKeyword, 0)
+		case "std", "$std":
+			if tokenType, ok := scope.lookup(node.Id); ok {
+				b.add(node.LocRange, tokenType, 0)
+			} else {
+				b.add(node.LocRange, tokenNamespace, modifierDefaultLibrary)
+			}
+		default:
+			if tokenType, ok := scope.lookup(node.Id); ok {
+				b.add(node.LocRange, tokenType, 0)
+			}
+		}
+	case *ast.Index:
+		b.visit(node.Target, scope)
+		// Only `foo.bar` indexes, `foo['bar']` is a string
+		if index, ok := node.Index.(*ast.LiteralString); ok && !index.LocRange.Begin.IsSet() {
+			if nameRange, ok := processing.IndexNameRange(node); ok {
+				tokenType, modifiers := tokenProperty, uint32(0)
+				if target, ok := node.Target.(*ast.Var); ok && target.Id == "std" && scope.isStd() {
+					modifiers = modifierDefaultLibrary
+					if b.stdlib[index.Value] {
+						tokenType = tokenFunction
+					}
+				}
+				b.add(nameRange, tokenType, modifiers)
+			}
+		} else {
+			b.visit(node.Index, scope)
+		}
+	case *ast.SuperIndex:
+		if index, ok := node.Index.(*ast.LiteralString); ok && !index.LocRange.Begin.IsSet() {
+			if nameRange, ok := processing.IndexNameRange(node); ok {
+				b.add(nameRange, tokenProperty, 0)
+			}
+		} else {
+			b.visit(node.Index, scope)
+		}
+	case *ast.Import:
+		b.add(node.File.LocRange, tokenNamespace, 0)
+	case *ast.ImportStr:
+		b.add(node.File.LocRange, tokenNamespace, 0)
+	case *ast.ImportBin:
+		b.add(node.File.LocRange, tokenNamespace, 0)
+	default:
+		for _, child := range toolutils.Children(node) {
+			b.visit(child, scope)
+		}
+	}
+}
+
+// isStd returns true if `std` refers to the standard library, i.e. it wasn't redefined by the user
+func (s *semanticScope) isStd() bool {
+	_, redefined := s.lookup("std")
+	return !redefined
+}
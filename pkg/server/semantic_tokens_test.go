@@ -0,0 +1,167 @@
+package server
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/grafana/jsonnet-language-server/pkg/stdlib"
+	"github.com/jdbaldry/go-language-server-protocol/lsp/protocol"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type decodedSemanticToken struct {
+	line, character uint32
+	text            string
+	tokenType       string
+	modifiers       string
+}
+
+// decodeSemanticTokens converts the relative positions and legend indexes of semantic tokens to readable tokens
+func decodeSemanticTokens(t *testing.T, text string, data []uint32) []decodedSemanticToken {
+	t.Helper()
+	require.Zero(t, len(data)%5)
+
+	lines := strings.Split(text, "\n")
+	var tokens []decodedSemanticToken
+	var line, character uint32
+	for i := 0; i < len(data); i += 5 {
+		if data[i] > 0 {
+			character = 0
+		}
+		line += data[i]
+		character += data[i+1]
+
+		var modifiers []string
+		for bit, modifier := range semanticTokensLegend.TokenModifiers {
+			if data[i+4]&(1<<bit) != 0 {
+				modifiers = append(modifiers, modifier)
+			}
+		}
+		tokens = append(tokens, decodedSemanticToken{
+			line:      line,
+			character: character,
+			text:      lines[line][character : character+data[i+2]],
+			tokenType: semanticTokensLegend.TokenTypes[data[i+3]],
+			modifiers: strings.Join(modifiers, ","),
+		})
+	}
+	return tokens
+}
+
+var semanticTokensExpected = []decodedSemanticToken{
+	{0, 0, "local", "keyword", ""},
+	{0, 6, "lib", "variable", "declaration"},
+	{0, 12, "import", "keyword", ""},
+	{0, 19, "'semantic-tokens-lib.libsonnet'", "namespace", ""},
+	{1, 0, "local", "keyword", ""},
+	{1, 6, "add", "function", "declaration"},
+	{1, 10, "a", "parameter", "declaration"},
+	{1, 13, "b", "parameter", "declaration"},
+	{1, 20, "a", "parameter", ""},
+	{1, 24, "b", "parameter", ""},
+	{3, 2, "visible", "property", "declaration"},
+	{3, 11, "add", "function", ""},
+	{4, 2, "hidden", "property", "declaration,hidden"},
+	{4, 11, "std", "namespace", "defaultLibrary"},
+	{4, 15, "length", "function", "defaultLibrary"},
+	{5, 2, "merged", "property", "declaration,plusSuper"},
+	{5, 13, "x", "property", "declaration"},
+	{5, 16, "$", "keyword", ""},
+	{5, 18, "visible", "property", ""},
+	{6, 12, "lib", "variable", ""},
+	{6, 16, "value", "property", ""},
+}
+
+func TestSemanticTokensFull(t *testing.T) {
+	server := testServer(t, []stdlib.Function{{Name: "length"}})
+	uri := serverOpenTestFile(t, server, "testdata/semantic-tokens.jsonnet")
+	doc, err := server.cache.Get(uri)
+	require.NoError(t, err)
+
+	result, err := server.SemanticTokensFull(context.Background(), &protocol.SemanticTokensParams{
+		TextDocument: protocol.TextDocumentIdentifier{URI: uri},
+	})
+	require.NoError(t, err)
+	assert.NotEmpty(t, result.ResultID)
+	assert.Equal(t, semanticTokensExpected, decodeSemanticTokens(t, doc.Item.Text, result.Data))
+}
+
+func TestSemanticTokensRange(t *testing.T) {
+	server := testServer(t, []stdlib.Function{{Name: "length"}})
+	uri := serverOpenTestFile(t, server, "testdata/semantic-tokens.jsonnet")
+	doc, err := server.cache.Get(uri)
+	require.NoError(t, err)
+
+	result, err := server.SemanticTokensRange(context.Background(), &protocol.SemanticTokensRangeParams{
+		TextDocument: protocol.TextDocumentIdentifier{URI: uri},
+		Range: protocol.Range{
+			Start: protocol.Position{Line: 4, Character: 12},
+			End:   protocol.Position{Line: 5, Character: 13},
+		},
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, semanticTokensExpected[13:16], decodeSemanticTokens(t, doc.Item.Text, result.Data))
+}
+
+func TestSemanticTokensFullDelta(t *testing.T) {
+	server := testServer(t, []stdlib.Function{{Name: "length"}})
+	uri := serverOpenTestFile(t, server, "testdata/semantic-tokens.jsonnet")
+	doc, err := server.cache.Get(uri)
+	require.NoError(t, err)
+
+	full, err := server.SemanticTokensFull(context.Background(), &protocol.SemanticTokensParams{
+		TextDocument: protocol.TextDocumentIdentifier{URI: uri},
+	})
+	require.NoError(t, err)
+
+	// Unchanged document, no edits
+	result, err := server.SemanticTokensFullDelta(context.Background(), &protocol.SemanticTokensDeltaParams{
+		TextDocument:     protocol.TextDocumentIdentifier{URI: uri},
+		PreviousResultID: full.ResultID,
+	})
+	require.NoError(t, err)
+	delta, ok := result.(*protocol.SemanticTokensDelta)
+	require.True(t, ok, "expected a delta, got %T", result)
+	assert.NotEqual(t, full.ResultID, delta.ResultID)
+	assert.Empty(t, delta.Edits)
+
+	// Add a field, the edit should only contain its token
+	newText := strings.Replace(doc.Item.Text, "  'quoted'", "  added: 1,\n  'quoted'", 1)
+	require.NoError(t, server.DidChange(context.Background(), &protocol.DidChangeTextDocumentParams{
+		TextDocument: protocol.VersionedTextDocumentIdentifier{
+			TextDocumentIdentifier: protocol.TextDocumentIdentifier{URI: uri},
+			Version:                doc.Item.Version + 1,
+		},
+		ContentChanges: []protocol.TextDocumentContentChangeEvent{{Text: newText}},
+	}))
+	result, err = server.SemanticTokensFullDelta(context.Background(), &protocol.SemanticTokensDeltaParams{
+		TextDocument:     protocol.TextDocumentIdentifier{URI: uri},
+		PreviousResultID: delta.ResultID,
+	})
+	require.NoError(t, err)
+	delta, ok = result.(*protocol.SemanticTokensDelta)
+	require.True(t, ok, "expected a delta, got %T", result)
+	require.Len(t, delta.Edits, 1)
+
+	// Applying the edit to the previous data gives the same result as a full request
+	edit := delta.Edits[0]
+	edited := append(append(append([]uint32{}, full.Data[:edit.Start]...), edit.Data...), full.Data[edit.Start+edit.DeleteCount:]...)
+	newFull, err := server.SemanticTokensFull(context.Background(), &protocol.SemanticTokensParams{
+		TextDocument: protocol.TextDocumentIdentifier{URI: uri},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, newFull.Data, edited)
+
+	// Unknown previous result, all tokens are returned
+	result, err = server.SemanticTokensFullDelta(context.Background(), &protocol.SemanticTokensDeltaParams{
+		TextDocument:     protocol.TextDocumentIdentifier{URI: uri},
+		PreviousResultID: "unknown",
+	})
+	require.NoError(t, err)
+	tokens, ok := result.(*protocol.SemanticTokens)
+	require.True(t, ok, "expected tokens, got %T", result)
+	assert.Equal(t, newFull.Data, tokens.Data)
+}
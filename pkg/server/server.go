@@ -3,12 +3,15 @@ package server
 import (
 	"context"
 	"path/filepath"
-	"strings"
+	"runtime"
+	"slices"
 	"sync"
+	"time"
 
 	"github.com/google/go-jsonnet"
 	"github.com/google/go-jsonnet/ast"
 	"github.com/grafana/jsonnet-language-server/pkg/cache"
+	position "github.com/grafana/jsonnet-language-server/pkg/position_conversion"
 	"github.com/grafana/jsonnet-language-server/pkg/stdlib"
 	"github.com/grafana/jsonnet-language-server/pkg/utils"
 	tankaJsonnet "github.com/grafana/tanka/pkg/jsonnet/implementations/goimpl"
@@ -31,9 +34,27 @@ func NewServer(name, version string, client protocol.ClientCloser, configuration
 		client:        client,
 		configuration: configuration,
 
-		diagQueue: make(map[protocol.DocumentURI]struct{}),
+		positionEncoding: position.UTF16,
+
+		evalRunning:             make(map[protocol.DocumentURI]*runningVM),
+		evalAbandonedReturned:   make(chan struct{}),
+		maxAbandonedEvaluations: runtime.NumCPU(),
+
+		importedDiags: make(map[protocol.DocumentURI]map[protocol.DocumentURI][]protocol.Diagnostic),
+
+		dependentsPending: make(map[protocol.DocumentURI]struct{}),
+
+		diagResults: make(map[protocol.DocumentURI]diagnosticsResult),
+
+		semanticTokensResults: make(map[protocol.DocumentURI]semanticTokensResult),
+
+		evalHints: make(map[protocol.DocumentURI]*evaluatedLocals),
+
+		workspaceSymbols: newWorkspaceSymbolIndex(),
 	}
 
+	server.diagnostics = newDiagnosticsScheduler(runtime.NumCPU(), server.diagnose)
+
 	return server
 }
 
@@ -47,13 +68,76 @@ type Server struct {
 
 	configuration Configuration
 
+	// Encoding of the characters of the protocol positions, negotiated on initialization
+	positionEncoding position.Encoding
+
 	// Diagnostics
-	diagMutex   sync.RWMutex
-	diagQueue   map[protocol.DocumentURI]struct{}
-	diagRunning sync.Map
+	diagnostics  *diagnosticsScheduler
+	publishMutex sync.Mutex
+	// Cancellation of the running evaluations, by document, and the VMs that didn't return yet.
+	// The VMs of aborted evaluations can't be interrupted, a single one runs for each document, and their number is capped
+	evalCancels             sync.Map
+	evalRunningMutex        sync.Mutex
+	evalRunning             map[protocol.DocumentURI]*runningVM
+	evalAbandoned           int
+	evalAbandonedReturned   chan struct{}
+	maxAbandonedEvaluations int
+
+	// Evaluation errors reported in imported files, by imported file and by importing file
+	importedDiagsMutex sync.Mutex
+	importedDiags      map[protocol.DocumentURI]map[protocol.DocumentURI][]protocol.Diagnostic
+
+	// Diagnostics pulled by the client, when it supports it. They're pushed otherwise
+	pullDiagnosticsSupport    bool
+	diagnosticsRefreshSupport bool
+	diagResultsMutex          sync.Mutex
+	diagResults               map[protocol.DocumentURI]diagnosticsResult
+	diagResultID              int
+	diagRefreshTimer          *time.Timer
+	// Entrypoints of the workspace folders, whose diagnostics are pulled. They're found again when files are created or deleted
+	entrypointsMutex sync.Mutex
+	entrypoints      []string
+
+	// Files whose open importers are diagnosed again once the changes settle
+	dependentsMutex   sync.Mutex
+	dependentsPending map[protocol.DocumentURI]struct{}
+	dependentsTimer   *time.Timer
+
+	// Semantic tokens, kept to compute deltas
+	semanticTokensMutex    sync.Mutex
+	semanticTokensResults  map[protocol.DocumentURI]semanticTokensResult
+	semanticTokensResultID int
+
+	// Values of the top-level locals shown as inlay hints, evaluated in the background
+	evalHintsMutex          sync.Mutex
+	evalHints               map[protocol.DocumentURI]*evaluatedLocals
+	inlayHintRefreshSupport bool
+
+	// Workspace symbols, indexed from the workspace folders and the JPaths
+	workspaceRoots   []string
+	workspaceSymbols *workspaceSymbolIndex
+
+	// Whether the client supports the workspace/codeLens/refresh request, and the pending refresh
+	codeLensRefreshSupport bool
+	codeLensRefreshMutex   sync.Mutex
+	codeLensRefreshTimer   *time.Timer
+	// Whether the client supports the dynamic registration of file watchers
+	watchedFilesRegistrationSupport bool
+	// Whether the client can create files in workspace edits
+	createFileSupport bool
 }
 
 func (s *Server) getVM(path string) *jsonnet.VM {
+	return s.makeVM(path, false)
+}
+
+// getDiagnosticsVM returns a VM importing the open documents with their unsaved text, so that the diagnostics of the
+// documents importing a changed one are up to date
+func (s *Server) getDiagnosticsVM(path string) *jsonnet.VM {
+	return s.makeVM(path, true)
+}
+
+func (s *Server) makeVM(path string, importOpenDocuments bool) *jsonnet.VM {
 	var vm *jsonnet.VM
 	if s.configuration.ResolvePathsWithTanka {
 		jpath, _, _, err := jpath.Resolve(path, false)
@@ -63,14 +147,24 @@ func (s *Server) getVM(path string) *jsonnet.VM {
 			jpath = append(s.configuration.JPaths, filepath.Dir(path))
 		}
 		vm = tankaJsonnet.MakeRawVM(jpath, nil, nil, 0)
+		if importOpenDocuments {
+			// Tanka's importer isn't exported, the files are imported through another VM using it
+			vm.Importer(newOpenDocumentsImporter(vmImporter{tankaJsonnet.MakeRawVM(jpath, nil, nil, 0)}, s.cache))
+		}
 	} else {
 		// nolint: gocritic
 		jpath := append(s.configuration.JPaths, filepath.Dir(path))
 		vm = jsonnet.MakeVM()
-		importer := &jsonnet.FileImporter{JPaths: jpath}
+		var importer jsonnet.Importer = &jsonnet.FileImporter{JPaths: jpath}
+		if importOpenDocuments {
+			importer = newOpenDocumentsImporter(importer, s.cache)
+		}
 		vm.Importer(importer)
 	}
 
+	if s.configuration.MaxStack > 0 {
+		vm.MaxStack = s.configuration.MaxStack
+	}
 	resetExtVars(vm, s.configuration.ExtVars, s.configuration.ExtCode)
 	return vm
 }
@@ -78,31 +172,40 @@ func (s *Server) getVM(path string) *jsonnet.VM {
 func (s *Server) DidChange(_ context.Context, params *protocol.DidChangeTextDocumentParams) error {
 	defer s.queueDiagnostics(params.TextDocument.URI)
 
-	doc, err := s.cache.Get(params.TextDocument.URI)
+	cached, err := s.cache.Get(params.TextDocument.URI)
 	if err != nil {
 		return utils.LogErrorf("DidChange: %s: %w", errorRetrievingDocument, err)
 	}
+	// The cached document may be read by the diagnostics at the same time, the change is applied to a copy
+	updated := *cached
+	doc := &updated
 
 	if params.TextDocument.Version > doc.Item.Version && len(params.ContentChanges) != 0 {
-		oldText := doc.Item.Text
-		doc.Item.Text = params.ContentChanges[len(params.ContentChanges)-1].Text
+		// The evaluation of the previous version is outdated
+		s.cancelEvaluation(params.TextDocument.URI)
+		// The importers read the open documents from the cache, they're diagnosed again once the change is cached
+		defer s.queueDependentDiagnostics(params.TextDocument.URI)
+
+		text, changedLines, err := applyContentChanges(doc.Item.Text, doc.LinesChangedSinceAST, params.ContentChanges, s.positionEncoding)
+		if err != nil {
+			return utils.LogErrorf("DidChange: %w", err)
+		}
+		doc.Item.Text = text
+		doc.Item.Version = params.TextDocument.Version
 
 		var ast ast.Node
 		ast, doc.Err = jsonnet.SnippetToAST(doc.Item.URI.SpanURI().Filename(), doc.Item.Text)
+		doc.EvalErr = false
 
 		// If the AST parsed correctly, set it on the document
-		// Otherwise, keep the old AST, and find all the lines that have changed since last AST
+		// Otherwise, keep the old AST, and track the lines that have changed since last AST
 		if ast != nil {
 			doc.AST = ast
 			doc.LinesChangedSinceAST = map[int]bool{}
+			s.updateWorkspaceSymbols(doc.Item.URI, doc.Item.Text, ast)
+			s.refreshCodeLenses()
 		} else {
-			splitOldText := strings.Split(oldText, "\n")
-			splitNewText := strings.Split(doc.Item.Text, "\n")
-			for index, oldLine := range splitOldText {
-				if index >= len(splitNewText) || oldLine != splitNewText[index] {
-					doc.LinesChangedSinceAST[index] = true
-				}
-			}
+			doc.LinesChangedSinceAST = changedLines
 		}
 	}
 
@@ -115,14 +218,59 @@ func (s *Server) DidOpen(_ context.Context, params *protocol.DidOpenTextDocument
 	doc := &cache.Document{Item: params.TextDocument, LinesChangedSinceAST: map[int]bool{}}
 	if params.TextDocument.Text != "" {
 		doc.AST, doc.Err = jsonnet.SnippetToAST(params.TextDocument.URI.SpanURI().Filename(), params.TextDocument.Text)
+		s.updateWorkspaceSymbols(doc.Item.URI, doc.Item.Text, doc.AST)
 	}
 	return s.cache.Put(doc)
 }
 
-func (s *Server) Initialize(_ context.Context, _ *protocol.ParamInitialize) (*protocol.InitializeResult, error) {
+func (s *Server) DidClose(_ context.Context, params *protocol.DidCloseTextDocumentParams) error {
+	uri := params.TextDocument.URI
+	s.cancelEvaluation(uri)
+	s.cache.Remove(uri)
+
+	s.diagnostics.cancel(uri)
+
+	s.semanticTokensMutex.Lock()
+	delete(s.semanticTokensResults, uri)
+	s.semanticTokensMutex.Unlock()
+	s.forgetEvaluatedLocals(uri)
+
+	// The diagnostics of a closed document are cleared, along with the errors it reported in the files it imports
+	if s.pullDiagnosticsSupport {
+		s.forgetDiagnostics(uri)
+	} else if err := s.client.PublishDiagnostics(context.Background(), &protocol.PublishDiagnosticsParams{URI: uri, Diagnostics: []protocol.Diagnostic{}}); err != nil {
+		log.Errorf("DidClose: unable to clear the diagnostics: %v\n", err)
+	}
+	for _, target := range s.setImportedDiags(uri, nil) {
+		if targetDoc, err := s.cache.Get(target); err == nil {
+			s.publishDiagnostics(target, targetDoc.Diagnostics)
+		}
+	}
+
+	// Unsaved changes are discarded, the symbols come from the file on disk again, and so do the importers
+	s.updateWorkspaceSymbolsFromDisk(uri)
+	s.queueDependentDiagnostics(uri)
+	return nil
+}
+
+func (s *Server) DidSave(_ context.Context, params *protocol.DidSaveTextDocumentParams) error {
+	s.cache.Invalidate(params.TextDocument.URI)
+	s.queueDiagnostics(params.TextDocument.URI)
+	// The importers of the saved file are diagnosed again
+	s.queueDependentDiagnostics(params.TextDocument.URI)
+	return nil
+}
+
+func (s *Server) Initialize(ctx context.Context, params *protocol.ParamInitialize) (*protocol.InitializeResult, error) {
 	log.Infof("Initializing %s version %s", s.name, s.version)
 
-	s.diagnosticsLoop()
+	s.setWorkspaceRoots(params)
+	s.setNonstandardCapabilities(ctx)
+	s.codeLensRefreshSupport = params.Capabilities.Workspace.CodeLens.RefreshSupport
+	s.watchedFilesRegistrationSupport = params.Capabilities.Workspace.DidChangeWatchedFiles.DynamicRegistration
+	if workspaceEdit := params.Capabilities.Workspace.WorkspaceEdit; workspaceEdit != nil {
+		s.createFileSupport = workspaceEdit.DocumentChanges && slices.Contains(workspaceEdit.ResourceOperations, protocol.Create)
+	}
 
 	var err error
 
@@ -133,22 +281,45 @@ func (s *Server) Initialize(_ context.Context, _ *protocol.ParamInitialize) (*pr
 		}
 	}
 
+	s.diagnostics.startWorkers()
+
 	return &protocol.InitializeResult{
 		Capabilities: protocol.ServerCapabilities{
-			CompletionProvider:         protocol.CompletionOptions{TriggerCharacters: []string{"."}},
-			HoverProvider:              true,
-			DefinitionProvider:         true,
-			DocumentFormattingProvider: true,
-			DocumentSymbolProvider:     true,
-			ExecuteCommandProvider:     protocol.ExecuteCommandOptions{Commands: []string{}},
+			CallHierarchyProvider:           true,
+			CodeLensProvider:                protocol.CodeLensOptions{ResolveProvider: true},
+			CodeActionProvider:              protocol.CodeActionOptions{CodeActionKinds: []protocol.CodeActionKind{protocol.QuickFix, protocol.RefactorExtract, protocol.RefactorInline}},
+			CompletionProvider:              protocol.CompletionOptions{TriggerCharacters: []string{"."}},
+			HoverProvider:                   true,
+			SignatureHelpProvider:           protocol.SignatureHelpOptions{TriggerCharacters: []string{"(", ","}},
+			DefinitionProvider:              true,
+			DocumentFormattingProvider:      true,
+			DocumentRangeFormattingProvider: true,
+			DocumentOnTypeFormattingProvider: protocol.DocumentOnTypeFormattingOptions{
+				FirstTriggerCharacter: "}",
+				MoreTriggerCharacter:  []string{"]", "\n"},
+			},
+			DocumentHighlightProvider: true,
+			DocumentLinkProvider:      protocol.DocumentLinkOptions{ResolveProvider: true},
+			DocumentSymbolProvider:    true,
+			ExecuteCommandProvider:    protocol.ExecuteCommandOptions{Commands: []string{}},
 			TextDocumentSync: &protocol.TextDocumentSyncOptions{
-				Change:    protocol.Full,
+				Change:    protocol.Incremental,
 				OpenClose: true,
 				Save: protocol.SaveOptions{
 					IncludeText: false,
 				},
 			},
-			ReferencesProvider: true,
+			FoldingRangeProvider:    true,
+			ReferencesProvider:      true,
+			RenameProvider:          protocol.RenameOptions{PrepareProvider: true},
+			WorkspaceSymbolProvider: true,
+			SemanticTokensProvider: protocol.SemanticTokensOptions{
+				Legend: semanticTokensLegend,
+				Range:  true,
+				Full: struct {
+					Delta bool `json:"delta"`
+				}{Delta: true},
+			},
 		},
 		ServerInfo: struct {
 			Name    string `json:"name"`
@@ -159,3 +330,8 @@ func (s *Server) Initialize(_ context.Context, _ *protocol.ParamInitialize) (*pr
 		},
 	}, nil
 }
+
+func (s *Server) Initialized(context.Context, *protocol.InitializedParams) error {
+	s.registerFileWatchers()
+	return nil
+}
@@ -0,0 +1,218 @@
+package server
+
+import (
+	"context"
+	"strings"
+
+	"github.com/google/go-jsonnet/ast"
+	"github.com/grafana/jsonnet-language-server/pkg/ast/processing"
+	"github.com/grafana/jsonnet-language-server/pkg/nodestack"
+	"github.com/grafana/jsonnet-language-server/pkg/utils"
+	"github.com/jdbaldry/go-language-server-protocol/lsp/protocol"
+	log "github.com/sirupsen/logrus"
+)
+
+func (s *Server) SignatureHelp(_ context.Context, params *protocol.SignatureHelpParams) (*protocol.SignatureHelp, error) {
+	doc, err := s.cache.Get(params.TextDocument.URI)
+	if err != nil {
+		return nil, utils.LogErrorf("SignatureHelp: %s: %w", errorRetrievingDocument, err)
+	}
+
+	// Signature help triggers while typing. Throwing an error on each request is noisy
+	if doc.AST == nil {
+		log.Debugf("SignatureHelp: document was never successfully parsed")
+		return nil, nil
+	}
+	if doc.LinesChangedSinceAST[int(params.Position.Line)] {
+		log.Debugf("SignatureHelp: document line %d was changed since last successful parse", params.Position.Line)
+		return nil, nil
+	}
+
+	location := s.converter(doc.Item.Text).ProtocolToAST(params.Position)
+	stack, err := processing.FindNodeByPosition(doc.AST, location)
+	if err != nil {
+		log.Debugf("SignatureHelp: error computing node: %v", err)
+		return nil, nil
+	}
+
+	// Find the innermost function call whose argument list contains the cursor
+	var apply *ast.Apply
+	for !stack.IsEmpty() {
+		if node, ok := stack.Pop().(*ast.Apply); ok && isInArguments(node, location) {
+			apply = node
+			break
+		}
+	}
+	if apply == nil {
+		return nil, nil
+	}
+
+	processor := processing.NewProcessor(s.cache, s.getVM(doc.Item.URI.SpanURI().Filename()))
+	signature, paramNames := s.resolveSignature(processor, stack, apply.Target)
+	if signature == nil {
+		return nil, nil
+	}
+
+	activeParameter := activeArgument(doc.Item.Text, apply, location)
+	if named := activeParameter - len(apply.Arguments.Positional); named >= 0 && named < len(apply.Arguments.Named) {
+		// Named arguments can be in any order, find the parameter by name
+		activeParameter = len(paramNames)
+		for i, name := range paramNames {
+			if name == string(apply.Arguments.Named[named].Name) {
+				activeParameter = i
+				break
+			}
+		}
+	}
+
+	signature.ActiveParameter = uint32(activeParameter)
+	return &protocol.SignatureHelp{
+		Signatures:      []protocol.SignatureInformation{*signature},
+		ActiveSignature: 0,
+		ActiveParameter: uint32(activeParameter),
+	}, nil
+}
+
+// isInArguments returns true if the location is between the parentheses of the function call
+func isInArguments(apply *ast.Apply, location ast.Location) bool {
+	return isBefore(apply.Target.Loc().End, location) && isBefore(location, apply.LocRange.End)
+}
+
+func isBefore(a, b ast.Location) bool {
+	return a.Line < b.Line || (a.Line == b.Line && a.Column < b.Column)
+}
+
+// activeArgument returns the index of the argument at the location, positional arguments first, then named arguments
+func activeArgument(text string, apply *ast.Apply, location ast.Location) int {
+	var argEnds []ast.Location
+	for _, arg := range apply.Arguments.Positional {
+		argEnds = append(argEnds, arg.Expr.Loc().End)
+	}
+	for _, arg := range apply.Arguments.Named {
+		argEnds = append(argEnds, arg.Arg.Loc().End)
+	}
+
+	// Arguments ending before the cursor have been typed. The cursor is in the next one only if a comma follows
+	active := 0
+	for active < len(argEnds) && !isBefore(location, argEnds[active]) {
+		active++
+	}
+	if active > 0 && !strings.Contains(textBetween(text, argEnds[active-1], location), ",") {
+		active--
+	}
+	return active
+}
+
+// textBetween returns the text between two AST locations
+func textBetween(text string, begin, end ast.Location) string {
+	from, to := textOffset(text, begin), textOffset(text, end)
+	if from > to {
+		return ""
+	}
+	return text[from:to]
+}
+
+// textOffset returns the byte offset of an AST location in the text. The columns of the AST are byte offsets in their line
+func textOffset(text string, location ast.Location) int {
+	lines := strings.Split(text, "\n")
+	result := 0
+	for i := 0; i < location.Line-1 && i < len(lines); i++ {
+		result += len(lines[i]) + 1
+	}
+	if location.Line-1 < len(lines) {
+		result += min(max(location.Column-1, 0), len(lines[location.Line-1]))
+	}
+	return min(result, len(text))
+}
+
+// resolveSignature finds the function called by the given target and returns its signature and parameter names
+func (s *Server) resolveSignature(processor *processing.Processor, stack *nodestack.NodeStack, target ast.Node) (*protocol.SignatureInformation, []string) {
+	indexList := nodestack.NewNodeStack(target).BuildIndexList()
+	if len(indexList) == 0 {
+		return nil, nil
+	}
+	label := strings.Join(indexList, ".")
+
+	if len(indexList) == 2 && indexList[0] == "std" {
+		for _, f := range s.stdlib {
+			if f.Name == indexList[1] {
+				signature := &protocol.SignatureInformation{
+					Label:         f.Signature(),
+					Documentation: f.MarkdownDescription,
+				}
+				return signature, signatureParameters(signature, f.Params)
+			}
+		}
+		return nil, nil
+	}
+
+	var function *ast.Function
+	if varNode, ok := target.(*ast.Var); ok {
+		function = s.findFunctionBind(processor, stack, varNode.Id)
+	} else {
+		function = s.findFunctionField(processor, stack, indexList)
+	}
+	if function == nil {
+		return nil, nil
+	}
+
+	var params []string
+	for _, param := range function.Parameters {
+		paramText := string(param.Name)
+		if param.DefaultArg != nil && param.LocRange.Begin.IsSet() {
+			// Show the default value as written in the source
+			uri := protocol.URIFromPath(param.LocRange.FileName)
+			if text, err := s.cache.GetContents(uri, param.LocRange); err == nil {
+				paramText = text
+			}
+		}
+		params = append(params, paramText)
+	}
+	signature := &protocol.SignatureInformation{
+		Label: label + "(" + strings.Join(params, ", ") + ")",
+	}
+	return signature, signatureParameters(signature, params)
+}
+
+// signatureParameters sets the parameters of the signature and returns their names
+func signatureParameters(signature *protocol.SignatureInformation, params []string) []string {
+	var names []string
+	for _, param := range params {
+		signature.Parameters = append(signature.Parameters, protocol.ParameterInformation{Label: param})
+		name, _, _ := strings.Cut(param, "=")
+		names = append(names, strings.TrimSpace(name))
+	}
+	return names
+}
+
+// findFunctionBind finds the function bound to a local. The local is resolved like the definition of the variable
+func (s *Server) findFunctionBind(processor *processing.Processor, stack *nodestack.NodeStack, id ast.Identifier) *ast.Function {
+	bind := processing.FindBindByIDViaStack(stack, id)
+	if bind == nil {
+		// We can't know which function is passed as an argument
+		return nil
+	}
+	switch body := bind.Body.(type) {
+	case *ast.Function:
+		return body
+	case *ast.Index:
+		// `local f = lib.f`
+		return s.findFunctionField(processor, stack, nodestack.NewNodeStack(body).BuildIndexList())
+	}
+	return nil
+}
+
+// findFunctionField finds the function defined by the field at the end of the index list (`lib.f`, `self.f`)
+func (s *Server) findFunctionField(processor *processing.Processor, stack *nodestack.NodeStack, indexList []string) *ast.Function {
+	ranges, err := processor.FindRangesFromIndexList(stack.Clone(), indexList, false)
+	if err != nil {
+		log.Debugf("SignatureHelp: could not resolve %s: %v", strings.Join(indexList, "."), err)
+		return nil
+	}
+	for _, r := range ranges {
+		if function, ok := r.Node.(*ast.Function); ok {
+			return function
+		}
+	}
+	return nil
+}
@@ -0,0 +1,122 @@
+package server
+
+import (
+	"context"
+	"testing"
+
+	"github.com/grafana/jsonnet-language-server/pkg/stdlib"
+	"github.com/jdbaldry/go-language-server-protocol/lsp/protocol"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSignatureHelp(t *testing.T) {
+	testCases := []struct {
+		name            string
+		position        protocol.Position
+		expectedLabel   string
+		expectedParams  []string
+		expectedActive  uint32
+		expectedNoMatch bool
+	}{
+		{
+			name:           "first argument of a local function",
+			position:       protocol.Position{Line: 4, Character: 9},
+			expectedLabel:  "add(a, b=1)",
+			expectedParams: []string{"a", "b=1"},
+			expectedActive: 0,
+		},
+		{
+			name:           "after a comma",
+			position:       protocol.Position{Line: 4, Character: 12},
+			expectedLabel:  "add(a, b=1)",
+			expectedParams: []string{"a", "b=1"},
+			expectedActive: 1,
+		},
+		{
+			name:           "named argument out of order",
+			position:       protocol.Position{Line: 5, Character: 11},
+			expectedLabel:  "add(a, b=1)",
+			expectedParams: []string{"a", "b=1"},
+			expectedActive: 1,
+		},
+		{
+			name:           "named argument",
+			position:       protocol.Position{Line: 5, Character: 16},
+			expectedLabel:  "add(a, b=1)",
+			expectedParams: []string{"a", "b=1"},
+			expectedActive: 0,
+		},
+		{
+			name:           "imported function",
+			position:       protocol.Position{Line: 6, Character: 15},
+			expectedLabel:  "lib.greet(name, greeting='hello')",
+			expectedParams: []string{"name", "greeting='hello'"},
+			expectedActive: 0,
+		},
+		{
+			name:           "std function",
+			position:       protocol.Position{Line: 7, Character: 16},
+			expectedLabel:  "std.length(x)",
+			expectedParams: []string{"x"},
+			expectedActive: 0,
+		},
+		{
+			name:           "innermost call",
+			position:       protocol.Position{Line: 8, Character: 21},
+			expectedLabel:  "add(a, b=1)",
+			expectedParams: []string{"a", "b=1"},
+			expectedActive: 0,
+		},
+		{
+			name:           "method",
+			position:       protocol.Position{Line: 8, Character: 17},
+			expectedLabel:  "self.method(x)",
+			expectedParams: []string{"x"},
+			expectedActive: 0,
+		},
+		{
+			name:           "local bound to an imported function",
+			position:       protocol.Position{Line: 9, Character: 11},
+			expectedLabel:  "greet(name, greeting='hello')",
+			expectedParams: []string{"name", "greeting='hello'"},
+			expectedActive: 0,
+		},
+		{
+			name:            "outside of a call",
+			position:        protocol.Position{Line: 4, Character: 5},
+			expectedNoMatch: true,
+		},
+	}
+
+	server := testServer(t, []stdlib.Function{{Name: "length", Params: []string{"x"}, MarkdownDescription: "Length of x"}})
+	server.configuration.JPaths = []string{"testdata"}
+	uri := serverOpenTestFile(t, server, "testdata/signature-help.jsonnet")
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			result, err := server.SignatureHelp(context.Background(), &protocol.SignatureHelpParams{
+				TextDocumentPositionParams: protocol.TextDocumentPositionParams{
+					TextDocument: protocol.TextDocumentIdentifier{URI: uri},
+					Position:     tc.position,
+				},
+			})
+			require.NoError(t, err)
+			if tc.expectedNoMatch {
+				assert.Nil(t, result)
+				return
+			}
+			require.NotNil(t, result)
+			require.Len(t, result.Signatures, 1)
+
+			signature := result.Signatures[0]
+			assert.Equal(t, tc.expectedLabel, signature.Label)
+			var params []string
+			for _, param := range signature.Parameters {
+				params = append(params, param.Label)
+			}
+			assert.Equal(t, tc.expectedParams, params)
+			assert.Equal(t, tc.expectedActive, result.ActiveParameter)
+		})
+	}
+}
@@ -28,7 +28,7 @@ func (s *Server) DocumentSymbol(_ context.Context, params *protocol.DocumentSymb
 	}
 
 	processor := processing.NewProcessor(s.cache, nil)
-	symbols := s.buildDocumentSymbols(processor, doc.AST)
+	symbols := s.buildDocumentSymbols(s.converter(doc.Item.Text), processor, doc.AST)
 
 	result := make([]interface{}, len(symbols))
 	for i, symbol := range symbols {
@@ -38,25 +38,25 @@ func (s *Server) DocumentSymbol(_ context.Context, params *protocol.DocumentSymb
 	return result, nil
 }
 
-func (s *Server) buildDocumentSymbols(processor *processing.Processor, node ast.Node) []protocol.DocumentSymbol {
+func (s *Server) buildDocumentSymbols(converter *position.Converter, processor *processing.Processor, node ast.Node) []protocol.DocumentSymbol {
 	var symbols []protocol.DocumentSymbol
 
 	switch node := node.(type) {
 	case *ast.Binary:
-		symbols = append(symbols, s.buildDocumentSymbols(processor, node.Left)...)
-		symbols = append(symbols, s.buildDocumentSymbols(processor, node.Right)...)
+		symbols = append(symbols, s.buildDocumentSymbols(converter, processor, node.Left)...)
+		symbols = append(symbols, s.buildDocumentSymbols(converter, processor, node.Right)...)
 	case *ast.Local:
 		for _, bind := range node.Binds {
 			objectRange := processing.LocalBindToRange(bind)
 			symbols = append(symbols, protocol.DocumentSymbol{
 				Name:           string(bind.Variable),
 				Kind:           protocol.Variable,
-				Range:          position.RangeASTToProtocol(objectRange.FullRange),
-				SelectionRange: position.RangeASTToProtocol(objectRange.SelectionRange),
+				Range:          converter.RangeASTToProtocol(objectRange.FullRange),
+				SelectionRange: converter.RangeASTToProtocol(objectRange.SelectionRange),
 				Detail:         symbolDetails(bind.Body),
 			})
 		}
-		symbols = append(symbols, s.buildDocumentSymbols(processor, node.Body)...)
+		symbols = append(symbols, s.buildDocumentSymbols(converter, processor, node.Body)...)
 	case *ast.DesugaredObject:
 		for _, field := range node.Fields {
 			kind := protocol.Field
@@ -67,10 +67,10 @@ func (s *Server) buildDocumentSymbols(processor *processing.Processor, node ast.
 			symbols = append(symbols, protocol.DocumentSymbol{
 				Name:           processor.FieldNameToString(field.Name),
 				Kind:           kind,
-				Range:          position.RangeASTToProtocol(fieldRange.FullRange),
-				SelectionRange: position.RangeASTToProtocol(fieldRange.SelectionRange),
+				Range:          converter.RangeASTToProtocol(fieldRange.FullRange),
+				SelectionRange: converter.RangeASTToProtocol(fieldRange.SelectionRange),
 				Detail:         symbolDetails(field.Body),
-				Children:       s.buildDocumentSymbols(processor, field.Body),
+				Children:       s.buildDocumentSymbols(converter, processor, field.Body),
 			})
 		}
 	}
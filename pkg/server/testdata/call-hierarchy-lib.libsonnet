@@ -0,0 +1,6 @@
+local withLimits(resources) = resources { limits: resources.requests };
+
+{
+  withResources(cpu, memory):: withLimits({ requests: { cpu: cpu, memory: memory } }),
+  defaultResources():: self.withResources('1', '1Gi'),
+}
@@ -0,0 +1,3 @@
+{
+  name: 'frontend',
+}
@@ -0,0 +1,7 @@
+local prefix = 'app-';
+local unused = 1;
+{
+  name(suffix):: prefix + suffix,
+  replicas: 2,
+  'quoted-field': self.replicas,
+}
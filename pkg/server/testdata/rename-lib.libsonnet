@@ -0,0 +1,8 @@
+local helper(value) = value * 2;
+{
+  local value = 1,
+  value: helper(value),
+  double(value):: helper(value) + self.value,
+  nested: { value: 'other' },
+  'quoted-name': self['value'],
+}
@@ -0,0 +1,3 @@
+{
+  greet(name, greeting='hello'):: greeting + ' ' + name,
+}
@@ -0,0 +1,3 @@
+{
+  ignoredField: true,
+}
@@ -0,0 +1,12 @@
+{
+  apps: {
+    deployment: {
+      new(name): {
+        metadata: { name: name },
+      },
+      withReplicas(replicas): {
+        spec: { replicas: replicas },
+      },
+    },
+  },
+}
@@ -0,0 +1,87 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	position "github.com/grafana/jsonnet-language-server/pkg/position_conversion"
+	"github.com/jdbaldry/go-language-server-protocol/lsp/protocol"
+)
+
+var errInvalidChange = errors.New("invalid content change")
+
+// applyContentChanges applies the changes of a DidChange notification to the text, in order. Changes without a range
+// replace the whole text. It returns the new text, and the lines of the new text that don't match the AST anymore:
+// the given changed lines, the edited lines, and the lines that moved. The characters of the ranges are counted in the encoding
+func applyContentChanges(text string, changedLines map[int]bool, changes []protocol.TextDocumentContentChangeEvent, encoding position.Encoding) (string, map[int]bool, error) {
+	result := make(map[int]bool, len(changedLines))
+	for line := range changedLines {
+		result[line] = true
+	}
+
+	for _, change := range changes {
+		if change.Range == nil {
+			result = changedLinesAfterReplace(text, change.Text, result)
+			text = change.Text
+			continue
+		}
+
+		begin, end := positionOffset(text, change.Range.Start, encoding), positionOffset(text, change.Range.End, encoding)
+		if begin > end {
+			return "", nil, fmt.Errorf("%w: range end %v is before its start %v", errInvalidChange, change.Range.End, change.Range.Start)
+		}
+		startLine, endLine := strings.Count(text[:begin], "\n"), strings.Count(text[:end], "\n")
+		text = text[:begin] + change.Text + text[end:]
+
+		// The lines after the edit moved if the number of lines changed
+		newEndLine := startLine + strings.Count(change.Text, "\n")
+		moved := newEndLine != endLine
+		shifted := make(map[int]bool, len(result))
+		for line := range result {
+			if line < startLine || (line > endLine && !moved) {
+				shifted[line] = true
+			}
+		}
+		lastLine := newEndLine
+		if moved {
+			lastLine = strings.Count(text, "\n")
+		}
+		for line := startLine; line <= lastLine; line++ {
+			shifted[line] = true
+		}
+		result = shifted
+	}
+	return text, result, nil
+}
+
+// changedLinesAfterReplace adds the lines that differ between two versions of a text to the changed lines
+func changedLinesAfterReplace(oldText, newText string, changedLines map[int]bool) map[int]bool {
+	oldLines, newLines := strings.Split(oldText, "\n"), strings.Split(newText, "\n")
+	for index := 0; index < len(oldLines) || index < len(newLines); index++ {
+		if index >= len(oldLines) || index >= len(newLines) || oldLines[index] != newLines[index] {
+			changedLines[index] = true
+		}
+	}
+	return changedLines
+}
+
+// positionOffset returns the byte offset of a position, whose character is counted in the encoding.
+// Like the specification requires, positions past the end of a line are at the end of the line,
+// and lines past the end of the text are at the end of the text
+func positionOffset(text string, pos protocol.Position, encoding position.Encoding) int {
+	offset := 0
+	for line := uint32(0); line < pos.Line; line++ {
+		newline := strings.IndexByte(text[offset:], '\n')
+		if newline < 0 {
+			return len(text)
+		}
+		offset += newline + 1
+	}
+
+	line := text[offset:]
+	if newline := strings.IndexByte(line, '\n'); newline >= 0 {
+		line = line[:newline]
+	}
+	return offset + min(encoding.ByteOffset(line, pos.Character), len(line))
+}
@@ -0,0 +1,165 @@
+package server
+
+import (
+	"context"
+	"testing"
+
+	"github.com/grafana/jsonnet-language-server/pkg/cache"
+	position "github.com/grafana/jsonnet-language-server/pkg/position_conversion"
+	"github.com/jdbaldry/go-language-server-protocol/lsp/protocol"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func rangeChange(startLine, startCharacter, endLine, endCharacter uint32, text string) protocol.TextDocumentContentChangeEvent {
+	changeRange := selectionRange(startLine, startCharacter, endLine, endCharacter)
+	return protocol.TextDocumentContentChangeEvent{Range: &changeRange, Text: text}
+}
+
+func TestApplyContentChanges(t *testing.T) {
+	for _, tc := range []struct {
+		name                 string
+		text                 string
+		changedLines         map[int]bool
+		changes              []protocol.TextDocumentContentChangeEvent
+		encoding             position.Encoding
+		expectedText         string
+		expectedChangedLines map[int]bool
+		expectedErr          bool
+	}{
+		{
+			name:                 "insertion in a line",
+			text:                 "{\n  a: 1,\n}\n",
+			changes:              []protocol.TextDocumentContentChangeEvent{rangeChange(1, 5, 1, 5, "2")},
+			expectedText:         "{\n  a: 21,\n}\n",
+			expectedChangedLines: map[int]bool{1: true},
+		},
+		{
+			name:                 "new lines move the following lines",
+			text:                 "{\n  a: 1,\n}\n",
+			changes:              []protocol.TextDocumentContentChangeEvent{rangeChange(1, 7, 1, 7, "\n  b: 2,")},
+			expectedText:         "{\n  a: 1,\n  b: 2,\n}\n",
+			expectedChangedLines: map[int]bool{1: true, 2: true, 3: true, 4: true},
+		},
+		{
+			name:                 "deletion across lines",
+			text:                 "{\n  a: 1,\n  b: 2,\n}\n",
+			changes:              []protocol.TextDocumentContentChangeEvent{rangeChange(1, 7, 2, 7, "")},
+			expectedText:         "{\n  a: 1,\n}\n",
+			expectedChangedLines: map[int]bool{1: true, 2: true, 3: true},
+		},
+		{
+			name:                 "previous changes are kept",
+			text:                 "[\n  1,\n  2,\n]\n",
+			changedLines:         map[int]bool{0: true, 2: true},
+			changes:              []protocol.TextDocumentContentChangeEvent{rangeChange(1, 2, 1, 3, "3")},
+			expectedText:         "[\n  3,\n  2,\n]\n",
+			expectedChangedLines: map[int]bool{0: true, 1: true, 2: true},
+		},
+		{
+			name:                 "several changes in order",
+			text:                 "local a = 1;\na\n",
+			changes:              []protocol.TextDocumentContentChangeEvent{rangeChange(0, 6, 0, 7, "b"), rangeChange(1, 0, 1, 1, "b")},
+			expectedText:         "local b = 1;\nb\n",
+			expectedChangedLines: map[int]bool{0: true, 1: true},
+		},
+		{
+			name:                 "UTF-16 positions",
+			text:                 "{ a: '😀é', b: 1 }",
+			changes:              []protocol.TextDocumentContentChangeEvent{rangeChange(0, 6, 0, 9, "x")},
+			expectedText:         "{ a: 'x', b: 1 }",
+			expectedChangedLines: map[int]bool{0: true},
+		},
+		{
+			name:                 "UTF-8 positions",
+			text:                 "{ a: '😀é', b: 1 }",
+			changes:              []protocol.TextDocumentContentChangeEvent{rangeChange(0, 6, 0, 12, "x")},
+			encoding:             position.UTF8,
+			expectedText:         "{ a: 'x', b: 1 }",
+			expectedChangedLines: map[int]bool{0: true},
+		},
+		{
+			name:                 "UTF-32 positions",
+			text:                 "{ a: '😀é', b: 1 }",
+			changes:              []protocol.TextDocumentContentChangeEvent{rangeChange(0, 6, 0, 8, "x")},
+			encoding:             position.UTF32,
+			expectedText:         "{ a: 'x', b: 1 }",
+			expectedChangedLines: map[int]bool{0: true},
+		},
+		{
+			name:                 "positions past the end of a line",
+			text:                 "[1]\n[2]\n",
+			changes:              []protocol.TextDocumentContentChangeEvent{rangeChange(0, 10, 0, 20, " + [3]")},
+			expectedText:         "[1] + [3]\n[2]\n",
+			expectedChangedLines: map[int]bool{0: true},
+		},
+		{
+			name:                 "positions past the end of the text",
+			text:                 "[1]",
+			changes:              []protocol.TextDocumentContentChangeEvent{rangeChange(5, 0, 5, 0, " + [2]")},
+			expectedText:         "[1] + [2]",
+			expectedChangedLines: map[int]bool{0: true},
+		},
+		{
+			name:                 "full text",
+			text:                 "[\n  1,\n  2,\n]\n",
+			changes:              []protocol.TextDocumentContentChangeEvent{{Text: "[\n  1,\n  3,\n  4,\n]\n"}},
+			expectedText:         "[\n  1,\n  3,\n  4,\n]\n",
+			expectedChangedLines: map[int]bool{2: true, 3: true, 4: true, 5: true},
+		},
+		{
+			name:        "range end before its start",
+			text:        "[1, 2]",
+			changes:     []protocol.TextDocumentContentChangeEvent{rangeChange(0, 4, 0, 1, "")},
+			expectedErr: true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			encoding := tc.encoding
+			if encoding == "" {
+				encoding = position.UTF16
+			}
+			text, changedLines, err := applyContentChanges(tc.text, tc.changedLines, tc.changes, encoding)
+			if tc.expectedErr {
+				assert.ErrorIs(t, err, errInvalidChange)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.expectedText, text)
+			assert.Equal(t, tc.expectedChangedLines, changedLines)
+		})
+	}
+}
+
+func TestDidChangeIncremental(t *testing.T) {
+	server, uri := testServerWithFile(t, nil, "local a = 1;\n{\n  b: a,\n}\n")
+	var doc *cache.Document
+	change := func(version int32, changes ...protocol.TextDocumentContentChangeEvent) {
+		t.Helper()
+		require.NoError(t, server.DidChange(context.Background(), &protocol.DidChangeTextDocumentParams{
+			TextDocument:   protocol.VersionedTextDocumentIdentifier{Version: version, TextDocumentIdentifier: protocol.TextDocumentIdentifier{URI: uri}},
+			ContentChanges: changes,
+		}))
+		var err error
+		doc, err = server.cache.Get(uri)
+		require.NoError(t, err)
+	}
+
+	// The AST of the last valid version is kept
+	change(2, rangeChange(2, 6, 2, 6, " +"))
+	assert.Equal(t, "local a = 1;\n{\n  b: a +,\n}\n", doc.Item.Text)
+	assert.Equal(t, int32(2), doc.Item.Version)
+	assert.Error(t, doc.Err)
+	assert.Equal(t, map[int]bool{2: true}, doc.LinesChangedSinceAST)
+	oldAST := doc.AST
+
+	// Older versions are ignored
+	change(1, rangeChange(0, 0, 0, 0, "broken"))
+	assert.Equal(t, "local a = 1;\n{\n  b: a +,\n}\n", doc.Item.Text)
+
+	change(3, rangeChange(2, 8, 2, 8, " 1"))
+	assert.Equal(t, "local a = 1;\n{\n  b: a + 1,\n}\n", doc.Item.Text)
+	assert.NoError(t, doc.Err)
+	assert.NotSame(t, oldAST, doc.AST)
+	assert.Empty(t, doc.LinesChangedSinceAST)
+}
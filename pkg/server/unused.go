@@ -8,18 +8,6 @@ import (
 	"github.com/jdbaldry/go-language-server-protocol/lsp/protocol"
 )
 
-func (s *Server) Initialized(context.Context, *protocol.InitializedParams) error {
-	return nil
-}
-
-func (s *Server) CodeAction(context.Context, *protocol.CodeActionParams) ([]protocol.CodeAction, error) {
-	return nil, notImplemented("CodeAction")
-}
-
-func (s *Server) CodeLens(_ context.Context, _ *protocol.CodeLensParams) ([]protocol.CodeLens, error) {
-	return []protocol.CodeLens{}, nil
-}
-
 func (s *Server) CodeLensRefresh(context.Context) error {
 	return notImplemented("CodeLensRefresh")
 }
@@ -36,34 +24,18 @@ func (s *Server) DidRenameFiles(context.Context, *protocol.RenameFilesParams) er
 	return notImplemented("DidRenameFiles")
 }
 
-func (s *Server) DidSave(context.Context, *protocol.DidSaveTextDocumentParams) error {
-	return notImplemented("DidSave")
-}
-
 func (s *Server) DocumentColor(context.Context, *protocol.DocumentColorParams) ([]protocol.ColorInformation, error) {
 	return nil, notImplemented("DocumentColor")
 }
 
-func (s *Server) DocumentHighlight(context.Context, *protocol.DocumentHighlightParams) ([]protocol.DocumentHighlight, error) {
-	return nil, notImplemented("DocumentHighlight")
-}
-
 func (s *Server) Exit(context.Context) error {
 	return notImplemented("Exit")
 }
 
-func (s *Server) FoldingRange(context.Context, *protocol.FoldingRangeParams) ([]protocol.FoldingRange, error) {
-	return nil, notImplemented("FoldingRange")
-}
-
 func (s *Server) Implementation(context.Context, *protocol.ImplementationParams) (protocol.Definition, error) {
 	return nil, notImplemented("Implementation")
 }
 
-func (s *Server) IncomingCalls(context.Context, *protocol.CallHierarchyIncomingCallsParams) ([]protocol.CallHierarchyIncomingCall, error) {
-	return nil, notImplemented("IncomingCalls")
-}
-
 func (s *Server) LinkedEditingRange(context.Context, *protocol.LinkedEditingRangeParams) (*protocol.LinkedEditingRanges, error) {
 	return nil, notImplemented("LinkedEditingRange")
 }
@@ -76,38 +48,10 @@ func (s *Server) Moniker(context.Context, *protocol.MonikerParams) ([]protocol.M
 	return nil, notImplemented("Moniker")
 }
 
-func (s *Server) NonstandardRequest(context.Context, string, interface{}) (interface{}, error) {
-	return nil, notImplemented("NonstandardRequest")
-}
-
-func (s *Server) OnTypeFormatting(context.Context, *protocol.DocumentOnTypeFormattingParams) ([]protocol.TextEdit, error) {
-	return nil, notImplemented("OnTypeFormatting")
-}
-
-func (s *Server) OutgoingCalls(context.Context, *protocol.CallHierarchyOutgoingCallsParams) ([]protocol.CallHierarchyOutgoingCall, error) {
-	return nil, notImplemented("OutgoingCalls")
-}
-
-func (s *Server) PrepareCallHierarchy(context.Context, *protocol.CallHierarchyPrepareParams) ([]protocol.CallHierarchyItem, error) {
-	return nil, notImplemented("PrepareCallHierarchy")
-}
-
-func (s *Server) PrepareRename(context.Context, *protocol.PrepareRenameParams) (*protocol.Range, error) {
-	return nil, notImplemented("PrepareRange")
-}
-
 func (s *Server) PrepareTypeHierarchy(context.Context, *protocol.TypeHierarchyPrepareParams) ([]protocol.TypeHierarchyItem, error) {
 	return nil, notImplemented("PrepareTypeHierarchy")
 }
 
-func (s *Server) RangeFormatting(context.Context, *protocol.DocumentRangeFormattingParams) ([]protocol.TextEdit, error) {
-	return nil, notImplemented("RangeFormatting")
-}
-
-func (s *Server) Rename(context.Context, *protocol.RenameParams) (*protocol.WorkspaceEdit, error) {
-	return nil, notImplemented("Rename")
-}
-
 func (s *Server) Resolve(context.Context, *protocol.CompletionItem) (*protocol.CompletionItem, error) {
 	return nil, notImplemented("Resolve")
 }
@@ -116,30 +60,10 @@ func (s *Server) ResolveCodeAction(context.Context, *protocol.CodeAction) (*prot
 	return nil, notImplemented("ResolveCodeAction")
 }
 
-func (s *Server) ResolveCodeLens(context.Context, *protocol.CodeLens) (*protocol.CodeLens, error) {
-	return nil, notImplemented("ResolveCodeLens")
-}
-
-func (s *Server) ResolveDocumentLink(context.Context, *protocol.DocumentLink) (*protocol.DocumentLink, error) {
-	return nil, notImplemented("ResolveDocumentLink")
-}
-
 func (s *Server) SelectionRange(context.Context, *protocol.SelectionRangeParams) ([]protocol.SelectionRange, error) {
 	return nil, notImplemented("SelectionRange")
 }
 
-func (s *Server) SemanticTokensFull(context.Context, *protocol.SemanticTokensParams) (*protocol.SemanticTokens, error) {
-	return nil, notImplemented("SemanticTokensFull")
-}
-
-func (s *Server) SemanticTokensFullDelta(context.Context, *protocol.SemanticTokensDeltaParams) (interface{}, error) {
-	return nil, notImplemented("SemanticTokensFullDelta")
-}
-
-func (s *Server) SemanticTokensRange(context.Context, *protocol.SemanticTokensRangeParams) (*protocol.SemanticTokens, error) {
-	return nil, notImplemented("SemanticTokensRange")
-}
-
 func (s *Server) SemanticTokensRefresh(context.Context) error {
 	return notImplemented("SemanticTokensRefresh")
 }
@@ -152,10 +76,6 @@ func (s *Server) Shutdown(context.Context) error {
 	return nil
 }
 
-func (s *Server) SignatureHelp(context.Context, *protocol.SignatureHelpParams) (*protocol.SignatureHelp, error) {
-	return nil, notImplemented("SignatureHelp")
-}
-
 func (s *Server) Subtypes(context.Context, *protocol.TypeHierarchySubtypesParams) ([]protocol.TypeHierarchyItem, error) {
 	return nil, notImplemented("Subtypes")
 }
@@ -164,10 +84,6 @@ func (s *Server) Supertypes(context.Context, *protocol.TypeHierarchySupertypesPa
 	return nil, notImplemented("Supertypes")
 }
 
-func (s *Server) Symbol(context.Context, *protocol.WorkspaceSymbolParams) ([]protocol.SymbolInformation, error) {
-	return nil, notImplemented("Symbol")
-}
-
 func (s *Server) TypeDefinition(context.Context, *protocol.TypeDefinitionParams) (protocol.Definition, error) {
 	return nil, notImplemented("TypeDefinition")
 }
@@ -196,30 +112,11 @@ func (s *Server) WorkDoneProgressCancel(context.Context, *protocol.WorkDoneProgr
 	return notImplemented("WorkDoneProgressCancel")
 }
 
+// Diagnostic is generated with the wrong parameters by the protocol library. The request is handled by DocumentDiagnostic
 func (s *Server) Diagnostic(context.Context, *string) (*string, error) {
 	return nil, notImplemented("Diagnostic")
 }
 
-func (s *Server) DiagnosticRefresh(context.Context) error {
-	return notImplemented("DiagnosticRefresh")
-}
-
-func (s *Server) DiagnosticWorkspace(context.Context, *protocol.WorkspaceDiagnosticParams) (*protocol.WorkspaceDiagnosticReport, error) {
-	return nil, notImplemented("DiagnosticWorkspace")
-}
-
-func (s *Server) DidChangeWatchedFiles(context.Context, *protocol.DidChangeWatchedFilesParams) error {
-	return notImplemented("DidChangeWatchedFiles")
-}
-
-func (s *Server) DidChangeWorkspaceFolders(context.Context, *protocol.DidChangeWorkspaceFoldersParams) error {
-	return notImplemented("DidChangeWorkspaceFolders")
-}
-
-func (s *Server) DidClose(context.Context, *protocol.DidCloseTextDocumentParams) error {
-	return notImplemented("DidClose")
-}
-
 func (s *Server) DidCreateFiles(context.Context, *protocol.CreateFilesParams) error {
 	return notImplemented("DidCreateFiles")
 }
@@ -228,12 +125,6 @@ func (s *Server) DidDeleteFiles(context.Context, *protocol.DeleteFilesParams) er
 	return notImplemented("DidDeleteFiles")
 }
 
-// DocumentLink is not implemented.
-// TODO(#13): Understand why the server capabilities includes documentlink.
-func (s *Server) DocumentLink(context.Context, *protocol.DocumentLinkParams) ([]protocol.DocumentLink, error) {
-	return nil, nil
-}
-
 func notImplemented(method string) error {
 	return fmt.Errorf("%w: %q not yet implemented", jsonrpc2.ErrMethodNotFound, method)
 }
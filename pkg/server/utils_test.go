@@ -5,7 +5,9 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"runtime"
 	"testing"
+	"time"
 
 	"github.com/google/go-jsonnet/formatter"
 	"github.com/grafana/jsonnet-language-server/pkg/stdlib"
@@ -48,6 +50,8 @@ func testServer(t *testing.T, stdlib []stdlib.Function) (server *Server) {
 	server.stdlib = stdlib
 	_, err := server.Initialize(context.Background(), &protocol.ParamInitialize{})
 	require.NoError(t, err)
+	// The documents aren't diagnosed in the background while the tests modify them, unless a test starts the workers
+	server.diagnostics = newDiagnosticsScheduler(runtime.NumCPU(), server.diagnose)
 
 	return server
 }
@@ -83,3 +87,18 @@ func testServerWithFile(t *testing.T, stdlib []stdlib.Function, fileContent stri
 
 	return server, serverOpenTestFile(t, server, tmpFile.Name())
 }
+
+// withEvalTimeout aborts the evaluations of a test server after a short timeout. The VMs of the aborted evaluations
+// keep running, the test waits for them to return at the end, so that they don't slow down the other tests
+func withEvalTimeout(t *testing.T, server *Server, timeout time.Duration) {
+	t.Helper()
+
+	server.configuration.EvalTimeout = timeout
+	t.Cleanup(func() {
+		require.Eventually(t, func() bool {
+			server.evalRunningMutex.Lock()
+			defer server.evalRunningMutex.Unlock()
+			return server.evalAbandoned == 0
+		}, 30*time.Second, 10*time.Millisecond)
+	})
+}
@@ -0,0 +1,53 @@
+package server
+
+import (
+	"context"
+
+	"github.com/jdbaldry/go-language-server-protocol/lsp/protocol"
+	log "github.com/sirupsen/logrus"
+)
+
+// watchedFilesGlobPattern matches the files that can be imported
+const watchedFilesGlobPattern = "**/*.{jsonnet,libsonnet,json}"
+
+// registerFileWatchers asks the client to notify the changes made to the files outside of the editor
+func (s *Server) registerFileWatchers() {
+	if !s.watchedFilesRegistrationSupport {
+		return
+	}
+	go func() {
+		err := s.client.RegisterCapability(context.Background(), &protocol.RegistrationParams{
+			Registrations: []protocol.Registration{{
+				ID:     "jsonnet-watched-files",
+				Method: "workspace/didChangeWatchedFiles",
+				RegisterOptions: protocol.DidChangeWatchedFilesRegistrationOptions{
+					Watchers: []protocol.FileSystemWatcher{{GlobPattern: watchedFilesGlobPattern}},
+				},
+			}},
+		})
+		if err != nil {
+			log.Errorf("RegisterCapability: unable to register the file watchers: %v", err)
+		}
+	}()
+}
+
+func (s *Server) DidChangeWatchedFiles(_ context.Context, params *protocol.DidChangeWatchedFilesParams) error {
+	for _, change := range params.Changes {
+		log.Debugf("DidChangeWatchedFiles: %s changed (%d)", change.URI, change.Type)
+		s.cache.Invalidate(change.URI)
+		if change.Type == protocol.Deleted {
+			s.forgetDiagnostics(change.URI)
+		}
+		if change.Type != protocol.Changed {
+			s.forgetWorkspaceEntrypoints()
+		}
+		s.queueDependentDiagnostics(change.URI)
+
+		// Open documents are more recent than the files on disk
+		if _, err := s.cache.Get(change.URI); err == nil {
+			continue
+		}
+		s.updateWorkspaceSymbolsFromDisk(change.URI)
+	}
+	return nil
+}
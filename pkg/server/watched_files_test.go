@@ -0,0 +1,196 @@
+package server
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/grafana/jsonnet-language-server/pkg/stdlib"
+	"github.com/jdbaldry/go-language-server-protocol/lsp/protocol"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// registeringClient records the capabilities registered by the server
+type registeringClient struct {
+	protocol.ClientCloser
+	registrations chan *protocol.RegistrationParams
+}
+
+func (c *registeringClient) RegisterCapability(_ context.Context, params *protocol.RegistrationParams) error {
+	c.registrations <- params
+	return nil
+}
+
+func TestDidCloseEvictsDocument(t *testing.T) {
+	server, uri := testServerWithFile(t, nil, "{ a: 1 }")
+	require.NoError(t, server.DidChange(context.Background(), &protocol.DidChangeTextDocumentParams{
+		TextDocument: protocol.VersionedTextDocumentIdentifier{
+			TextDocumentIdentifier: protocol.TextDocumentIdentifier{URI: uri},
+			Version:                2,
+		},
+		ContentChanges: []protocol.TextDocumentContentChangeEvent{{Text: "{ b: 2 }"}},
+	}))
+	text, err := server.cache.GetText(uri)
+	require.NoError(t, err)
+	assert.Equal(t, "{ b: 2 }", text)
+
+	// The unsaved changes are discarded, the text comes from the disk
+	require.NoError(t, server.DidClose(context.Background(), &protocol.DidCloseTextDocumentParams{
+		TextDocument: protocol.TextDocumentIdentifier{URI: uri},
+	}))
+	_, err = server.cache.Get(uri)
+	assert.Error(t, err)
+	text, err = server.cache.GetText(uri)
+	require.NoError(t, err)
+	assert.Equal(t, "{ a: 1 }", text)
+
+	// The document can be opened again
+	serverOpenTestFile(t, server, uri.SpanURI().Filename())
+	_, err = server.cache.Get(uri)
+	assert.NoError(t, err)
+}
+
+func TestDidChangeWatchedFiles(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "lib.libsonnet")
+	uri := protocol.URIFromPath(filename)
+	require.NoError(t, os.WriteFile(filename, []byte("{ first: 1 }"), 0o600))
+
+	server := testServer(t, nil)
+	server.setWorkspaceRoots(&protocol.ParamInitialize{
+		InitializeParams: protocol.InitializeParams{RootURI: protocol.URIFromPath(dir)},
+	})
+	search := func(query string) []string {
+		symbols, err := server.Symbol(context.Background(), &protocol.WorkspaceSymbolParams{Query: query})
+		require.NoError(t, err)
+		return qualifiedSymbolNames(symbols)
+	}
+	notify := func(changeType protocol.FileChangeType) {
+		require.NoError(t, server.DidChangeWatchedFiles(context.Background(), &protocol.DidChangeWatchedFilesParams{
+			Changes: []protocol.FileEvent{{URI: uri, Type: changeType}},
+		}))
+	}
+	text, err := server.cache.GetText(uri)
+	require.NoError(t, err)
+	assert.Equal(t, "{ first: 1 }", text)
+	assert.Equal(t, []string{"first"}, search("first"))
+
+	// The text read from disk is cached until the file changes
+	require.NoError(t, os.WriteFile(filename, []byte("{ second: 2 }"), 0o600))
+	text, err = server.cache.GetText(uri)
+	require.NoError(t, err)
+	assert.Equal(t, "{ first: 1 }", text)
+
+	notify(protocol.Changed)
+	text, err = server.cache.GetText(uri)
+	require.NoError(t, err)
+	assert.Equal(t, "{ second: 2 }", text)
+	assert.Equal(t, []string{}, search("first"))
+	assert.Equal(t, []string{"second"}, search("second"))
+
+	require.NoError(t, os.Remove(filename))
+	notify(protocol.Deleted)
+	_, err = server.cache.GetText(uri)
+	assert.Error(t, err)
+	assert.Equal(t, []string{}, search("second"))
+}
+
+func TestInitializedRegistersFileWatchers(t *testing.T) {
+	for _, tc := range []struct {
+		name                string
+		dynamicRegistration bool
+	}{
+		{name: "dynamic registration", dynamicRegistration: true},
+		{name: "no dynamic registration", dynamicRegistration: false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			client := &registeringClient{registrations: make(chan *protocol.RegistrationParams, 1)}
+			server := NewServer("jsonnet-language-server", "dev", client, Configuration{})
+			server.stdlib = []stdlib.Function{}
+			params := &protocol.ParamInitialize{}
+			params.Capabilities.Workspace.DidChangeWatchedFiles.DynamicRegistration = tc.dynamicRegistration
+			_, err := server.Initialize(context.Background(), params)
+			require.NoError(t, err)
+			require.NoError(t, server.Initialized(context.Background(), &protocol.InitializedParams{}))
+
+			if !tc.dynamicRegistration {
+				select {
+				case <-client.registrations:
+					t.Fatal("the file watchers are registered without dynamic registration support")
+				case <-time.After(100 * time.Millisecond):
+				}
+				return
+			}
+
+			select {
+			case params := <-client.registrations:
+				require.Len(t, params.Registrations, 1)
+				assert.Equal(t, "workspace/didChangeWatchedFiles", params.Registrations[0].Method)
+				assert.Equal(t, protocol.DidChangeWatchedFilesRegistrationOptions{
+					Watchers: []protocol.FileSystemWatcher{{GlobPattern: "**/*.{jsonnet,libsonnet,json}"}},
+				}, params.Registrations[0].RegisterOptions)
+			case <-time.After(5 * time.Second):
+				t.Fatal("the file watchers were not registered")
+			}
+		})
+	}
+}
+
+func TestWatchedFileChangeInvalidatesImporters(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"base.libsonnet":  "{ b: 2 }",
+		"lib.libsonnet":   "(import 'base.libsonnet') + { a: 1 }",
+		"main.jsonnet":    "local lib = import 'lib.libsonnet';\nlib.b\n",
+		"other.libsonnet": "{ c: 3 }",
+	}
+	for name, content := range files {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(content), 0o600))
+	}
+	mainFile, baseFile := filepath.Join(dir, "main.jsonnet"), filepath.Join(dir, "base.libsonnet")
+
+	server := testServer(t, nil)
+	mainURI := serverOpenTestFile(t, server, mainFile)
+	otherURI := serverOpenTestFile(t, server, filepath.Join(dir, "other.libsonnet"))
+	resolve := func() {
+		t.Helper()
+		links, err := server.definitionLink(&protocol.DefinitionParams{
+			TextDocumentPositionParams: protocol.TextDocumentPositionParams{
+				TextDocument: protocol.TextDocumentIdentifier{URI: mainURI},
+				Position:     protocol.Position{Line: 1, Character: 4},
+			},
+		})
+		require.NoError(t, err)
+		require.Len(t, links, 1)
+		assert.Equal(t, protocol.URIFromPath(baseFile), links[0].TargetURI)
+	}
+	cached := func() bool {
+		_, ok := server.cache.GetTopLevelObject("lib.libsonnet", mainFile)
+		return ok
+	}
+
+	resolve()
+	require.True(t, cached())
+	assert.Equal(t, []string{filepath.Join(dir, "lib.libsonnet"), mainFile}, server.cache.TransitiveImporters(baseFile))
+
+	// Changes to unrelated files keep the objects
+	require.NoError(t, server.DidChange(context.Background(), &protocol.DidChangeTextDocumentParams{
+		TextDocument: protocol.VersionedTextDocumentIdentifier{
+			TextDocumentIdentifier: protocol.TextDocumentIdentifier{URI: otherURI},
+			Version:                2,
+		},
+		ContentChanges: []protocol.TextDocumentContentChangeEvent{{Text: "{ d: 4 }"}},
+	}))
+	assert.True(t, cached())
+
+	// Changes to a file imported indirectly drop them
+	require.NoError(t, server.DidChangeWatchedFiles(context.Background(), &protocol.DidChangeWatchedFilesParams{
+		Changes: []protocol.FileEvent{{URI: protocol.URIFromPath(baseFile), Type: protocol.Changed}},
+	}))
+	assert.False(t, cached())
+	resolve()
+	assert.True(t, cached())
+}
@@ -0,0 +1,371 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode"
+
+	"github.com/google/go-jsonnet"
+	"github.com/google/go-jsonnet/ast"
+	"github.com/grafana/jsonnet-language-server/pkg/ast/processing"
+	"github.com/jdbaldry/go-language-server-protocol/lsp/protocol"
+	log "github.com/sirupsen/logrus"
+)
+
+// maxWorkspaceSymbols is the maximum number of symbols returned by a workspace symbol search
+const maxWorkspaceSymbols = 500
+
+// workspaceSymbolIndex contains the top-level locals and fields of the Jsonnet files of the workspace.
+// It's built on the first search and updated when documents are opened or changed
+type workspaceSymbolIndex struct {
+	mu    sync.RWMutex
+	built bool
+	files map[string][]protocol.SymbolInformation
+
+	// buildMu prevents concurrent searches from walking the workspace at the same time
+	buildMu sync.Mutex
+	// building is set while the index is built in the background
+	building atomic.Bool
+}
+
+func newWorkspaceSymbolIndex() *workspaceSymbolIndex {
+	return &workspaceSymbolIndex{files: make(map[string][]protocol.SymbolInformation)}
+}
+
+// update replaces the symbols of a file
+func (i *workspaceSymbolIndex) update(filename string, symbols []protocol.SymbolInformation) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.files[filename] = symbols
+}
+
+// remove forgets the symbols of a file
+func (i *workspaceSymbolIndex) remove(filename string) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	delete(i.files, filename)
+}
+
+// reset forgets all the files. The index is rebuilt on the next search
+func (i *workspaceSymbolIndex) reset() {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.built = false
+	i.files = make(map[string][]protocol.SymbolInformation)
+}
+
+func (i *workspaceSymbolIndex) isBuilt() bool {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	return i.built
+}
+
+// merge adds the symbols of files that were walked. Files updated while walking are more recent, they are kept
+func (i *workspaceSymbolIndex) merge(files map[string][]protocol.SymbolInformation) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	for filename, symbols := range files {
+		if _, ok := i.files[filename]; !ok {
+			i.files[filename] = symbols
+		}
+	}
+	i.built = true
+}
+
+func (i *workspaceSymbolIndex) all() []protocol.SymbolInformation {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	var symbols []protocol.SymbolInformation
+	for _, fileSymbols := range i.files {
+		symbols = append(symbols, fileSymbols...)
+	}
+	return symbols
+}
+
+// filenames returns the indexed files, sorted
+func (i *workspaceSymbolIndex) filenames() []string {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	filenames := make([]string, 0, len(i.files))
+	for filename := range i.files {
+		filenames = append(filenames, filename)
+	}
+	sort.Strings(filenames)
+	return filenames
+}
+
+func (s *Server) Symbol(_ context.Context, params *protocol.WorkspaceSymbolParams) ([]protocol.SymbolInformation, error) {
+	s.buildWorkspaceSymbols()
+
+	type match struct {
+		symbol protocol.SymbolInformation
+		score  int
+	}
+	var matches []match
+	for _, symbol := range s.workspaceSymbols.all() {
+		// Qualified queries (`deployment.new`) are matched against the path of the symbol
+		name := symbol.Name
+		if strings.Contains(params.Query, ".") && symbol.ContainerName != "" {
+			name = symbol.ContainerName + "." + symbol.Name
+		}
+		if score, ok := fuzzyMatch(params.Query, name); ok {
+			matches = append(matches, match{symbol: symbol, score: score})
+		}
+	}
+
+	sort.SliceStable(matches, func(i, j int) bool {
+		a, b := matches[i], matches[j]
+		if a.score != b.score {
+			return a.score > b.score
+		}
+		if a.symbol.Name != b.symbol.Name {
+			return a.symbol.Name < b.symbol.Name
+		}
+		if a.symbol.Location.URI != b.symbol.Location.URI {
+			return a.symbol.Location.URI < b.symbol.Location.URI
+		}
+		return a.symbol.Location.Range.Start.Line < b.symbol.Location.Range.Start.Line
+	})
+
+	result := []protocol.SymbolInformation{}
+	for i := 0; i < len(matches) && i < maxWorkspaceSymbols; i++ {
+		result = append(result, matches[i].symbol)
+	}
+	return result, nil
+}
+
+func (s *Server) DidChangeWorkspaceFolders(_ context.Context, params *protocol.DidChangeWorkspaceFoldersParams) error {
+	removed := map[string]bool{}
+	for _, folder := range params.Event.Removed {
+		removed[protocol.DocumentURI(folder.URI).SpanURI().Filename()] = true
+	}
+
+	var roots []string
+	for _, root := range s.workspaceRoots {
+		if !removed[root] {
+			roots = append(roots, root)
+		}
+	}
+	for _, folder := range params.Event.Added {
+		roots = append(roots, protocol.DocumentURI(folder.URI).SpanURI().Filename())
+	}
+	s.workspaceRoots = roots
+
+	s.workspaceSymbols.reset()
+	return nil
+}
+
+// setWorkspaceRoots keeps the folders opened by the client, they are searched for workspace symbols
+func (s *Server) setWorkspaceRoots(params *protocol.ParamInitialize) {
+	defer s.forgetWorkspaceEntrypoints()
+	s.workspaceRoots = nil
+	for _, folder := range params.WorkspaceFolders {
+		s.workspaceRoots = append(s.workspaceRoots, protocol.DocumentURI(folder.URI).SpanURI().Filename())
+	}
+	if len(s.workspaceRoots) == 0 && params.RootURI != "" {
+		s.workspaceRoots = append(s.workspaceRoots, params.RootURI.SpanURI().Filename())
+	}
+}
+
+// buildWorkspaceSymbols walks the workspace roots and the JPaths, and indexes all the Jsonnet files found
+func (s *Server) buildWorkspaceSymbols() {
+	s.workspaceSymbols.buildMu.Lock()
+	defer s.workspaceSymbols.buildMu.Unlock()
+	if s.workspaceSymbols.isBuilt() {
+		return
+	}
+
+	start := time.Now()
+	files := map[string][]protocol.SymbolInformation{}
+
+	roots := append([]string{}, s.workspaceRoots...)
+	roots = append(roots, s.configuration.JPaths...)
+	for _, root := range roots {
+		root, err := filepath.Abs(root)
+		if err != nil {
+			log.Warnf("Symbol: could not resolve the path of %s: %v", root, err)
+			continue
+		}
+		// The VM isn't used to list the symbols, a single one is shared by the files of the root
+		processor := processing.NewProcessor(s.cache, s.getVM(root))
+		err = filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				log.Debugf("Symbol: could not walk %s: %v", path, err)
+				return nil
+			}
+			if entry.IsDir() {
+				if path != root && strings.HasPrefix(entry.Name(), ".") {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if _, ok := files[path]; ok || !isJsonnetFile(path) {
+				return nil
+			}
+
+			text, node, err := s.parseWorkspaceFile(path)
+			if err != nil {
+				log.Debugf("Symbol: could not parse %s: %v", path, err)
+				return nil
+			}
+			files[path] = s.workspaceFileSymbols(processor.WithText(path, text), path, text, node)
+			return nil
+		})
+		if err != nil {
+			log.Warnf("Symbol: could not walk %s: %v", root, err)
+		}
+	}
+
+	s.workspaceSymbols.merge(files)
+	log.Infof("Symbol: indexed %d files in %s", len(files), time.Since(start))
+}
+
+// buildWorkspaceSymbolsInBackground starts building the index, unless it's already built or being built
+func (s *Server) buildWorkspaceSymbolsInBackground() {
+	if s.workspaceSymbols.isBuilt() || !s.workspaceSymbols.building.CompareAndSwap(false, true) {
+		return
+	}
+	go func() {
+		defer s.workspaceSymbols.building.Store(false)
+		s.buildWorkspaceSymbols()
+	}()
+}
+
+// parseWorkspaceFile returns the text and the AST of a file, from the cache if the document is open and parsed.
+// The files that aren't open are read directly, rather than through the cache, since they're only read once
+func (s *Server) parseWorkspaceFile(path string) (string, ast.Node, error) {
+	if doc, err := s.cache.Get(protocol.URIFromPath(path)); err == nil && doc.AST != nil && len(doc.LinesChangedSinceAST) == 0 {
+		return doc.Item.Text, doc.AST, nil
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return "", nil, err
+	}
+	node, err := jsonnet.SnippetToAST(path, string(content))
+	return string(content), node, err
+}
+
+// updateWorkspaceSymbols updates the index with the latest successfully parsed version of a document
+func (s *Server) updateWorkspaceSymbols(uri protocol.DocumentURI, text string, root ast.Node) {
+	if root == nil {
+		return
+	}
+	filename := uri.SpanURI().Filename()
+	if !isJsonnetFile(filename) {
+		return
+	}
+	processor := processing.NewProcessor(s.cache, s.getVM(filename)).WithText(filename, text)
+	s.workspaceSymbols.update(filename, s.workspaceFileSymbols(processor, filename, text, root))
+}
+
+// workspaceFileSymbols flattens the document symbols of a file, parsed from the given text. Fields are contained by the path of their parents.
+// The processor reads the text of the file from the given text, so that the files that aren't open aren't cached
+func (s *Server) workspaceFileSymbols(processor *processing.Processor, filename, text string, root ast.Node) []protocol.SymbolInformation {
+	uri := protocol.URIFromPath(filename)
+
+	var symbols []protocol.SymbolInformation
+	var flatten func(documentSymbols []protocol.DocumentSymbol, container string)
+	flatten = func(documentSymbols []protocol.DocumentSymbol, container string) {
+		for _, symbol := range documentSymbols {
+			symbols = append(symbols, protocol.SymbolInformation{
+				Name:          symbol.Name,
+				Kind:          symbol.Kind,
+				Location:      protocol.Location{URI: uri, Range: symbol.Range},
+				ContainerName: container,
+			})
+			childContainer := symbol.Name
+			if container != "" {
+				childContainer = container + "." + symbol.Name
+			}
+			flatten(symbol.Children, childContainer)
+		}
+	}
+	flatten(s.buildDocumentSymbols(s.converter(text), processor, root), "")
+	return symbols
+}
+
+func isJsonnetFile(path string) bool {
+	ext := filepath.Ext(path)
+	return ext == ".jsonnet" || ext == ".libsonnet"
+}
+
+// fuzzyMatch returns true if all the characters of the query are found in order in the name, ignoring case.
+// The score is higher for matches that are contiguous or start on word boundaries
+func fuzzyMatch(query, name string) (int, bool) {
+	if query == "" {
+		return 0, true
+	}
+
+	queryRunes := []rune(strings.ToLower(query))
+	nameRunes := []rune(name)
+	score, queryIndex, previousMatch := 0, 0, -2
+	for i := 0; i < len(nameRunes) && queryIndex < len(queryRunes); i++ {
+		if unicode.ToLower(nameRunes[i]) != queryRunes[queryIndex] {
+			continue
+		}
+		score++
+		switch {
+		case i == previousMatch+1:
+			score += 3
+		case isWordStart(nameRunes, i):
+			score += 2
+		}
+		previousMatch = i
+		queryIndex++
+	}
+	if queryIndex < len(queryRunes) {
+		return 0, false
+	}
+
+	if strings.EqualFold(query, name) {
+		score += 100
+	} else if strings.HasPrefix(strings.ToLower(name), string(queryRunes)) {
+		score += 50
+	}
+	return score, true
+}
+
+// isWordStart returns true at the start of the name, after a separator or on a camelCase boundary
+func isWordStart(name []rune, i int) bool {
+	if i == 0 {
+		return true
+	}
+	previous := name[i-1]
+	if previous == '_' || previous == '-' || previous == '.' {
+		return true
+	}
+	return unicode.IsUpper(name[i]) && unicode.IsLower(previous)
+}
+
+// updateWorkspaceSymbolsFromDisk updates the index with the file on disk, or removes the file if it doesn't exist anymore.
+// Before the index is built, the file is left for the walk of the workspace
+func (s *Server) updateWorkspaceSymbolsFromDisk(uri protocol.DocumentURI) {
+	filename := uri.SpanURI().Filename()
+	if !s.workspaceSymbols.isBuilt() || !isJsonnetFile(filename) {
+		return
+	}
+	content, err := os.ReadFile(filename)
+	if errors.Is(err, fs.ErrNotExist) {
+		s.workspaceSymbols.remove(filename)
+		return
+	}
+	if err != nil {
+		log.Debugf("Symbol: could not read %s: %v", filename, err)
+		return
+	}
+	root, err := jsonnet.SnippetToAST(filename, string(content))
+	if err != nil {
+		// The symbols of the last successful parse are kept
+		log.Debugf("Symbol: could not parse %s: %v", filename, err)
+		return
+	}
+	s.updateWorkspaceSymbols(uri, string(content), root)
+}
@@ -0,0 +1,200 @@
+package server
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jdbaldry/go-language-server-protocol/lsp/protocol"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// qualifiedSymbolNames returns the names of the symbols, prefixed by their container
+func qualifiedSymbolNames(symbols []protocol.SymbolInformation) []string {
+	names := []string{}
+	for _, symbol := range symbols {
+		name := symbol.Name
+		if symbol.ContainerName != "" {
+			name = symbol.ContainerName + "." + name
+		}
+		names = append(names, name)
+	}
+	return names
+}
+
+func TestWorkspaceSymbol(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		query    string
+		expected []string
+	}{
+		{
+			name:     "empty query",
+			query:    "",
+			expected: []string{"apps", "apps.deployment", "frontend", "lib", "apps.deployment.new", "replicaCount", "apps.deployment.withReplicas"},
+		},
+		{
+			name:     "exact match",
+			query:    "lib",
+			expected: []string{"lib"},
+		},
+		{
+			name:     "case insensitive prefix",
+			query:    "withrep",
+			expected: []string{"apps.deployment.withReplicas"},
+		},
+		{
+			name:     "fuzzy match prefers word starts",
+			query:    "rc",
+			expected: []string{"replicaCount", "apps.deployment.withReplicas"},
+		},
+		{
+			name:     "qualified query",
+			query:    "deployment.new",
+			expected: []string{"apps.deployment.new"},
+		},
+		{
+			name:     "hidden directories are skipped",
+			query:    "ignoredField",
+			expected: []string{},
+		},
+		{
+			name:     "no match",
+			query:    "doesNotExist",
+			expected: []string{},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			server := NewServer("any", "test version", nil, Configuration{})
+			server.setWorkspaceRoots(&protocol.ParamInitialize{
+				InitializeParams: protocol.InitializeParams{RootURI: absURI(t, "testdata/workspace-symbols")},
+			})
+
+			symbols, err := server.Symbol(context.Background(), &protocol.WorkspaceSymbolParams{Query: tc.query})
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, qualifiedSymbolNames(symbols))
+		})
+	}
+}
+
+func TestWorkspaceSymbolLocation(t *testing.T) {
+	server := NewServer("any", "test version", nil, Configuration{
+		JPaths: []string{"testdata/workspace-symbols"},
+	})
+
+	symbols, err := server.Symbol(context.Background(), &protocol.WorkspaceSymbolParams{Query: "withReplicas"})
+	require.NoError(t, err)
+	assert.Equal(t, []protocol.SymbolInformation{
+		{
+			Name: "withReplicas",
+			Kind: protocol.Field,
+			Location: protocol.Location{
+				URI: absURI(t, "testdata/workspace-symbols/deployment.libsonnet"),
+				Range: protocol.Range{
+					Start: protocol.Position{Line: 6, Character: 6},
+					End:   protocol.Position{Line: 8, Character: 7},
+				},
+			},
+			ContainerName: "apps.deployment",
+		},
+	}, symbols)
+}
+
+func TestWorkspaceSymbolNonASCII(t *testing.T) {
+	// The emoji is two UTF-16 code units and four bytes
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "main.jsonnet")
+	require.NoError(t, os.WriteFile(filename, []byte("{ '😀': 1, target: 2 }\n"), 0o600))
+
+	server := NewServer("any", "test version", nil, Configuration{JPaths: []string{dir}})
+	symbols, err := server.Symbol(context.Background(), &protocol.WorkspaceSymbolParams{Query: "target"})
+	require.NoError(t, err)
+	require.Len(t, symbols, 1)
+	assert.Equal(t, protocol.Range{
+		Start: protocol.Position{Line: 0, Character: 11},
+		End:   protocol.Position{Line: 0, Character: 20},
+	}, symbols[0].Location.Range)
+}
+
+func TestWorkspaceSymbolComputedFieldName(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "main.jsonnet")
+	require.NoError(t, os.WriteFile(filename, []byte("{ ['a' + 'b']: 1 }\n"), 0o600))
+
+	server := NewServer("any", "test version", nil, Configuration{JPaths: []string{dir}})
+	symbols, err := server.Symbol(context.Background(), &protocol.WorkspaceSymbolParams{})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"'a' + 'b'"}, qualifiedSymbolNames(symbols))
+
+	// The name is read from the indexed text, the file isn't kept in the cache
+	require.NoError(t, os.WriteFile(filename, []byte("{}\n"), 0o600))
+	text, err := server.cache.GetText(protocol.URIFromPath(filename))
+	require.NoError(t, err)
+	assert.Equal(t, "{}\n", text)
+}
+
+func TestWorkspaceSymbolIncrementalUpdate(t *testing.T) {
+	filename := "testdata/workspace-symbols/main.jsonnet"
+	uri := absURI(t, filename)
+	server := NewServer("any", "test version", nil, Configuration{})
+	server.setWorkspaceRoots(&protocol.ParamInitialize{
+		InitializeParams: protocol.InitializeParams{RootURI: absURI(t, "testdata/workspace-symbols")},
+	})
+
+	search := func(query string) []string {
+		symbols, err := server.Symbol(context.Background(), &protocol.WorkspaceSymbolParams{Query: query})
+		require.NoError(t, err)
+		return qualifiedSymbolNames(symbols)
+	}
+	assert.Equal(t, []string{"frontend"}, search("frontend"))
+
+	content, err := os.ReadFile(filename)
+	require.NoError(t, err)
+	require.NoError(t, server.DidOpen(context.Background(), &protocol.DidOpenTextDocumentParams{
+		TextDocument: protocol.TextDocumentItem{URI: uri, Text: string(content), Version: 1},
+	}))
+
+	// The field is renamed in the open document
+	require.NoError(t, server.DidChange(context.Background(), &protocol.DidChangeTextDocumentParams{
+		TextDocument: protocol.VersionedTextDocumentIdentifier{
+			TextDocumentIdentifier: protocol.TextDocumentIdentifier{URI: uri},
+			Version:                2,
+		},
+		ContentChanges: []protocol.TextDocumentContentChangeEvent{{Text: "{ backend: {} }"}},
+	}))
+	assert.Equal(t, []string{}, search("frontend"))
+	assert.Equal(t, []string{"backend"}, search("backend"))
+
+	// The symbols of the last successful parse are kept while the document is invalid
+	require.NoError(t, server.DidChange(context.Background(), &protocol.DidChangeTextDocumentParams{
+		TextDocument: protocol.VersionedTextDocumentIdentifier{
+			TextDocumentIdentifier: protocol.TextDocumentIdentifier{URI: uri},
+			Version:                3,
+		},
+		ContentChanges: []protocol.TextDocumentContentChangeEvent{{Text: "{ backend: "}},
+	}))
+	assert.Equal(t, []string{"backend"}, search("backend"))
+}
+
+func TestWorkspaceSymbolWorkspaceFolders(t *testing.T) {
+	server := NewServer("any", "test version", nil, Configuration{})
+	search := func() []string {
+		symbols, err := server.Symbol(context.Background(), &protocol.WorkspaceSymbolParams{Query: "replicaCount"})
+		require.NoError(t, err)
+		return qualifiedSymbolNames(symbols)
+	}
+	assert.Equal(t, []string{}, search())
+
+	folder := protocol.WorkspaceFolder{URI: string(absURI(t, "testdata/workspace-symbols")), Name: "workspace-symbols"}
+	require.NoError(t, server.DidChangeWorkspaceFolders(context.Background(), &protocol.DidChangeWorkspaceFoldersParams{
+		Event: protocol.WorkspaceFoldersChangeEvent{Added: []protocol.WorkspaceFolder{folder}},
+	}))
+	assert.Equal(t, []string{"replicaCount"}, search())
+
+	require.NoError(t, server.DidChangeWorkspaceFolders(context.Background(), &protocol.DidChangeWorkspaceFoldersParams{
+		Event: protocol.WorkspaceFoldersChangeEvent{Removed: []protocol.WorkspaceFolder{folder}},
+	}))
+	assert.Equal(t, []string{}, search())
+}
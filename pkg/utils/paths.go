@@ -0,0 +1,12 @@
+package utils
+
+import "path/filepath"
+
+// AbsFilename returns the absolute form of a filename, or the filename itself if it can't be resolved.
+// Filenames are compared in this form, so that the same file resolved from different JPaths or directories matches
+func AbsFilename(filename string) string {
+	if abs, err := filepath.Abs(filename); err == nil {
+		return abs
+	}
+	return filename
+}